@@ -0,0 +1,35 @@
+package v1
+
+import "time"
+
+// Response of the admin route listing the users with stored Build API credentials.
+type ListCredentialsResponse struct {
+	Usernames []string `json:"usernames"`
+}
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// Record of a state changing request.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	User      string    `json:"user"`
+	// What the request did, e.g. "host.delete".
+	Action string `json:"action"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Zone   string `json:"zone,omitempty"`
+	Host   string `json:"host,omitempty"`
+	// Either AuditOutcomeSuccess or AuditOutcomeFailure.
+	Outcome    string `json:"outcome"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+type ListAuditEntriesResponse struct {
+	// Most recent entries first.
+	Items []*AuditEntry `json:"items"`
+}
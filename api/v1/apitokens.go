@@ -0,0 +1,24 @@
+package v1
+
+type CreateAPITokenRequest struct {
+	// Describes what the token is used for, e.g. "presubmit-ci".
+	Name string `json:"name"`
+	// Lifetime of the token in seconds, the token never expires if zero.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
+
+// API tokens authenticate the requests of non-interactive clients, sent in the `Authorization: Bearer` header.
+type APIToken struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// The secret token, only returned on creation as just a hash of it is stored.
+	Token        string `json:"token,omitempty"`
+	CreationTime string `json:"creation_time"`
+	// Absent if the token never expires.
+	ExpirationTime string `json:"expiration_time,omitempty"`
+}
+
+type ListAPITokensResponse struct {
+	// Oldest first.
+	Items []*APIToken `json:"items"`
+}
@@ -1,8 +1,24 @@
 package v1
 
+import "strings"
+
 type CreateHostRequest struct {
 	// [REQUIRED]
 	HostInstance *HostInstance `json:"host_instance"`
+	// Time to live of the host in seconds. The host is automatically deleted once it expires. The host never
+	// expires if not set.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+	// User defined labels. Keys must start with a lowercase letter, keys and values may contain only lowercase
+	// letters, digits, underscores and dashes, up to 63 characters. Keys starting with `cf-` are reserved.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Group owning the host along with its creator, members of the group can use and delete the host. The
+	// creator must be a member of the group. Same format as label values.
+	Group string `json:"group,omitempty"`
+}
+
+type ExtendHostRequest struct {
+	// [REQUIRED] New time to live of the host in seconds, counted from the time the request is handled.
+	TTLSeconds int64 `json:"ttl_seconds"`
 }
 
 type Zone struct {
@@ -10,20 +26,70 @@ type Zone struct {
 }
 
 type HostInstance struct {
-	// [Output Only] Instance name.
+	// [Output Only] Instance name, unique within its zone.
 	Name string `json:"name,omitempty"`
+	// [Output Only] Zone the host lives in.
+	Zone string `json:"zone,omitempty"`
+	// [Output Only] Globally unique identifier of the host, it can be used instead of the name in any host
+	// route, e.g. `/v1/hosts/{handle}/cvds`, without knowing the zone of the host.
+	Handle string `json:"handle,omitempty"`
 	// [Output Only] Boot disk size in GB.
 	BootDiskSizeGB int64 `json:"boot_disk_size_gb,omitempty"`
+	// [Output Only] Time at which the host is automatically deleted, in RFC 3339 format. Empty if the host
+	// never expires.
+	ExpirationTime string `json:"expiration_time,omitempty"`
+	// [Output Only] Lifecycle status of the host, one of the HostStatus* values.
+	Status string `json:"status,omitempty"`
+	// [Output Only] Creation time in RFC 3339 format.
+	CreationTime string `json:"creation_time,omitempty"`
+	// [Output Only] Username of the user who created the host.
+	Owner string `json:"owner,omitempty"`
+	// [Output Only] Group owning the host, if any.
+	Group string `json:"group,omitempty"`
+	// [Output Only] IP address of the host in its private network.
+	InternalIP string `json:"internal_ip,omitempty"`
+	// [Output Only] Public IP address of the host, empty if it has none.
+	ExternalIP string `json:"external_ip,omitempty"`
+	// [Output Only] User defined labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// [Output Only] Whether the host orchestrator of a running host answered the latest health check, the host
+	// can't be used until then. Listed hosts are checked in the background, it may take a few seconds to be set.
+	Ready bool `json:"ready,omitempty"`
 	// GCP specific properties.
 	GCP *GCPInstance `json:"gcp,omitempty"`
 }
 
+// Host lifecycle statuses.
+const (
+	HostStatusProvisioning = "PROVISIONING"
+	HostStatusStaging      = "STAGING"
+	HostStatusRunning      = "RUNNING"
+	HostStatusStopping     = "STOPPING"
+	HostStatusStopped      = "STOPPED"
+	HostStatusSuspending   = "SUSPENDING"
+	HostStatusSuspended    = "SUSPENDED"
+	HostStatusRepairing    = "REPAIRING"
+	HostStatusTerminated   = "TERMINATED"
+)
+
 type GCPInstance struct {
 	// [REQUIRED] Specifies the machine type of the VM Instance.
 	// Check https://cloud.google.com/compute/docs/regions-zones#available for available values.
 	MachineType string `json:"machine_type"`
 	// Specifies a minimum CPU platform for the VM instance.
 	MinCPUPlatform string `json:"min_cpu_platform"`
+	// Boot disk size in GB, up to the maximum allowed by the service. The service default is used if not set.
+	BootDiskSizeGB int64 `json:"boot_disk_size_gb,omitempty"`
+	// Subnetwork to attach the VM instance to, it must be allowed by the service. The service default is used
+	// if not set.
+	Subnetwork string `json:"subnetwork,omitempty"`
+	// Network tags added to the VM instance, they must be allowed by the service.
+	NetworkTags []string `json:"network_tags,omitempty"`
+	// If true, the VM instance is created without an external IP address.
+	NoExternalIP bool `json:"no_external_ip,omitempty"`
+	// If true, the VM instance is provisioned as a Spot VM, which is cheaper but can be stopped at any time.
+	// It must be allowed by the service.
+	Spot bool `json:"spot,omitempty"`
 }
 
 type Operation struct {
@@ -65,3 +131,21 @@ type ListHostsResponse struct {
 type Config struct {
 	InstanceManagerType string `json:"instance_manager_type"`
 }
+
+// Separates the zone from the host name in host handles. Zone and host names can't contain it.
+const hostHandleSep = "~"
+
+// Returns the handle of the host with the given name in the given zone, e.g. `us-central1-a~cf-1234`.
+func HostHandle(zone, host string) string {
+	return zone + hostHandleSep + host
+}
+
+// Extracts the zone and host name from a host handle. Returns false if the value is not a host handle, like
+// a plain host name.
+func ParseHostHandle(handle string) (zone string, host string, ok bool) {
+	zone, host, ok = strings.Cut(handle, hostHandleSep)
+	if !ok || zone == "" || host == "" {
+		return "", "", false
+	}
+	return zone, host, true
+}
@@ -0,0 +1,118 @@
+package v1
+
+import "encoding/json"
+
+// Protocol spoken by the orchestrator to instance manager plugins over HTTP. Every method of the instance
+// manager maps to a `POST <endpoint>/<method>` request, e.g. `POST http://localhost:9090/CreateHost`, whose
+// JSON body and successful response are described next to the method name below. Errors are reported with a
+// non 2xx status code, which is passed on to the orchestrator clients, and an Error body.
+const (
+	// PluginListZonesRequest -> ListZonesResponse
+	PluginMethodListZones = "ListZones"
+	// PluginCreateHostRequest -> Operation
+	PluginMethodCreateHost = "CreateHost"
+	// PluginListHostsRequest -> ListHostsResponse
+	PluginMethodListHosts = "ListHosts"
+	// PluginHostRequest -> Operation
+	PluginMethodDeleteHost = "DeleteHost"
+	// PluginExtendHostRequest -> Operation, the operation result is the updated HostInstance.
+	PluginMethodExtendHost = "ExtendHost"
+	// PluginDeleteExpiredHostsRequest -> empty object
+	PluginMethodDeleteExpiredHosts = "DeleteExpiredHosts"
+	// PluginHostRequest -> Operation
+	PluginMethodStopHost = "StopHost"
+	// PluginHostRequest -> Operation
+	PluginMethodStartHost = "StartHost"
+	// PluginHostRequest -> Operation
+	PluginMethodSuspendHost = "SuspendHost"
+	// PluginHostRequest -> Operation
+	PluginMethodResumeHost = "ResumeHost"
+	// PluginWaitOperationRequest -> PluginWaitOperationResponse
+	PluginMethodWaitOperation = "WaitOperation"
+	// PluginHostRequest -> empty object, an error status code if the user can't access the host.
+	PluginMethodAuthorizeHostAccess = "AuthorizeHostAccess"
+	// PluginGetHostURLRequest -> PluginGetHostURLResponse
+	PluginMethodGetHostURL = "GetHostURL"
+)
+
+type PluginListZonesRequest struct{}
+
+type PluginCreateHostRequest struct {
+	Zone string `json:"zone"`
+	// Username of the user creating the host, who owns it. The user is a member of the group requested to own
+	// the host, if any.
+	User    string             `json:"user"`
+	Request *CreateHostRequest `json:"request"`
+}
+
+type PluginListHostsRequest struct {
+	Zone string `json:"zone"`
+	// Only hosts owned by this user or any of their groups must be listed.
+	User       string   `json:"user"`
+	Groups     []string `json:"groups,omitempty"`
+	MaxResults uint32   `json:"max_results,omitempty"`
+	PageToken  string   `json:"page_token,omitempty"`
+	// Only hosts with this status must be listed, any status if empty.
+	Status string `json:"status,omitempty"`
+	// Only hosts whose labels match every requirement must be listed.
+	LabelSelector []PluginLabelRequirement `json:"label_selector,omitempty"`
+	// The hosts of every user must be listed, regardless of User and Groups.
+	AllUsers bool `json:"all_users,omitempty"`
+}
+
+type PluginLabelRequirement struct {
+	Key string `json:"key"`
+	// One of "=", "!=" or "" meaning the label must exist.
+	Operator string `json:"operator,omitempty"`
+	Value    string `json:"value,omitempty"`
+}
+
+// Request of the methods taking only a host. Hosts not owned by the user or any of their groups must be
+// reported as not found, unless the user is an admin.
+type PluginHostRequest struct {
+	Zone   string   `json:"zone"`
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// Whether the user has access to every host, only set on the administration routes.
+	Admin bool   `json:"admin,omitempty"`
+	Host  string `json:"host"`
+}
+
+type PluginExtendHostRequest struct {
+	Zone    string             `json:"zone"`
+	User    string             `json:"user"`
+	Groups  []string           `json:"groups,omitempty"`
+	Admin   bool               `json:"admin,omitempty"`
+	Host    string             `json:"host"`
+	Request *ExtendHostRequest `json:"request"`
+}
+
+type PluginDeleteExpiredHostsRequest struct{}
+
+type PluginWaitOperationRequest struct {
+	Zone string `json:"zone"`
+	// Operations neither started by the user nor on hosts owned by any of their groups must be reported as
+	// not found, unless the user is an admin.
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// Whether the user has access to every operation, only set on the administration routes.
+	Admin     bool   `json:"admin,omitempty"`
+	Operation string `json:"operation"`
+}
+
+type PluginWaitOperationResponse struct {
+	// The result of the operation, the HostInstance for operations on a single host other than deletions. Host
+	// instances, recognized by their name, are completed by the orchestrator with their zone and readiness, any
+	// other result is returned as is to the orchestrator clients.
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type PluginGetHostURLRequest struct {
+	Zone string `json:"zone"`
+	Host string `json:"host"`
+}
+
+type PluginGetHostURLResponse struct {
+	// Base URL of the host orchestrator running in the host, e.g. "http://10.0.0.5:1080".
+	URL string `json:"url"`
+}
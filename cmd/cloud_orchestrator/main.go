@@ -16,56 +16,127 @@ package main
 
 import (
 	"context"
-	"log"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/google/cloud-android-orchestration/pkg/app"
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
 	"github.com/google/cloud-android-orchestration/pkg/app/config"
 	"github.com/google/cloud-android-orchestration/pkg/app/database"
 	"github.com/google/cloud-android-orchestration/pkg/app/encryption"
 	"github.com/google/cloud-android-orchestration/pkg/app/instances"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
 	"github.com/google/cloud-android-orchestration/pkg/app/secrets"
 
 	"github.com/google/uuid"
-	"google.golang.org/api/compute/v1"
 )
 
+// Logs the error and exits.
+func fatal(msg string, keyvals ...any) {
+	logging.Default().Error(msg, keyvals...)
+	os.Exit(1)
+}
+
 func LoadConfiguration() *config.Config {
 	config, err := config.LoadConfig()
 	if err != nil {
-		log.Fatal("Failed to load configuration: ", err)
+		fatal("Failed to load configuration", "error", err)
 	}
-	log.Println("Main Configuration:")
-	log.Println("  Instance Manager Type: " + config.InstanceManager.Type)
+	SetupLogging(config)
+	keyvals := []any{"instance_manager_type", config.InstanceManager.Type}
 	if config.InstanceManager.Type == instances.GCEIMType {
-		log.Println("  GCP Project: " + config.InstanceManager.GCP.ProjectID)
+		keyvals = append(keyvals, "gcp_project", config.InstanceManager.GCP.ProjectID)
 	}
+	logging.Default().Info("Main configuration", keyvals...)
 	return config
 }
 
+// Makes every log entry, the standard log package ones included, a structured JSON entry.
+func SetupLogging(config *config.Config) {
+	level, err := logging.ParseLevel(config.Logging.Level)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	logging.SetDefault(logging.New(os.Stderr, level))
+}
+
 func LoadInstanceManager(config *config.Config) instances.Manager {
 	var im instances.Manager
 	switch config.InstanceManager.Type {
 	case instances.GCEIMType:
-		service, err := compute.NewService(context.Background())
+		service, err := instances.NewInstrumentedComputeService(context.Background())
 		if err != nil {
-			log.Fatal(err)
+			fatal("Failed to create the compute service", "error", err)
+		}
+		// Fails early on broken startup script templates rather than on every host creation.
+		if _, err := instances.ParseStartupScriptTemplates(config.InstanceManager.GCP.StartupScriptTemplates); err != nil {
+			fatal("Invalid startup script templates", "error", err)
 		}
 		nameGenerator := &instances.InstanceNameGenerator{
 			UUIDFactory: func() string { return uuid.New().String() },
 		}
-		im = instances.NewGCEInstanceManager(config.InstanceManager, service, nameGenerator)
+		gim := instances.NewGCEInstanceManager(config.InstanceManager, service, nameGenerator)
+		if len(config.InstanceManager.GCP.WarmPools) > 0 && config.InstanceManager.GCP.RefillWarmPools {
+			instances.NewWarmPoolRefiller(gim).Start()
+		}
+		im = gim
 	case instances.UnixIMType:
 		im = instances.NewLocalInstanceManager(config.InstanceManager)
+	case instances.ProcessIMType:
+		nameGenerator := &instances.InstanceNameGenerator{
+			UUIDFactory: func() string { return uuid.New().String() },
+		}
+		pim, err := instances.NewProcessInstanceManager(config.InstanceManager, nameGenerator)
+		if err != nil {
+			fatal("Failed to create the process instance manager", "error", err)
+		}
+		im = pim
+	case instances.PluginIMType:
+		pim, err := instances.NewPluginInstanceManager(config.InstanceManager)
+		if err != nil {
+			fatal("Failed to create the plugin instance manager", "error", err)
+		}
+		im = pim
 	default:
-		log.Fatal("Unknown Instance Manager type: ", config.InstanceManager.Type)
+		fatal("Unknown Instance Manager type", "type", config.InstanceManager.Type)
 	}
 	return im
 }
 
+func StartHostReaper(config *config.Config, im instances.Manager) {
+	interval := config.InstanceManager.HostReaperIntervalMinutes
+	if interval <= 0 {
+		logging.Default().Warn("Expired hosts will not be deleted: host reaper disabled")
+		return
+	}
+	instances.NewHostReaper(im, time.Duration(interval)*time.Minute).Start()
+}
+
+// Releases the resources held by the instance manager, e.g. local host processes, when the server is
+// asked to exit.
+func CloseOnShutdown(im instances.Manager) {
+	closer, ok := im.(io.Closer)
+	if !ok {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		logging.Default().Info("Shutting down", "signal", sig.String())
+		if err := closer.Close(); err != nil {
+			logging.Default().Error("Failed to close instance manager", "error", err)
+		}
+		os.Exit(0)
+	}()
+}
+
 func LoadSecretManager(config *config.Config) secrets.SecretManager {
 	var sm secrets.SecretManager
 	switch config.SecretManager.Type {
@@ -73,16 +144,16 @@ func LoadSecretManager(config *config.Config) secrets.SecretManager {
 		var err error
 		sm, err = secrets.NewGCPSecretManager(config.SecretManager.GCP)
 		if err != nil {
-			log.Fatal("Failed to build Secret Manager: ", err)
+			fatal("Failed to build Secret Manager", "error", err)
 		}
 	case secrets.UnixSMType:
 		var err error
 		sm, err = secrets.NewFromFileSecretManager(config.SecretManager.UNIX.SecretFilePath)
 		if err != nil {
-			log.Fatal(err)
+			fatal("Failed to build Secret Manager", "error", err)
 		}
 	default:
-		log.Fatal("Unknown Secret Manager type: ", config.SecretManager.Type)
+		fatal("Unknown Secret Manager type", "type", config.SecretManager.Type)
 	}
 	return sm
 }
@@ -93,20 +164,59 @@ func LoadOAuth2Config(config *config.Config, sm secrets.SecretManager) *appOAuth
 	case appOAuth2.GoogleOAuth2Provider:
 		oauth2Helper = appOAuth2.NewGoogleOAuth2Helper(config.AccountManager.OAuth2.RedirectURL, sm)
 	default:
-		log.Fatal("Unknown oauth2 provider: ", config.AccountManager.OAuth2.Provider)
+		fatal("Unknown oauth2 provider", "provider", config.AccountManager.OAuth2.Provider)
 	}
 	return oauth2Helper
 }
 
-func LoadAccountManager(config *config.Config) accounts.Manager {
+func LoadAccountManager(config *config.Config, dbs database.Service) accounts.Manager {
+	usernames := config.AccountManager.Usernames
+	if err := usernames.Validate(); err != nil {
+		fatal("Invalid usernames configuration", "error", err)
+	}
 	var am accounts.Manager
 	switch config.AccountManager.Type {
 	case accounts.GAEAMType:
-		am = accounts.NewGAEUsersAccountManager()
+		am = accounts.NewGAEUsersAccountManager(usernames)
 	case accounts.UnixAMType:
 		am = accounts.NewUnixAccountManager()
+	case accounts.OIDCAMType:
+		if config.AccountManager.OIDC == nil {
+			fatal("Missing OIDC account manager configuration")
+		}
+		oidc, err := accounts.NewOIDCAccountManager(*config.AccountManager.OIDC, usernames)
+		if err != nil {
+			fatal("Failed to create the OIDC account manager", "error", err)
+		}
+		am = oidc
+	case accounts.IAPAMType:
+		if config.AccountManager.IAP == nil {
+			fatal("Missing IAP account manager configuration")
+		}
+		iap, err := accounts.NewIAPAccountManager(*config.AccountManager.IAP, usernames)
+		if err != nil {
+			fatal("Failed to create the IAP account manager", "error", err)
+		}
+		am = iap
+	case accounts.HtpasswdAMType:
+		if config.AccountManager.Htpasswd == nil {
+			fatal("Missing htpasswd account manager configuration")
+		}
+		htpasswd, err := accounts.NewHtpasswdAccountManager(*config.AccountManager.Htpasswd)
+		if err != nil {
+			fatal("Failed to create the htpasswd account manager", "error", err)
+		}
+		am = htpasswd
 	default:
-		log.Fatal("Unknown Account Manager type: ", config.AccountManager.Type)
+		fatal("Unknown Account Manager type", "type", config.AccountManager.Type)
+	}
+	if len(config.AccountManager.Admission.AllowedDomains) > 0 &&
+		(config.AccountManager.Type == accounts.UnixAMType || config.AccountManager.Type == accounts.HtpasswdAMType) {
+		logging.Default().Warn("The account manager doesn't know the users' emails, only the allowed users are admitted",
+			"type", config.AccountManager.Type)
+	}
+	if config.AccountManager.APITokens != nil {
+		am = accounts.NewAPITokenAccountManager(dbs, am)
 	}
 	return am
 }
@@ -119,7 +229,7 @@ func LoadEncryptionService(config *config.Config) encryption.Service {
 	case encryption.GCPKMSESType:
 		es = encryption.NewGCPKMSEncryptionService(config.EncryptionService.GCPKMS.KeyName)
 	default:
-		log.Fatal("Unknown encryption service type: ", config.EncryptionService.Type)
+		fatal("Unknown encryption service type", "type", config.EncryptionService.Type)
 	}
 	return es
 }
@@ -132,9 +242,29 @@ func LoadDatabaseService(config *config.Config) database.Service {
 	case database.SpannerDBType:
 		dbs = database.NewSpannerDBService(config.DatabaseService.Spanner.DatabaseName)
 	default:
-		log.Fatal("Unknown database service type: ", config.DatabaseService.Type)
+		fatal("Unknown database service type", "type", config.DatabaseService.Type)
+	}
+	return database.NewInstrumentedService(dbs)
+}
+
+// Returns nil if audit logging is disabled.
+func LoadAuditSink(config *config.Config, dbs database.Service) audit.Sink {
+	switch config.Audit.Type {
+	case "":
+		logging.Default().Warn("State changing requests will not be recorded: audit logging disabled")
+		return nil
+	case audit.JSONLSinkType:
+		sink, err := audit.NewJSONLSink(config.Audit.JSONL.Path)
+		if err != nil {
+			fatal("Failed to create the audit sink", "error", err)
+		}
+		return sink
+	case audit.DatabaseSinkType:
+		return dbs
+	default:
+		fatal("Unknown audit sink type", "type", config.Audit.Type)
 	}
-	return dbs
+	return nil
 }
 
 // The network interface for the web server to listen on.
@@ -145,6 +275,11 @@ func ChooseNetworkInterface(config *config.Config) string {
 		// interface only.
 		return "localhost"
 	}
+	if config.AccountManager.Type == accounts.HtpasswdAMType && config.TLS == nil {
+		// Passwords travel in clear text without TLS, only listen on the loopback interface unless the
+		// server is served over HTTPS.
+		return "localhost"
+	}
 	// Empty means all interfaces, which is the right choice in production.
 	return ""
 }
@@ -153,7 +288,7 @@ func ServerPort() string {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
-		log.Printf("Defaulting to port %s", port)
+		logging.Default().Info("Defaulting to port", "port", port)
 	}
 	return port
 }
@@ -162,17 +297,26 @@ func main() {
 	config := LoadConfiguration()
 
 	instanceManager := LoadInstanceManager(config)
+	StartHostReaper(config, instanceManager)
+	CloseOnShutdown(instanceManager)
 	secretManager := LoadSecretManager(config)
 	oauth2Helper := LoadOAuth2Config(config, secretManager)
-	accountManager := LoadAccountManager(config)
 	encryptionService := LoadEncryptionService(config)
 	dbService := LoadDatabaseService(config)
+	accountManager := LoadAccountManager(config, dbService)
+	auditSink := LoadAuditSink(config, dbService)
 	controller := app.NewApp(instanceManager, accountManager, oauth2Helper,
-		encryptionService, dbService, config.WebStaticFilesPath, config.CORSAllowedOrigins, config.WebRTC, config)
+		encryptionService, dbService, auditSink, config.WebStaticFilesPath, config.CORSAllowedOrigins, config.WebRTC, config)
 
 	iface := ChooseNetworkInterface(config)
 	port := ServerPort()
 
-	log.Printf("Listening on port %s", port)
-	log.Fatal(http.ListenAndServe(iface+":"+port, controller.Handler()))
+	logging.Default().Info("Listening", "port", port)
+	var err error
+	if config.TLS != nil {
+		err = http.ListenAndServeTLS(iface+":"+port, config.TLS.CertFile, config.TLS.KeyFile, controller.Handler())
+	} else {
+		err = http.ListenAndServe(iface+":"+port, controller.Handler())
+	}
+	fatal("Server stopped", "error", err)
 }
@@ -0,0 +1,33 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Runs the stub instance manager plugin, use it with the "plugin" instance manager type.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/instances/pluginstub"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9090", "Address to listen on")
+	hostURL := flag.String("host_url", "http://127.0.0.1:1081", "Host orchestrator URL returned for every host")
+	flag.Parse()
+
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, pluginstub.NewStub(*hostURL)))
+}
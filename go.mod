@@ -18,6 +18,7 @@ require (
 	github.com/pion/webrtc/v3 v3.1.47
 	github.com/sergi/go-diff v1.2.0
 	github.com/spf13/cobra v1.6.1
+	golang.org/x/crypto v0.7.0
 	golang.org/x/oauth2 v0.8.0
 	golang.org/x/term v0.8.0
 	google.golang.org/api v0.118.0
@@ -60,7 +61,6 @@ require (
 	github.com/pion/udp v0.1.4 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	go.opencensus.io v0.24.0 // indirect
-	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
@@ -16,6 +16,7 @@ package accounts
 
 import (
 	"net/http"
+	"sort"
 
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
 )
@@ -24,6 +25,35 @@ type User interface {
 	Username() string
 }
 
+// Implemented by users belonging to groups. Members of a group have access to the hosts owned by the group.
+type GroupMember interface {
+	Groups() []string
+}
+
+// Implemented by users with a configured role, see Config.Roles.
+type RoleHolder interface {
+	Role() Role
+}
+
+type Role string
+
+const (
+	// Can use the administration routes to list and delete the hosts of every user. Elsewhere admins only have
+	// access to the hosts they own, like any other user.
+	RoleAdmin Role = "admin"
+	// Can create hosts and manage the hosts they have access to.
+	RoleUser Role = "user"
+	// Can only list and inspect the hosts they have access to.
+	RoleReadOnly Role = "read-only"
+)
+
+// Roles grant every permission of the lower ranked roles. Unknown roles rank the lowest.
+var roleRanks = map[Role]int{
+	RoleReadOnly: 1,
+	RoleUser:     2,
+	RoleAdmin:    3,
+}
+
 type Manager interface {
 	// Gets the user from the http request, typically from a cookie or another header.
 	UserFromRequest(r *http.Request) (User, error)
@@ -37,4 +67,136 @@ type AMType string
 type Config struct {
 	Type   AMType
 	OAuth2 appOAuth2.OAuth2Config
+	// Usernames of the members of each group, by group name. Users also belong to the groups reported by the
+	// account manager.
+	Groups map[string][]string
+	// Roles of the users, by username. Account managers don't report roles, users without a configured role
+	// are RoleUser.
+	Roles map[string]Role
+	// How usernames are derived from the users' emails.
+	Usernames UsernameConfig
+	// Which authenticated users are allowed to use the service, every user if empty.
+	Admission AdmissionConfig
+	// Requests with an API token are accepted besides the ones authenticated by the account manager if not nil.
+	APITokens *APITokensConfig
+	// Required by the OIDC account manager.
+	OIDC *OIDCConfig
+	// Required by the IAP account manager.
+	IAP *IAPConfig
+	// Required by the htpasswd account manager.
+	Htpasswd *HtpasswdConfig
+}
+
+// Returns the groups the user belongs to.
+func UserGroups(user User) []string {
+	if m, ok := user.(GroupMember); ok {
+		return m.Groups()
+	}
+	return nil
+}
+
+func IsGroupMember(user User, group string) bool {
+	if group == "" {
+		return false
+	}
+	for _, g := range UserGroups(user) {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the role of the user, RoleUser if none is configured.
+func UserRole(user User) Role {
+	if h, ok := user.(RoleHolder); ok && h.Role() != "" {
+		return h.Role()
+	}
+	return RoleUser
+}
+
+// Whether the user's role grants the permissions of the given role.
+func HasRole(user User, role Role) bool {
+	return roleRanks[UserRole(user)] >= roleRanks[role] && roleRanks[role] > 0
+}
+
+type configuredUser struct {
+	User
+	groups []string
+	role   Role
+}
+
+func (u *configuredUser) Groups() []string {
+	return u.groups
+}
+
+func (u *configuredUser) Role() Role {
+	return u.role
+}
+
+func (u *configuredUser) Email() string {
+	return UserEmail(u.User)
+}
+
+// Returns the user with the groups and role from the given configuration. Configured groups are added to the
+// user's own groups.
+func ConfigureUser(user User, cfg *Config) User {
+	var added []string
+	for group, members := range cfg.Groups {
+		if IsGroupMember(user, group) {
+			continue
+		}
+		for _, m := range members {
+			if m == user.Username() {
+				added = append(added, group)
+				break
+			}
+		}
+	}
+	role, hasRole := cfg.Roles[user.Username()]
+	if len(added) == 0 && !hasRole {
+		return user
+	}
+	if !hasRole {
+		role = UserRole(user)
+	}
+	sort.Strings(added)
+	return &configuredUser{
+		User:   user,
+		groups: append(append([]string{}, UserGroups(user)...), added...),
+		role:   role,
+	}
+}
+
+type adminUser struct {
+	User
+}
+
+func (u *adminUser) Groups() []string {
+	return UserGroups(u.User)
+}
+
+func (u *adminUser) Role() Role {
+	return UserRole(u.User)
+}
+
+func (u *adminUser) Email() string {
+	return UserEmail(u.User)
+}
+
+// Returns the user acting with its admin rights on other users' hosts. Only the administration routes act with
+// admin rights, so admins can't reach the devices of other users through the regular routes.
+func WithAdminRights(user User) User {
+	return &adminUser{User: user}
+}
+
+// Whether the user is an admin acting with its admin rights, see WithAdminRights.
+func HasAdminRights(user User) bool {
+	_, ok := user.(*adminUser)
+	return ok && HasRole(user, RoleAdmin)
+}
+
+// Returns the user with the groups it's a member of in the given configuration added to its own groups.
+func WithConfiguredGroups(user User, groups map[string][]string) User {
+	return ConfigureUser(user, &Config{Groups: groups})
 }
@@ -0,0 +1,79 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"strings"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+)
+
+// Implemented by users whose email is known to the account manager.
+type EmailHolder interface {
+	Email() string
+}
+
+// Decides which authenticated users are allowed to use the service. Users are listed by email or username.
+// Users authenticated with API tokens are admitted as the email of the token's creator. Users without a known
+// email, like the htpasswd account manager's, can't be admitted by domain and need to be in AllowedUsers when
+// AllowedDomains is set.
+type AdmissionConfig struct {
+	// Users in these domains are admitted. Every user is admitted if neither this nor AllowedUsers are set.
+	AllowedDomains []string
+	// Users admitted regardless of their domain.
+	AllowedUsers []string
+	// Users never admitted, it takes precedence over the other settings.
+	DeniedUsers []string
+}
+
+// Returns the user's email, the empty string if unknown.
+func UserEmail(user User) string {
+	if h, ok := user.(EmailHolder); ok {
+		return h.Email()
+	}
+	return ""
+}
+
+// Returns a forbidden error if the configuration doesn't admit the user.
+func Admit(user User, cfg *AdmissionConfig) error {
+	email := UserEmail(user)
+	if listed(user.Username(), email, cfg.DeniedUsers) {
+		return apperr.NewForbiddenError("User is not allowed to use this service", nil)
+	}
+	if len(cfg.AllowedDomains) == 0 && len(cfg.AllowedUsers) == 0 {
+		return nil
+	}
+	if listed(user.Username(), email, cfg.AllowedUsers) {
+		return nil
+	}
+	if domain := emailDomain(email); domain != "" {
+		for _, d := range cfg.AllowedDomains {
+			if strings.EqualFold(d, domain) {
+				return nil
+			}
+		}
+	}
+	return apperr.NewForbiddenError("User is not allowed to use this service", nil)
+}
+
+// Emails are compared ignoring case.
+func listed(username, email string, users []string) bool {
+	for _, u := range users {
+		if u == username || (email != "" && strings.EqualFold(u, email)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+)
+
+func TestAdmit(t *testing.T) {
+	alice := &GAEUser{username: "alice", email: "alice@ourcorp.com"}
+	bob := &GAEUser{username: "bob__partner_com", email: "Bob@partner.com"}
+	token := &APITokenUser{username: "bob__partner_com"}
+	aliceToken := &APITokenUser{username: "alice", email: "alice@ourcorp.com"}
+	tests := []struct {
+		name     string
+		cfg      AdmissionConfig
+		admitted []User
+		rejected []User
+	}{
+		{
+			name:     "empty",
+			admitted: []User{alice, bob, token},
+		},
+		{
+			name:     "allowed domains",
+			cfg:      AdmissionConfig{AllowedDomains: []string{"OurCorp.com"}},
+			admitted: []User{alice, aliceToken},
+			rejected: []User{bob, token},
+		},
+		{
+			name:     "allowed users",
+			cfg:      AdmissionConfig{AllowedDomains: []string{"ourcorp.com"}, AllowedUsers: []string{"bob@partner.com"}},
+			admitted: []User{alice, bob},
+			rejected: []User{token},
+		},
+		{
+			name:     "allowed usernames",
+			cfg:      AdmissionConfig{AllowedUsers: []string{"bob__partner_com"}},
+			admitted: []User{bob, token},
+			rejected: []User{alice},
+		},
+		{
+			name:     "denied users",
+			cfg:      AdmissionConfig{AllowedDomains: []string{"partner.com"}, DeniedUsers: []string{"bob__partner_com"}},
+			rejected: []User{alice, bob, token},
+		},
+		{
+			name:     "denied emails",
+			cfg:      AdmissionConfig{DeniedUsers: []string{"bob@partner.com"}},
+			admitted: []User{alice, token},
+			rejected: []User{bob},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, u := range tc.admitted {
+				if err := Admit(u, &tc.cfg); err != nil {
+					t.Errorf("expected %q to be admitted, got: %v", u.Username(), err)
+				}
+			}
+			for _, u := range tc.rejected {
+				var appErr *apperr.AppError
+				if err := Admit(u, &tc.cfg); !errors.As(err, &appErr) || appErr.StatusCode != http.StatusForbidden {
+					t.Errorf("expected %q to be rejected, got: %v", u.Username(), err)
+				}
+			}
+		})
+	}
+}
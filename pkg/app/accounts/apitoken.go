@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+)
+
+type APITokensConfig struct {
+	// Longest lifetime of the new tokens in days, tokens may never expire if zero.
+	MaxTTLDays int
+}
+
+// Implements the Manager interface authenticating the requests with an API token in the `Authorization: Bearer`
+// header. Other requests, those with other bearer tokens included, are authenticated by the wrapped account
+// manager.
+type APITokenAccountManager struct {
+	store    apitokens.Store
+	fallback Manager
+}
+
+func NewAPITokenAccountManager(store apitokens.Store, fallback Manager) *APITokenAccountManager {
+	return &APITokenAccountManager{store: store, fallback: fallback}
+}
+
+func (m *APITokenAccountManager) UserFromRequest(r *http.Request) (User, error) {
+	token, ok := BearerToken(r)
+	if !ok || !apitokens.IsToken(token) {
+		return m.fallback.UserFromRequest(r)
+	}
+	t, err := m.store.FetchAPITokenByHash(apitokens.Hash(token))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch API token: %w", err)
+	}
+	if t == nil || t.Expired(time.Now()) {
+		return nil, apperr.NewUnauthenticatedError("Invalid or expired API token", nil)
+	}
+	return &APITokenUser{username: t.Username, email: t.Email, tokenID: t.ID}, nil
+}
+
+func (m *APITokenAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (User, error) {
+	return m.fallback.OnOAuth2Exchange(w, r, tk)
+}
+
+type APITokenUser struct {
+	username string
+	email    string
+	tokenID  string
+}
+
+func (u *APITokenUser) Username() string {
+	return u.username
+}
+
+// The email of the user who created the token, empty if unknown.
+func (u *APITokenUser) Email() string {
+	return u.email
+}
+
+// The id of the token the user authenticated with.
+func (u *APITokenUser) TokenID() string {
+	return u.tokenID
+}
+
+// Whether the user authenticated with an API token.
+func IsAPITokenUser(user User) bool {
+	if u, ok := user.(*adminUser); ok {
+		user = u.User
+	}
+	if u, ok := user.(*configuredUser); ok {
+		user = u.User
+	}
+	_, ok := user.(*APITokenUser)
+	return ok
+}
+
+// Returns the token of the request's `Authorization: Bearer` header, false if it has none.
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
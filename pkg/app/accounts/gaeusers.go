@@ -17,8 +17,8 @@ package accounts
 import (
 	"fmt"
 	"net/http"
-	"strings"
 
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
 )
 
@@ -28,10 +28,12 @@ const (
 	emailHeaderKey = "X-Appengine-User-Email"
 )
 
-type GAEUsersAccountManager struct{}
+type GAEUsersAccountManager struct {
+	usernames UsernameConfig
+}
 
-func NewGAEUsersAccountManager() *GAEUsersAccountManager {
-	return &GAEUsersAccountManager{}
+func NewGAEUsersAccountManager(usernames UsernameConfig) *GAEUsersAccountManager {
+	return &GAEUsersAccountManager{usernames: usernames}
 }
 
 func (g *GAEUsersAccountManager) UserFromRequest(r *http.Request) (User, error) {
@@ -39,7 +41,7 @@ func (g *GAEUsersAccountManager) UserFromRequest(r *http.Request) (User, error)
 	if err != nil {
 		return nil, err
 	}
-	return userFromEmail(email), nil
+	return g.userFromEmail(email)
 }
 
 func (g *GAEUsersAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, idToken appOAuth2.IDTokenClaims) (User, error) {
@@ -58,26 +60,34 @@ func (g *GAEUsersAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http
 	if rEmail != tkEmail {
 		return nil, fmt.Errorf("Logged in user doesn't match oauth2 user")
 	}
-	return userFromEmail(rEmail), nil
+	return g.userFromEmail(rEmail)
 }
 
 type GAEUser struct {
 	username string
+	email    string
 }
 
 func (u *GAEUser) Username() string {
 	return u.username
 }
 
+func (u *GAEUser) Email() string {
+	return u.email
+}
+
 func emailFromRequest(r *http.Request) (string, error) {
 	// These headers are guaranteed to be present and come from AppEngine.
 	return r.Header.Get(emailHeaderKey), nil
 }
 
-func userFromEmail(email string) *GAEUser {
+func (g *GAEUsersAccountManager) userFromEmail(email string) (User, error) {
 	if email == "" {
-		return nil
+		return nil, nil
+	}
+	username, err := g.usernames.FromEmail(email)
+	if err != nil {
+		return nil, apperr.NewForbiddenError("No valid username for the user's email", err)
 	}
-	username := strings.SplitN(email, "@", 2)[0]
-	return &GAEUser{username}
+	return &GAEUser{username: username, email: email}, nil
 }
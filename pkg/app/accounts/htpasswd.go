@@ -0,0 +1,210 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const HtpasswdAMType AMType = "htpasswd"
+
+const (
+	htpasswdDefaultRealm = "Cloud Orchestrator"
+	// The file is checked for changes at most this often.
+	htpasswdCheckInterval = 5 * time.Second
+)
+
+type HtpasswdConfig struct {
+	// Path of the file with a "username:hash" line per user, as created by `htpasswd -B`. Only bcrypt hashes
+	// are supported. Usernames may only have lowercase letters, digits, '-' and '_', up to 63 characters.
+	// Changes to the file are picked up without restarting the server.
+	File string
+	// Realm of the authentication challenge, "Cloud Orchestrator" if empty.
+	Realm string
+}
+
+// Implements the Manager interface authenticating the requests with HTTP basic authentication against the
+// users of an htpasswd file. Passwords are sent in clear text, so it must only be used over TLS.
+type HtpasswdAccountManager struct {
+	config    HtpasswdConfig
+	challenge string
+	// Compared against when the user doesn't exist, so unknown users take as long as wrong passwords.
+	dummyHash []byte
+
+	mu        sync.Mutex
+	hashes    map[string][]byte
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+	// Digests of the credentials already verified, bcrypt is too slow to run on every request.
+	verified map[[sha256.Size]byte]bool
+}
+
+func NewHtpasswdAccountManager(cfg HtpasswdConfig) (*HtpasswdAccountManager, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("htpasswd account manager: no file configured")
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = htpasswdDefaultRealm
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("htpasswd account manager: %w", err)
+	}
+	m := &HtpasswdAccountManager{
+		config:    cfg,
+		challenge: fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", cfg.Realm),
+		dummyHash: dummyHash,
+	}
+	if err := m.reload(); err != nil {
+		return nil, fmt.Errorf("htpasswd account manager: %w", err)
+	}
+	return m, nil
+}
+
+func (m *HtpasswdAccountManager) UserFromRequest(r *http.Request) (User, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, apperr.NewAuthenticationChallengeError("Authentication required", m.challenge)
+	}
+	if !m.verify(username, password) {
+		return nil, apperr.NewAuthenticationChallengeError("Invalid username or password", m.challenge)
+	}
+	return &HtpasswdUser{username}, nil
+}
+
+// The Build API authorization flow doesn't change who the user is, the request's user is kept.
+func (m *HtpasswdAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (User, error) {
+	return m.UserFromRequest(r)
+}
+
+func (m *HtpasswdAccountManager) verify(username, password string) bool {
+	m.mu.Lock()
+	m.reloadIfChanged()
+	hash, ok := m.hashes[username]
+	// The hash is part of the digest, so credentials verified against an old version of the file don't count.
+	digest := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + string(hash)))
+	verified := ok && m.verified[digest]
+	m.mu.Unlock()
+	if verified {
+		return true
+	}
+	if !ok {
+		bcrypt.CompareHashAndPassword(m.dummyHash, []byte(password))
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return false
+	}
+	m.mu.Lock()
+	m.verified[digest] = true
+	m.mu.Unlock()
+	return true
+}
+
+// Reloads the file if it was modified since the last time it was loaded. The current users are kept if the
+// file can't be loaded. Must be called with the lock held.
+func (m *HtpasswdAccountManager) reloadIfChanged() {
+	if time.Since(m.checkedAt) < htpasswdCheckInterval {
+		return
+	}
+	m.checkedAt = time.Now()
+	info, err := os.Stat(m.config.File)
+	if err != nil {
+		logging.Default().Error("Failed checking htpasswd file", "file", m.config.File, "error", err)
+		return
+	}
+	if info.ModTime().Equal(m.modTime) && info.Size() == m.size {
+		return
+	}
+	if err := m.reload(); err != nil {
+		logging.Default().Error("Failed reloading htpasswd file", "file", m.config.File, "error", err)
+		return
+	}
+	logging.Default().Info("Reloaded htpasswd file", "file", m.config.File, "users", len(m.hashes))
+}
+
+func (m *HtpasswdAccountManager) reload() error {
+	f, err := os.Open(m.config.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hashes, err := parseHtpasswd(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.config.File, err)
+	}
+	m.hashes = hashes
+	m.modTime = info.ModTime()
+	m.size = info.Size()
+	m.checkedAt = time.Now()
+	m.verified = make(map[[sha256.Size]byte]bool)
+	return nil
+}
+
+// Empty lines and lines starting with "#" are ignored. Files with invalid usernames are rejected as a whole, like
+// those with unsupported hashes.
+func parseHtpasswd(r io.Reader) (map[string][]byte, error) {
+	hashes := make(map[string][]byte)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		username, hash, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("line %d: expected username:hash", n)
+		}
+		if !validUsernameRe.MatchString(username) {
+			return nil, fmt.Errorf("line %d: invalid username %q, only lowercase letters, digits, '-' and '_' are "+
+				"allowed, up to %d characters", n, username, maxLabelValueLength)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: user %q: not a bcrypt hash: %w", n, username, err)
+		}
+		hashes[username] = []byte(hash)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+type HtpasswdUser struct {
+	username string
+}
+
+func (u *HtpasswdUser) Username() string {
+	return u.username
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func htpasswdLine(t *testing.T, username, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return username + ":" + string(hash) + "\n"
+}
+
+func writeHtpasswd(t *testing.T, path string, lines ...string) {
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestHtpasswdAccountManager(t *testing.T, lines ...string) (*HtpasswdAccountManager, string) {
+	path := filepath.Join(t.TempDir(), "users.htpasswd")
+	writeHtpasswd(t, path, lines...)
+	m, err := NewHtpasswdAccountManager(HtpasswdConfig{File: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, path
+}
+
+func requestWithBasicAuth(username, password string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones", nil)
+	if username != "" {
+		r.SetBasicAuth(username, password)
+	}
+	return r
+}
+
+func expectChallenge(t *testing.T, err error) {
+	t.Helper()
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected unauthenticated error, got: %v", err)
+	}
+	if want := `Basic realm="Cloud Orchestrator", charset="UTF-8"`; appErr.Challenge != want {
+		t.Errorf("expected challenge %q, got %q", want, appErr.Challenge)
+	}
+}
+
+func TestHtpasswdUserFromRequest(t *testing.T) {
+	m, _ := newTestHtpasswdAccountManager(t, "# lab users\n", "\n", htpasswdLine(t, "alice", "secret"))
+
+	// Twice, the second time the credentials are already verified.
+	for i := 0; i < 2; i++ {
+		user, err := m.UserFromRequest(requestWithBasicAuth("alice", "secret"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username() != "alice" {
+			t.Errorf("expected user %q, got %q", "alice", user.Username())
+		}
+	}
+}
+
+func TestHtpasswdRejectsInvalidCredentials(t *testing.T) {
+	m, _ := newTestHtpasswdAccountManager(t, htpasswdLine(t, "alice", "secret"))
+	tests := map[string]*http.Request{
+		"no credentials": requestWithBasicAuth("", ""),
+		"wrong password": requestWithBasicAuth("alice", "guess"),
+		"unknown user":   requestWithBasicAuth("bob", "secret"),
+	}
+	for name, r := range tests {
+		t.Run(name, func(t *testing.T) {
+			user, err := m.UserFromRequest(r)
+
+			if user != nil {
+				t.Errorf("expected no user, got: %+v", user)
+			}
+			expectChallenge(t, err)
+		})
+	}
+}
+
+func TestHtpasswdReloadsChangedFile(t *testing.T) {
+	m, path := newTestHtpasswdAccountManager(t, htpasswdLine(t, "alice", "secret"))
+	if _, err := m.UserFromRequest(requestWithBasicAuth("alice", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	writeHtpasswd(t, path, htpasswdLine(t, "bob", "secret"))
+	// Makes the change visible even on file systems with coarse modification times.
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	m.checkedAt = time.Time{}
+
+	if _, err := m.UserFromRequest(requestWithBasicAuth("bob", "secret")); err != nil {
+		t.Errorf("expected added user to be accepted, got: %v", err)
+	}
+	_, err := m.UserFromRequest(requestWithBasicAuth("alice", "secret"))
+	expectChallenge(t, err)
+}
+
+func TestHtpasswdKeepsUsersIfReloadFails(t *testing.T) {
+	m, path := newTestHtpasswdAccountManager(t, htpasswdLine(t, "alice", "secret"))
+	writeHtpasswd(t, path, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	m.checkedAt = time.Time{}
+
+	if _, err := m.UserFromRequest(requestWithBasicAuth("alice", "secret")); err != nil {
+		t.Errorf("expected user to be kept, got: %v", err)
+	}
+}
+
+func TestHtpasswdRejectsInvalidFiles(t *testing.T) {
+	tests := map[string]string{
+		"not bcrypt":        "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+		"no hash":           "alice\n",
+		"no username":       ":$2y$05$abc\n",
+		"uppercase":         htpasswdLine(t, "Alice", "secret"),
+		"space":             htpasswdLine(t, "alice smith", "secret"),
+		"filter syntax":     htpasswdLine(t, "alice OR labels.x=y", "secret"),
+		"too long username": htpasswdLine(t, strings.Repeat("a", 64), "secret"),
+		"missing file":      "",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.htpasswd")
+			if content != "" {
+				writeHtpasswd(t, path, content)
+			}
+
+			if _, err := NewHtpasswdAccountManager(HtpasswdConfig{File: path}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+)
+
+const IAPAMType AMType = "IAP"
+
+const (
+	iapAssertionHeader = "X-Goog-IAP-JWT-Assertion"
+	iapIssuer          = "https://cloud.google.com/iap"
+	iapJWKSURL         = "https://www.gstatic.com/iap/verify/public_key-jwk"
+)
+
+type IAPConfig struct {
+	// Accepted values of the "aud" claim. For GCP IAP it's "/projects/<number>/global/backendServices/<id>" or
+	// "/projects/<number>/apps/<project id>" on App Engine.
+	Audiences []string
+	// Header carrying the signed assertion, "X-Goog-IAP-JWT-Assertion" if empty.
+	Header string
+	// Expected "iss" claim, GCP IAP's if empty.
+	Issuer string
+	// Where the signing keys are fetched from, GCP IAP's if empty. Ignored if PublicKeyFile is set.
+	JWKSURL string
+	// PEM encoded public key the assertions are signed with, for proxies without a JWKS endpoint.
+	PublicKeyFile string
+	// Claims the username is taken from, the first one present in the assertion is used. Defaults to "email".
+	// Emails are turned into usernames as configured in the account manager's Usernames setting. Subjects whose
+	// username isn't a valid label value are rejected, the "qualified" format always produces valid ones.
+	UsernameClaims []string
+	// IP addresses or CIDR ranges of the proxies, requests from other addresses are rejected. Any address is
+	// accepted if empty.
+	TrustedProxies []string
+}
+
+// Implements the Manager interface authenticating the requests with the signed assertion added by an
+// authenticating reverse proxy, like GCP Identity-Aware Proxy or oauth2-proxy.
+type IAPAccountManager struct {
+	usernames      UsernameConfig
+	config         IAPConfig
+	verifier       *jwtVerifier
+	trustedProxies []*net.IPNet
+}
+
+func NewIAPAccountManager(cfg IAPConfig, usernames UsernameConfig) (*IAPAccountManager, error) {
+	if len(cfg.Audiences) == 0 {
+		return nil, fmt.Errorf("IAP account manager: no audiences configured")
+	}
+	if cfg.Header == "" {
+		cfg.Header = iapAssertionHeader
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = iapIssuer
+	}
+	if cfg.JWKSURL == "" {
+		cfg.JWKSURL = iapJWKSURL
+	}
+	if len(cfg.UsernameClaims) == 0 {
+		cfg.UsernameClaims = []string{"email"}
+	}
+	var keys keySource = newJWKSCache(cfg.Issuer, cfg.JWKSURL)
+	if cfg.PublicKeyFile != "" {
+		key, err := loadPublicKey(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("IAP account manager: %w", err)
+		}
+		keys = key
+	}
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("IAP account manager: %w", err)
+	}
+	return &IAPAccountManager{
+		usernames: usernames,
+		config:    cfg,
+		verifier: &jwtVerifier{
+			issuer:    cfg.Issuer,
+			audiences: cfg.Audiences,
+			keys:      keys,
+		},
+		trustedProxies: proxies,
+	}, nil
+}
+
+func (m *IAPAccountManager) UserFromRequest(r *http.Request) (User, error) {
+	if !m.isTrustedProxy(r.RemoteAddr) {
+		return nil, apperr.NewForbiddenError("Requests must be sent through the authenticating proxy", nil)
+	}
+	assertion := r.Header.Get(m.config.Header)
+	if assertion == "" {
+		return nil, nil
+	}
+	claims, err := m.verifier.verify(assertion)
+	if err != nil {
+		return nil, apperr.NewUnauthenticatedError("Invalid proxy assertion", err)
+	}
+	username, email, err := identityFromClaims(claims, m.config.UsernameClaims, &m.usernames)
+	if err != nil {
+		return nil, apperr.NewForbiddenError("No valid username for the proxy assertion's subject", err)
+	}
+	if username == "" {
+		return nil, apperr.NewUnauthenticatedError(
+			fmt.Sprintf("Proxy assertion without username, expected one of these claims: %s",
+				strings.Join(m.config.UsernameClaims, ", ")), nil)
+	}
+	return &IAPUser{username: username, email: email}, nil
+}
+
+// The Build API authorization flow doesn't change who the user is, the request's user is kept.
+func (m *IAPAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (User, error) {
+	user, err := m.UserFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("No proxy assertion in request")
+	}
+	return user, nil
+}
+
+func (m *IAPAccountManager) isTrustedProxy(remoteAddr string) bool {
+	if len(m.trustedProxies) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range m.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type IAPUser struct {
+	username string
+	email    string
+}
+
+func (u *IAPUser) Username() string {
+	return u.username
+}
+
+func (u *IAPUser) Email() string {
+	return u.email
+}
+
+// Single addresses are turned into ranges with just that address.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	res := []*net.IPNet{}
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address: %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range: %w", err)
+		}
+		res = append(res, n)
+	}
+	return res, nil
+}
+
+func loadPublicKey(path string) (*staticKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %q", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in %q: %w", path, err)
+	}
+	return &staticKey{key}, nil
+}
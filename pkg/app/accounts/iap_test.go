@@ -0,0 +1,152 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func newTestIAPAccountManager(t *testing.T, idp *testIdP, cfg IAPConfig) *IAPAccountManager {
+	cfg.Audiences = []string{testAudience}
+	cfg.Issuer = idp.server.URL
+	cfg.JWKSURL = idp.server.URL + "/jwks"
+	m, err := NewIAPAccountManager(cfg, UsernameConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func requestFrom(remoteAddr string, header, value string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones", nil)
+	r.RemoteAddr = remoteAddr
+	r.Header.Set(header, value)
+	return r
+}
+
+func TestIAPUserFromRequest(t *testing.T) {
+	idp := newTestIdP(t)
+	m := newTestIAPAccountManager(t, idp, IAPConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+	assertion := idp.sign(t, jwt.SigningMethodES256, "ec-1", idp.validClaims())
+
+	for _, addr := range []string{"10.1.2.3:4567", "[::1]:4567"} {
+		user, err := m.UserFromRequest(requestFrom(addr, "X-Goog-IAP-JWT-Assertion", assertion))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username() != "johndoe" {
+			t.Errorf("expected user %q, got %q", "johndoe", user.Username())
+		}
+	}
+}
+
+func TestIAPRejectsUntrustedProxies(t *testing.T) {
+	idp := newTestIdP(t)
+	m := newTestIAPAccountManager(t, idp, IAPConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	assertion := idp.sign(t, jwt.SigningMethodES256, "ec-1", idp.validClaims())
+
+	_, err := m.UserFromRequest(requestFrom("192.168.1.1:4567", "X-Goog-IAP-JWT-Assertion", assertion))
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected forbidden error, got: %v", err)
+	}
+}
+
+func TestIAPDoesNotTrustBareEmailHeaders(t *testing.T) {
+	m := newTestIAPAccountManager(t, newTestIdP(t), IAPConfig{})
+
+	user, err := m.UserFromRequest(
+		requestFrom("10.1.2.3:4567", "X-Goog-Authenticated-User-Email", "accounts.google.com:johndoe@example.com"))
+
+	if user != nil || err != nil {
+		t.Errorf("expected no user and no error, got: %+v, %v", user, err)
+	}
+	_, err = m.UserFromRequest(requestFrom("10.1.2.3:4567", "X-Goog-IAP-JWT-Assertion", "johndoe@example.com"))
+	if err == nil {
+		t.Error("expected unsigned assertion to be rejected")
+	}
+}
+
+func TestIAPPublicKeyFile(t *testing.T) {
+	idp := newTestIdP(t)
+	der, err := x509.MarshalPKIXPublicKey(&idp.ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestIAPAccountManager(t, idp, IAPConfig{Header: "X-Auth-Request-Assertion", PublicKeyFile: keyFile})
+	// Proxies with a single key don't need to set the key id.
+	assertion := idp.sign(t, jwt.SigningMethodES256, "", idp.validClaims())
+
+	user, err := m.UserFromRequest(requestFrom("10.1.2.3:4567", "X-Auth-Request-Assertion", assertion))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username() != "johndoe" {
+		t.Errorf("expected user %q, got %q", "johndoe", user.Username())
+	}
+	if idp.jwksGetCnt != 0 {
+		t.Errorf("expected no JWKS fetch, got %d", idp.jwksGetCnt)
+	}
+}
+
+func TestIAPInvalidTrustedProxies(t *testing.T) {
+	for _, p := range []string{"10.0.0.300", "10.0.0.0/33", "proxy.example.com"} {
+		if _, err := NewIAPAccountManager(IAPConfig{Audiences: []string{testAudience}, TrustedProxies: []string{p}}, UsernameConfig{}); err == nil {
+			t.Errorf("%q: expected error", p)
+		}
+	}
+}
+
+func TestIAPQualifiedUsernames(t *testing.T) {
+	idp := newTestIdP(t)
+	m, err := NewIAPAccountManager(IAPConfig{
+		Audiences: []string{testAudience},
+		Issuer:    idp.server.URL,
+		JWKSURL:   idp.server.URL + "/jwks",
+	}, UsernameConfig{Format: UsernameFormatQualified, PrimaryDomains: []string{"ourcorp.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion := idp.sign(t, jwt.SigningMethodES256, "ec-1", idp.validClaims())
+
+	user, err := m.UserFromRequest(requestFrom("10.1.2.3:4567", "X-Goog-IAP-JWT-Assertion", assertion))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username() != "johndoe__example_com" {
+		t.Errorf("expected user %q, got %q", "johndoe__example_com", user.Username())
+	}
+	if UserEmail(user) != "johndoe@example.com" {
+		t.Errorf("expected email %q, got %q", "johndoe@example.com", UserEmail(user))
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Provides the public keys JWTs are verified with.
+type keySource interface {
+	// Returns the key with the given id, the "kid" header of the JWT.
+	key(kid string) (crypto.PublicKey, error)
+}
+
+// A single key, used whatever the key id.
+type staticKey struct {
+	crypto.PublicKey
+}
+
+func (k *staticKey) key(string) (crypto.PublicKey, error) {
+	return k.PublicKey, nil
+}
+
+// Verifies JWTs signed by an issuer for any of the given audiences.
+type jwtVerifier struct {
+	issuer    string
+	audiences []string
+	keys      keySource
+}
+
+// Checks the signature, issuer, audience and validity period of the token and returns its claims.
+func (v *jwtVerifier) verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := v.keys.key(kid)
+		if err != nil {
+			return nil, err
+		}
+		// Rejects tokens signed with an algorithm not matching the key, e.g. HMAC with the public key as secret.
+		switch key.(type) {
+		case *rsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method %q", t.Method.Alg())
+			}
+		case *ecdsa.PublicKey:
+			if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+				return nil, fmt.Errorf("unexpected signing method %q", t.Method.Alg())
+			}
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	// MapClaims.Valid doesn't require the expiration time.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("token expired or without expiration time")
+	}
+	for _, aud := range v.audiences {
+		if claims.VerifyAudience(aud, true) {
+			return claims, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected audience: %v", claims["aud"])
+}
+
+// Usernames taken from tokens or htpasswd files must be valid label values, they are used as such and in filter
+// expressions by some instance managers.
+var validUsernameRe = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+
+// Returns the username and email of the token's subject. The username is the value of the first of the given
+// claims present in the token, derived as configured if it's an email, the empty string if none is present. The
+// email is the "email" claim unless the username claim is an email already.
+func identityFromClaims(claims jwt.MapClaims, names []string, usernames *UsernameConfig) (string, string, error) {
+	email, _ := claims["email"].(string)
+	for _, c := range names {
+		v, ok := claims[c].(string)
+		if !ok || v == "" {
+			continue
+		}
+		username := v
+		if strings.Contains(v, "@") {
+			email = v
+			var err error
+			if username, err = usernames.FromEmail(v); err != nil {
+				return "", "", err
+			}
+		}
+		if !validUsernameRe.MatchString(username) {
+			return "", "", fmt.Errorf("invalid username %q from claim %q, only lowercase letters, digits, '-' "+
+				"and '_' are allowed, up to %d characters", username, c, maxLabelValueLength)
+		}
+		return username, email, nil
+	}
+	return "", email, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const OIDCAMType AMType = "OIDC"
+
+type OIDCConfig struct {
+	// URL of the identity provider, e.g. "https://keycloak.example.com/realms/android". The "iss" claim of the
+	// tokens must match it.
+	Issuer string
+	// Accepted values of the "aud" claim, usually the client id the tokens are issued to.
+	Audiences []string
+	// Where the signing keys are fetched from, taken from the issuer's discovery document if empty.
+	JWKSURL string
+	// Claims the username is taken from, the first one present in the token is used. Defaults to "email".
+	// Emails are turned into usernames as configured in the account manager's Usernames setting. Subjects whose
+	// username isn't a valid label value are rejected, the "qualified" format always produces valid ones.
+	// The Build API authorization callback takes the username from the same claims of the OAuth2 ID token.
+	UsernameClaims []string
+	// Optional claim holding the list of groups the user belongs to.
+	GroupsClaim string
+	// Request header carrying the token, the bearer token of the Authorization header if empty.
+	Header string
+}
+
+// Implements the Manager interface authenticating the requests with OIDC ID tokens, or any JWT, signed by
+// the configured issuer.
+type OIDCAccountManager struct {
+	usernames UsernameConfig
+	config    OIDCConfig
+	verifier  *jwtVerifier
+}
+
+func NewOIDCAccountManager(cfg OIDCConfig, usernames UsernameConfig) (*OIDCAccountManager, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("OIDC account manager: no issuer configured")
+	}
+	if len(cfg.Audiences) == 0 {
+		return nil, fmt.Errorf("OIDC account manager: no audiences configured")
+	}
+	if len(cfg.UsernameClaims) == 0 {
+		cfg.UsernameClaims = []string{"email"}
+	}
+	return &OIDCAccountManager{
+		usernames: usernames,
+		config:    cfg,
+		verifier: &jwtVerifier{
+			issuer:    cfg.Issuer,
+			audiences: cfg.Audiences,
+			keys:      newJWKSCache(cfg.Issuer, cfg.JWKSURL),
+		},
+	}, nil
+}
+
+func (m *OIDCAccountManager) UserFromRequest(r *http.Request) (User, error) {
+	token, ok := m.tokenFromRequest(r)
+	if !ok {
+		return nil, nil
+	}
+	claims, err := m.verifier.verify(token)
+	if err != nil {
+		return nil, apperr.NewUnauthenticatedError("Invalid token", err)
+	}
+	return m.userFromClaims(claims)
+}
+
+// The Build API authorization flow ends with a browser redirect, which doesn't carry the token. The user is
+// identified by the ID token received from the OAuth2 provider instead, with the configured username claims.
+func (m *OIDCAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (User, error) {
+	if _, ok := m.tokenFromRequest(r); ok {
+		return m.UserFromRequest(r)
+	}
+	return m.userFromClaims(jwt.MapClaims(tk))
+}
+
+func (m *OIDCAccountManager) tokenFromRequest(r *http.Request) (string, bool) {
+	if m.config.Header != "" {
+		token := r.Header.Get(m.config.Header)
+		return token, token != ""
+	}
+	return BearerToken(r)
+}
+
+func (m *OIDCAccountManager) userFromClaims(claims jwt.MapClaims) (User, error) {
+	user := &OIDCUser{}
+	var err error
+	user.username, user.email, err = identityFromClaims(claims, m.config.UsernameClaims, &m.usernames)
+	if err != nil {
+		return nil, apperr.NewForbiddenError("No valid username for the token's subject", err)
+	}
+	if user.username == "" {
+		return nil, apperr.NewUnauthenticatedError(
+			fmt.Sprintf("Token without username, expected one of these claims: %s",
+				strings.Join(m.config.UsernameClaims, ", ")), nil)
+	}
+	if m.config.GroupsClaim != "" {
+		groups, _ := claims[m.config.GroupsClaim].([]interface{})
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				user.groups = append(user.groups, s)
+			}
+		}
+	}
+	return user, nil
+}
+
+type OIDCUser struct {
+	username string
+	email    string
+	groups   []string
+}
+
+func (u *OIDCUser) Username() string {
+	return u.username
+}
+
+func (u *OIDCUser) Email() string {
+	return u.email
+}
+
+func (u *OIDCUser) Groups() []string {
+	return u.groups
+}
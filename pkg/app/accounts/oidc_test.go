@@ -0,0 +1,283 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/database"
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testAudience = "cloud-orchestrator"
+
+// Stands in for an identity provider, serving its discovery document and signing keys.
+type testIdP struct {
+	server     *httptest.Server
+	rsaKey     *rsa.PrivateKey
+	ecKey      *ecdsa.PrivateKey
+	jwksGetCnt int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL, "jwks_uri": idp.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.jwksGetCnt++
+		enc := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": enc(rsaKey.N), "e": enc(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": enc(ecKey.X), "y": enc(ecKey.Y)},
+		}})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *testIdP) validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    idp.server.URL,
+		"aud":    testAudience,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "johndoe@example.com",
+		"groups": []string{"camera"},
+	}
+}
+
+func (idp *testIdP) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	tk := jwt.NewWithClaims(method, claims)
+	tk.Header["kid"] = kid
+	var key any = idp.rsaKey
+	if _, ok := method.(*jwt.SigningMethodECDSA); ok {
+		key = idp.ecKey
+	}
+	s, err := tk.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestOIDCAccountManager(t *testing.T, idp *testIdP) *OIDCAccountManager {
+	m, err := NewOIDCAccountManager(OIDCConfig{
+		Issuer:      idp.server.URL,
+		Audiences:   []string{"other", testAudience},
+		GroupsClaim: "groups",
+	}, UsernameConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func requestWithToken(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestOIDCUserFromRequest(t *testing.T) {
+	idp := newTestIdP(t)
+	m := newTestOIDCAccountManager(t, idp)
+	tokens := map[string]string{
+		"RSA": idp.sign(t, jwt.SigningMethodRS256, "rsa-1", idp.validClaims()),
+		"EC":  idp.sign(t, jwt.SigningMethodES256, "ec-1", idp.validClaims()),
+	}
+	for name, token := range tokens {
+		t.Run(name, func(t *testing.T) {
+			user, err := m.UserFromRequest(requestWithToken(token))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(&OIDCUser{username: "johndoe", email: "johndoe@example.com", groups: []string{"camera"}}, user,
+				cmp.AllowUnexported(OIDCUser{})); diff != "" {
+				t.Errorf("user mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	idp := newTestIdP(t)
+	m := newTestOIDCAccountManager(t, idp)
+	with := func(key string, value any) jwt.MapClaims {
+		c := idp.validClaims()
+		if value == nil {
+			delete(c, key)
+		} else {
+			c[key] = value
+		}
+		return c
+	}
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.validClaims()).SignedString([]byte("secret"))
+	tokens := map[string]string{
+		"expired":          idp.sign(t, jwt.SigningMethodRS256, "rsa-1", with("exp", time.Now().Add(-time.Minute).Unix())),
+		"no expiration":    idp.sign(t, jwt.SigningMethodRS256, "rsa-1", with("exp", nil)),
+		"wrong audience":   idp.sign(t, jwt.SigningMethodRS256, "rsa-1", with("aud", "foo")),
+		"wrong issuer":     idp.sign(t, jwt.SigningMethodRS256, "rsa-1", with("iss", "https://evil.example.com")),
+		"no username":      idp.sign(t, jwt.SigningMethodRS256, "rsa-1", with("email", nil)),
+		"unknown key":      idp.sign(t, jwt.SigningMethodRS256, "rsa-2", idp.validClaims()),
+		"mismatched key":   idp.sign(t, jwt.SigningMethodRS256, "ec-1", idp.validClaims()),
+		"hmac signed":      hmacToken,
+		"malformed":        "foo.bar.baz",
+		"tampered payload": idp.sign(t, jwt.SigningMethodRS256, "rsa-1", idp.validClaims()) + "x",
+	}
+	for name, token := range tokens {
+		t.Run(name, func(t *testing.T) {
+			user, err := m.UserFromRequest(requestWithToken(token))
+
+			if err == nil {
+				t.Errorf("expected error, got user: %+v", user)
+			}
+		})
+	}
+}
+
+func TestOIDCRejectsInvalidUsernames(t *testing.T) {
+	idp := newTestIdP(t)
+	m, err := NewOIDCAccountManager(OIDCConfig{
+		Issuer:         idp.server.URL,
+		Audiences:      []string{testAudience},
+		UsernameClaims: []string{"sub", "email"},
+	}, UsernameConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withSub := func(sub string) string {
+		c := idp.validClaims()
+		c["sub"] = sub
+		return idp.sign(t, jwt.SigningMethodRS256, "rsa-1", c)
+	}
+	valid := withSub("john-doe_1")
+	invalid := map[string]string{
+		"wildcard":  withSub("*"),
+		"filter":    withSub("foo OR labels.x:y"),
+		"uppercase": withSub("JohnDoe"),
+		"too long":  withSub(strings.Repeat("a", 64)),
+		"local part dot": idp.sign(t, jwt.SigningMethodRS256, "rsa-1", jwt.MapClaims{
+			"iss": idp.server.URL, "aud": testAudience, "exp": time.Now().Add(time.Hour).Unix(),
+			"email": "john.doe@example.com",
+		}),
+	}
+
+	if user, err := m.UserFromRequest(requestWithToken(valid)); err != nil || user.Username() != "john-doe_1" {
+		t.Errorf("expected user %q, got: %+v, %v", "john-doe_1", user, err)
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			user, err := m.UserFromRequest(requestWithToken(token))
+
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusForbidden {
+				t.Errorf("expected forbidden error, got: %+v, %v", user, err)
+			}
+		})
+	}
+}
+
+func TestOIDCWithoutTokenIsUnauthenticated(t *testing.T) {
+	m := newTestOIDCAccountManager(t, newTestIdP(t))
+
+	user, err := m.UserFromRequest(requestWithToken(""))
+
+	if user != nil || err != nil {
+		t.Errorf("expected no user and no error, got: %+v, %v", user, err)
+	}
+}
+
+func TestOIDCOnOAuth2ExchangeWithoutToken(t *testing.T) {
+	m := newTestOIDCAccountManager(t, newTestIdP(t))
+	claims := appOAuth2.IDTokenClaims{"email": "johndoe@example.com"}
+
+	user, err := m.OnOAuth2Exchange(httptest.NewRecorder(), requestWithToken(""), claims)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&OIDCUser{username: "johndoe", email: "johndoe@example.com"}, user,
+		cmp.AllowUnexported(OIDCUser{})); diff != "" {
+		t.Errorf("user mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOIDCCachesKeys(t *testing.T) {
+	idp := newTestIdP(t)
+	m := newTestOIDCAccountManager(t, idp)
+	valid := idp.sign(t, jwt.SigningMethodRS256, "rsa-1", idp.validClaims())
+	unknown := idp.sign(t, jwt.SigningMethodRS256, "rsa-2", idp.validClaims())
+
+	for _, token := range []string{valid, valid, unknown, unknown} {
+		m.UserFromRequest(requestWithToken(token))
+	}
+
+	// Unknown keys don't cause a fetch right after the previous one.
+	if idp.jwksGetCnt != 1 {
+		t.Errorf("expected the keys to be fetched once, got %d fetches", idp.jwksGetCnt)
+	}
+}
+
+func TestAPITokensCoexistWithOIDC(t *testing.T) {
+	idp := newTestIdP(t)
+	dbs := database.NewInMemoryDBService()
+	secret, _ := apitokens.Generate()
+	dbs.CreateAPIToken(&apitokens.Token{ID: "ci", Username: "janedoe", Hash: apitokens.Hash(secret)})
+	m := NewAPITokenAccountManager(dbs, newTestOIDCAccountManager(t, idp))
+	tokens := map[string]string{
+		"janedoe": secret,
+		"johndoe": idp.sign(t, jwt.SigningMethodRS256, "rsa-1", idp.validClaims()),
+	}
+	for want, token := range tokens {
+		user, err := m.UserFromRequest(requestWithToken(token))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Username() != want {
+			t.Errorf("expected user %q, got %q", want, user.Username())
+		}
+	}
+}
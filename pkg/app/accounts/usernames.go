@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"fmt"
+	"strings"
+)
+
+type UsernameFormat string
+
+const (
+	// The local part of the email, "alice@example.com" is "alice". Users with the same local part in different
+	// domains share the username.
+	UsernameFormatLocal UsernameFormat = "local"
+	// The lowercase local part for emails in the primary domains, followed by "__" and the domain with its dots
+	// replaced by underscores for any other email, "alice@partner.com" is "alice__partner_com". Characters of
+	// the local part other than letters, digits and '-' are escaped as '_' and their hex code, "john.doe" is
+	// "john_2edoe", so usernames in this format are valid label values and never shared by different emails.
+	// Emails resulting in usernames longer than the 63 characters allowed in label values are rejected.
+	UsernameFormatQualified UsernameFormat = "qualified"
+)
+
+// Maximum length of a label value, usernames are used as such by some instance managers.
+const maxLabelValueLength = 63
+
+type UsernameConfig struct {
+	// UsernameFormatLocal if empty.
+	Format UsernameFormat
+	// Domains whose users keep the local part of their email as username with the qualified format.
+	PrimaryDomains []string
+}
+
+func (c *UsernameConfig) Validate() error {
+	switch c.Format {
+	case "", UsernameFormatLocal, UsernameFormatQualified:
+		return nil
+	default:
+		return fmt.Errorf("unknown username format: %q", c.Format)
+	}
+}
+
+// Returns the username of the user with the given email.
+func (c *UsernameConfig) FromEmail(email string) (string, error) {
+	local, domain, found := strings.Cut(email, "@")
+	if c.Format != UsernameFormatQualified || !found {
+		return local, nil
+	}
+	username := escapeLocalPart(local)
+	if !c.isPrimaryDomain(domain) {
+		d, err := encodeDomain(domain)
+		if err != nil {
+			return "", err
+		}
+		// Escaped local parts never contain "__", so the separator can't be mistaken for part of them.
+		username += "__" + d
+	}
+	if len(username) > maxLabelValueLength {
+		return "", fmt.Errorf("username for %q longer than %d characters", email, maxLabelValueLength)
+	}
+	return username, nil
+}
+
+func escapeLocalPart(local string) string {
+	var b strings.Builder
+	for _, c := range []byte(strings.ToLower(local)) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02x", c)
+		}
+	}
+	return b.String()
+}
+
+// Domains only contain letters, digits, '-' and dots, which become underscores.
+func encodeDomain(domain string) (string, error) {
+	domain = strings.ToLower(domain)
+	for _, c := range []byte(domain) {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
+			return "", fmt.Errorf("invalid email domain: %q", domain)
+		}
+	}
+	return strings.ReplaceAll(domain, ".", "_"), nil
+}
+
+func (c *UsernameConfig) isPrimaryDomain(domain string) bool {
+	for _, d := range c.PrimaryDomains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the domain of the email, the empty string if it's not an email.
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.ToLower(domain)
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accounts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUsernameFromEmail(t *testing.T) {
+	local := &UsernameConfig{}
+	qualified := &UsernameConfig{Format: UsernameFormatQualified, PrimaryDomains: []string{"OurCorp.com"}}
+	tests := []struct {
+		cfg   *UsernameConfig
+		email string
+		want  string
+	}{
+		{local, "alice@ourcorp.com", "alice"},
+		{local, "alice@partner.com", "alice"},
+		{local, "Alice@partner.com", "Alice"},
+		{qualified, "alice@ourcorp.com", "alice"},
+		{qualified, "Alice@OURCORP.COM", "alice"},
+		{qualified, "alice@partner.com", "alice__partner_com"},
+		{qualified, "alice@eng.partner.com", "alice__eng_partner_com"},
+		{qualified, "john.doe@ourcorp.com", "john_2edoe"},
+		{qualified, "john.doe@partner.com", "john_2edoe__partner_com"},
+		{qualified, "john+ci@partner.com", "john_2bci__partner_com"},
+		{qualified, "john-doe@partner.com", "john-doe__partner_com"},
+		// Would be taken by alice@partner.com otherwise.
+		{qualified, "alice__partner_com@ourcorp.com", "alice_5f_5fpartner_5fcom"},
+		{qualified, "alice", "alice"},
+		{qualified, strings.Repeat("a", 63) + "@ourcorp.com", strings.Repeat("a", 63)},
+	}
+	for _, tc := range tests {
+		got, err := tc.cfg.FromEmail(tc.email)
+		if err != nil {
+			t.Errorf("%s format, %q: unexpected error: %v", tc.cfg.Format, tc.email, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s format, %q: expected %q, got %q", tc.cfg.Format, tc.email, tc.want, got)
+		}
+		if tc.cfg.Format == UsernameFormatQualified && !validUsernameRe.MatchString(got) {
+			t.Errorf("%q: %q is not a valid label value", tc.email, got)
+		}
+	}
+}
+
+func TestQualifiedUsernameRejectsInvalidEmails(t *testing.T) {
+	cfg := &UsernameConfig{Format: UsernameFormatQualified, PrimaryDomains: []string{"ourcorp.com"}}
+	emails := []string{
+		strings.Repeat("a", 64) + "@ourcorp.com",
+		strings.Repeat("a", 50) + "@partner.example.com",
+		"alice@part ner.com",
+		"alice@partner.com:80",
+	}
+	for _, e := range emails {
+		if u, err := cfg.FromEmail(e); err == nil {
+			t.Errorf("%q: expected error, got username %q", e, u)
+		}
+	}
+}
+
+func TestUsernameConfigValidate(t *testing.T) {
+	for _, f := range []UsernameFormat{"", UsernameFormatLocal, UsernameFormatQualified} {
+		if err := (&UsernameConfig{Format: f}).Validate(); err != nil {
+			t.Errorf("%q: unexpected error: %v", f, err)
+		}
+	}
+	if err := (&UsernameConfig{Format: "email"}).Validate(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apitokens implements the API tokens non-interactive clients authenticate with. Only hashes of the
+// tokens are stored, tokens can't be recovered from them.
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+// Prefix of every token, it makes leaked tokens easy to spot.
+const tokenPrefix = "cotk_"
+
+type Token struct {
+	ID       string
+	Username string
+	// Email of the user, empty if unknown. Users authenticated with the token are admitted as this email.
+	Email string
+	Name  string
+	// Hex encoded SHA-256 hash of the secret token.
+	Hash         string
+	CreationTime time.Time
+	// Zero if the token never expires.
+	ExpirationTime time.Time
+}
+
+func (t *Token) Expired(now time.Time) bool {
+	return !t.ExpirationTime.IsZero() && !now.Before(t.ExpirationTime)
+}
+
+// Returns the API representation of the token, without the secret.
+func (t *Token) ToAPI() *apiv1.APIToken {
+	res := &apiv1.APIToken{
+		ID:           t.ID,
+		Name:         t.Name,
+		CreationTime: t.CreationTime.Format(time.RFC3339),
+	}
+	if !t.ExpirationTime.IsZero() {
+		res.ExpirationTime = t.ExpirationTime.Format(time.RFC3339)
+	}
+	return res
+}
+
+// Stores API tokens. Implemented by the database service.
+type Store interface {
+	CreateAPIToken(t *Token) error
+	// Returns nil, nil if no token has the given hash.
+	FetchAPITokenByHash(hash string) (*Token, error)
+	// Returns the tokens of the user, oldest first.
+	ListAPITokens(username string) ([]*Token, error)
+	// Won't return error if the user has no token with the given id.
+	DeleteAPIToken(username, id string) error
+}
+
+// Returns a new random secret token.
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Returns the hash stored for the given secret token. Tokens are random and long enough for a plain hash to
+// be safe, a slow password hash isn't needed.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Whether the string looks like an API token, telling API tokens apart from other bearer tokens.
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, tokenPrefix)
+}
@@ -15,28 +15,38 @@
 package app
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"math"
 	"math/rand"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
 	"github.com/google/cloud-android-orchestration/pkg/app/config"
 	"github.com/google/cloud-android-orchestration/pkg/app/database"
 	"github.com/google/cloud-android-orchestration/pkg/app/encryption"
 	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
 	"github.com/google/cloud-android-orchestration/pkg/app/instances"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+	"github.com/google/cloud-android-orchestration/pkg/app/ratelimit"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/oauth2"
 )
@@ -44,21 +54,33 @@ import (
 const (
 	sessionIdCookie = "sessionid"
 	allowedMethods  = "GET, POST, PUT, DELETE, OPTIONS, HEAD"
+	// Maximum time a wait operation request waits for the host orchestrator of a new host to be up, clients
+	// are expected to retry afterwards.
+	hostReadyWaitTimeout = 1 * time.Minute
+	// Operations leaving hosts running are forgotten after this long if nobody waits for them.
+	startingOpRetention = 1 * time.Hour
 )
 
 // The controller implements the web API of the cloud orchestrator. It parses
 // and validates requests from the client and passes the information to the
 // relevant modules
 type App struct {
-	instanceManager          instances.Manager
-	accountManager           accounts.Manager
-	oauth2Helper             *appOAuth2.Helper
-	encryptionService        encryption.Service
-	databaseService          database.Service
+	instanceManager   instances.Manager
+	accountManager    accounts.Manager
+	oauth2Helper      *appOAuth2.Helper
+	encryptionService encryption.Service
+	databaseService   database.Service
+	// Nil if audit logging is disabled.
+	auditSink                audit.Sink
 	connectorStaticFilesPath string
 	corsAllowedOrigins       []string
 	infraConfig              apiv1.InfraConfig
 	config                   *config.Config
+	healthChecker            *instances.HealthChecker
+	startingOps              *startingOperations
+	// Nil if the requests aren't rate limited.
+	controlPlaneLimiter *ratelimit.Limiter
+	proxyLimiter        *ratelimit.Limiter
 }
 
 func NewApp(
@@ -67,11 +89,14 @@ func NewApp(
 	oc *appOAuth2.Helper,
 	es encryption.Service,
 	dbs database.Service,
+	as audit.Sink,
 	webStaticFilesPath string,
 	corsAllowedOrigins []string,
 	webRTCConfig config.WebRTCConfig,
 	config *config.Config) *App {
-	return &App{im, am, oc, es, dbs, webStaticFilesPath, corsAllowedOrigins, buildInfraCfg(webRTCConfig.STUNServers), config}
+	return &App{im, am, oc, es, dbs, as, webStaticFilesPath, corsAllowedOrigins, buildInfraCfg(webRTCConfig.STUNServers), config,
+		instances.NewHealthChecker(im), newStartingOperations(),
+		ratelimit.NewLimiter(config.RateLimit.ControlPlane), ratelimit.NewLimiter(config.RateLimit.Proxy)}
 }
 
 func (c *App) AddCorsHeaderIfNeeded(w http.ResponseWriter, r *http.Request) {
@@ -95,7 +120,10 @@ func (c *App) Handler() http.Handler {
 
 	// Instance Manager Routes
 	router.Handle("/v1/zones", c.Authenticate(c.listZones)).Methods("GET")
-	router.Handle("/v1/zones/{zone}/hosts", c.Authenticate(c.createHost)).Methods("POST")
+	// Lists the hosts of the user across all zones, the results are not paginated.
+	router.Handle("/v1/hosts", c.Authenticate(c.listAllHosts)).Methods("GET")
+	router.Handle("/v1/zones/{zone}/hosts",
+		c.Authenticate(c.Audit("host.create", RequireRole(accounts.RoleUser, c.createHost)))).Methods("POST")
 	router.Handle("/v1/zones/{zone}/hosts", c.Authenticate(c.listHosts)).Methods("GET")
 	// Waits for the specified operation to be DONE or for the request to approach the specified deadline,
 	// `503 Service Unavailable` error will be returned if the deadline is reached and the operation is not done.
@@ -103,28 +131,66 @@ func (c *App) Handler() http.Handler {
 	// It returns the expected response of the operation in case of success. If the original method returns no
 	// data on success, such as `Delete`, response will be empty. If the original method is standard
 	// `Get`/`Create`/`Update`, the response should be the relevant resource.
-	router.Handle("/v1/zones/{zone}/operations/{operation}/:wait", c.Authenticate(c.waitOperation)).Methods("POST")
-	router.Handle("/v1/zones/{zone}/hosts/{host}", c.Authenticate(c.deleteHost)).Methods("DELETE")
+	router.Handle("/v1/zones/{zone}/operations/{operation}/:wait",
+		c.Authenticate(RequireRole(accounts.RoleUser, c.waitOperation))).Methods("POST")
+	router.Handle("/v1/zones/{zone}/hosts/{host}",
+		c.Authenticate(c.Audit("host.delete", RequireRole(accounts.RoleUser, c.deleteHost)))).Methods("DELETE")
 
-	// Host Orchestrator Proxy Routes
-	router.Handle("/v1/zones/{zone}/hosts/{host}/{hostPath:.*}", c.Authenticate(c.ForwardToHost))
+	// Infra route, it must be registered before the proxy routes which would match it otherwise.
+	router.Handle("/v1/zones/{zone}/hosts/{host}/infra_config", c.Authenticate(c.getInfraConfig)).Methods("GET")
+	// Host lifecycle routes, they must be registered before the proxy routes too.
+	router.Handle("/v1/zones/{zone}/hosts/{host}/:extend",
+		c.Authenticate(c.Audit("host.extend", RequireRole(accounts.RoleUser, c.extendHost)))).Methods("POST")
+	router.Handle("/v1/zones/{zone}/hosts/{host}/:stop",
+		c.Authenticate(c.Audit("host.stop", RequireRole(accounts.RoleUser, c.stopHost)))).Methods("POST")
+	router.Handle("/v1/zones/{zone}/hosts/{host}/:start",
+		c.Authenticate(c.Audit("host.start", RequireRole(accounts.RoleUser, c.startHost)))).Methods("POST")
+	router.Handle("/v1/zones/{zone}/hosts/{host}/:suspend",
+		c.Authenticate(c.Audit("host.suspend", RequireRole(accounts.RoleUser, c.suspendHost)))).Methods("POST")
+	router.Handle("/v1/zones/{zone}/hosts/{host}/:resume",
+		c.Authenticate(c.Audit("host.resume", RequireRole(accounts.RoleUser, c.resumeHost)))).Methods("POST")
 
-	// Infra route
-	router.HandleFunc("/v1/zones/{zone}/hosts/{host}/infra_config", func(w http.ResponseWriter, r *http.Request) {
-		// TODO(b/220891296): Make this configurable
-		replyJSON(w, c.InfraConfig(), http.StatusOK)
-	}).Methods("GET")
+	// Host Orchestrator Proxy Routes, read-only users can only make requests that don't modify the host.
+	router.Handle("/v1/zones/{zone}/hosts/{host}/{hostPath:.*}",
+		c.AuthenticateProxied(c.AuditWrites("host.proxy", RequireRoleToWrite(accounts.RoleUser, c.ForwardToHost))))
+
+	// Zone agnostic host routes, they are served by the zone routes above with the zone taken from the host
+	// handle, e.g. `/v1/hosts/us-central1-a~foo/cvds` is served as `/v1/zones/us-central1-a/hosts/foo/cvds`.
+	router.Handle("/v1/hosts/{handle}", serveByHostHandle(router))
+	router.Handle("/v1/hosts/{handle}/{hostPath:.*}", serveByHostHandle(router))
+
+	// Admin routes
+	// Lists the hosts of every user across all zones, the results are not paginated.
+	router.Handle("/v1/admin/hosts", c.Authenticate(RequireRole(accounts.RoleAdmin, c.listAllUsersHosts))).Methods("GET")
+	router.Handle("/v1/admin/zones/{zone}/hosts/{host}",
+		c.Authenticate(c.Audit("host.delete", RequireRole(accounts.RoleAdmin, c.forceDeleteHost)))).Methods("DELETE")
+	router.Handle("/v1/admin/credentials", c.Authenticate(RequireRole(accounts.RoleAdmin, c.listCredentials))).Methods("GET")
+	router.Handle("/v1/admin/credentials/{user}",
+		c.Authenticate(c.Audit("credentials.revoke", RequireRole(accounts.RoleAdmin, c.revokeCredentials)))).Methods("DELETE")
+	// Lists the audit log entries matching the query parameters, most recent first.
+	router.Handle("/v1/admin/audit", c.Authenticate(RequireRole(accounts.RoleAdmin, c.listAuditEntries))).Methods("GET")
+
+	// API token routes, the tokens authenticate the requests of non-interactive clients.
+	router.Handle("/v1/apitokens",
+		c.Authenticate(c.Audit("apitoken.create", RequireRole(accounts.RoleUser, c.createAPIToken)))).Methods("POST")
+	router.Handle("/v1/apitokens", c.Authenticate(c.listAPITokens)).Methods("GET")
+	router.Handle("/v1/apitokens/{id}",
+		c.Authenticate(c.Audit("apitoken.revoke", c.revokeAPIToken))).Methods("DELETE")
 
 	// Global routes
+	// The metrics reveal the names of every user's hosts, scrapers authenticate as admins, e.g. with an API token.
+	router.Handle("/metrics", c.Authenticate(RequireRole(accounts.RoleAdmin, serveMetrics))).Methods("GET")
 	router.Handle("/auth", HTTPHandler(c.AuthHandler)).Methods("GET")
 	router.Handle("/oauth2callback", HTTPHandler(c.OAuth2Callback))
 	router.Handle("/deauth", c.Authenticate(c.DeAuthHandler)).Methods("GET")
-	router.Handle("/deauth", c.Authenticate(c.RescindAuthorizationHandler)).Methods("POST")
+	router.Handle("/deauth", c.Authenticate(c.Audit("credentials.rescind", c.RescindAuthorizationHandler))).Methods("POST")
 	router.Handle("/v1/config", c.Authenticate(c.ConfigHandler)).Methods("GET")
 	router.Handle("/", c.Authenticate(indexHandler))
 
+	router.Use(recordRoute)
+
 	rootRouter := mux.NewRouter()
-	rootRouter.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	rootRouter.PathPrefix("/").Handler(logRequests(instrumentRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c.AddCorsHeaderIfNeeded(w, r)
 		if r.Method == "OPTIONS" {
 			w.Header().Add("Allow", allowedMethods)
@@ -133,7 +199,7 @@ func (c *App) Handler() http.Handler {
 		} else {
 			router.ServeHTTP(w, r)
 		}
-	}))
+	}))))
 
 	return rootRouter
 }
@@ -142,12 +208,25 @@ func (a *App) InfraConfig() apiv1.InfraConfig {
 	return a.infraConfig
 }
 
+func (a *App) getInfraConfig(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if err := a.instanceManager.AuthorizeHostAccess(getZone(r), user, getHost(r)); err != nil {
+		return err
+	}
+	// TODO(b/220891296): Make this configurable
+	replyJSON(w, a.InfraConfig(), http.StatusOK)
+	return nil
+}
+
 const (
 	headerNameCOInjectBuildAPICreds = "X-Cutf-Cloud-Orchestrator-Inject-BuildAPI-Creds"
 	headerNameHOBuildAPICreds       = "X-Cutf-Host-Orchestrator-BuildAPI-Creds"
 )
 
 func (a *App) ForwardToHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if err := a.instanceManager.AuthorizeHostAccess(getZone(r), user, getHost(r)); err != nil {
+		return err
+	}
+
 	hostPath := "/" + mux.Vars(r)["hostPath"]
 
 	if interceptFile, found := a.findInterceptFile(hostPath); found {
@@ -164,8 +243,25 @@ func (a *App) ForwardToHost(w http.ResponseWriter, r *http.Request, user account
 			return err
 		}
 	}
+	// The client's credentials are meant for the cloud orchestrator only.
+	r.Header.Del("Authorization")
+	zone, host := getZone(r), getHost(r)
+	proxy := hostClient.GetReverseProxy()
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxyErrors.Inc(zone, host)
+		logging.FromContext(r.Context()).Error("Failed proxying request to host", "zone", zone, "host", host, "error", err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	reqBody := &countingReadCloser{ReadCloser: http.NoBody}
+	if r.Body != nil {
+		reqBody.ReadCloser = r.Body
+	}
+	r.Body = reqBody
+	sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
 	r.URL.Path = hostPath
-	hostClient.GetReverseProxy().ServeHTTP(w, r)
+	proxy.ServeHTTP(sw, r)
+	proxyBytes.Add(float64(reqBody.n), zone, host, "request")
+	proxyBytes.Add(float64(sw.written), zone, host, "response")
 	return nil
 }
 
@@ -200,14 +296,14 @@ func (c *App) listZones(w http.ResponseWriter, r *http.Request, user accounts.Us
 
 func (c *App) createHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
 	var msg apiv1.CreateHostRequest
-	err := json.NewDecoder(r.Body).Decode(&msg)
-	if err != nil {
-		return apperr.NewBadRequestError("Malformed JSON in request", err)
+	if err := c.decodeJSONBody(r, &msg); err != nil {
+		return err
 	}
 	op, err := c.instanceManager.CreateHost(getZone(r), &msg, user)
 	if err != nil {
 		return err
 	}
+	c.startingOps.add(getZone(r), op.Name)
 	replyJSON(w, op, http.StatusOK)
 	return nil
 }
@@ -221,30 +317,210 @@ func (c *App) listHosts(w http.ResponseWriter, r *http.Request, user accounts.Us
 	if err != nil {
 		return err
 	}
+	setHostsZone(getZone(r), res.Items)
+	c.healthChecker.SetReadiness(getZone(r), res.Items)
 	replyJSON(w, res, http.StatusOK)
 	return nil
 }
 
+func (c *App) listAllHosts(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	listReq, err := BuildListHostsRequest(r)
+	if err != nil {
+		return err
+	}
+	return c.replyAllZonesHosts(w, user, listReq)
+}
+
+func (c *App) listAllUsersHosts(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	listReq, err := BuildListHostsRequest(r)
+	if err != nil {
+		return err
+	}
+	listReq.AllUsers = true
+	return c.replyAllZonesHosts(w, user, listReq)
+}
+
+// Replies with the hosts matching the request in every zone.
+func (c *App) replyAllZonesHosts(w http.ResponseWriter, user accounts.User, listReq *instances.ListHostsRequest) error {
+	listReq.PageToken = ""
+	zones, err := c.instanceManager.ListZones()
+	if err != nil {
+		return err
+	}
+	hosts := make([][]*apiv1.HostInstance, len(zones.Items))
+	errs := make([]error, len(zones.Items))
+	var wg sync.WaitGroup
+	for i, zone := range zones.Items {
+		wg.Add(1)
+		go func(i int, zone string) {
+			defer wg.Done()
+			hosts[i], errs[i] = c.listZoneHosts(zone, user, *listReq)
+		}(i, zone.Name)
+	}
+	wg.Wait()
+	res := &apiv1.ListHostsResponse{Items: []*apiv1.HostInstance{}}
+	for i, zone := range zones.Items {
+		if errs[i] != nil {
+			return fmt.Errorf("failed listing hosts in zone %q: %w", zone.Name, errs[i])
+		}
+		res.Items = append(res.Items, hosts[i]...)
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+// Lists the hosts matching the request in the given zone, going through all the result pages.
+func (c *App) listZoneHosts(zone string, user accounts.User, req instances.ListHostsRequest) ([]*apiv1.HostInstance, error) {
+	var hosts []*apiv1.HostInstance
+	for {
+		res, err := c.instanceManager.ListHosts(zone, user, &req)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, res.Items...)
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	setHostsZone(zone, hosts)
+	c.healthChecker.SetReadiness(zone, hosts)
+	return hosts, nil
+}
+
+func setHostsZone(zone string, hosts []*apiv1.HostInstance) {
+	for _, h := range hosts {
+		h.Zone = zone
+		h.Handle = apiv1.HostHandle(zone, h.Name)
+	}
+}
+
 func (c *App) deleteHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
-	name := mux.Vars(r)["host"]
-	res, err := c.instanceManager.DeleteHost(getZone(r), user, name)
+	res, err := c.instanceManager.DeleteHost(getZone(r), user, getHost(r))
 	if err != nil {
 		return err
 	}
+	forgetHostMetrics(getZone(r), getHost(r))
 	replyJSON(w, res, http.StatusOK)
 	return nil
 }
 
+// Deletes the host of any user, admins only act with their admin rights on the administration routes.
+func (c *App) forceDeleteHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	return c.deleteHost(w, r, accounts.WithAdminRights(user))
+}
+
+func (c *App) extendHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	var msg apiv1.ExtendHostRequest
+	if err := c.decodeJSONBody(r, &msg); err != nil {
+		return err
+	}
+	op, err := c.instanceManager.ExtendHost(getZone(r), user, getHost(r), &msg)
+	if err != nil {
+		return err
+	}
+	replyJSON(w, op, http.StatusOK)
+	return nil
+}
+
+func (c *App) stopHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	return c.doHostOperation(w, r, user, c.instanceManager.StopHost)
+}
+
+func (c *App) startHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	return c.doHostOperation(w, r, user, c.startingOps.track(c.instanceManager.StartHost))
+}
+
+func (c *App) suspendHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	return c.doHostOperation(w, r, user, c.instanceManager.SuspendHost)
+}
+
+func (c *App) resumeHost(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	return c.doHostOperation(w, r, user, c.startingOps.track(c.instanceManager.ResumeHost))
+}
+
+type hostOperationFunc func(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+
+func (c *App) doHostOperation(w http.ResponseWriter, r *http.Request, user accounts.User, fn hostOperationFunc) error {
+	op, err := fn(getZone(r), user, getHost(r))
+	if err != nil {
+		return err
+	}
+	replyJSON(w, op, http.StatusOK)
+	return nil
+}
+
 func (c *App) waitOperation(w http.ResponseWriter, r *http.Request, user accounts.User) error {
 	name := mux.Vars(r)["operation"]
 	op, err := c.instanceManager.WaitOperation(getZone(r), user, name)
 	if err != nil {
 		return err
 	}
+	if host, ok := op.(*apiv1.HostInstance); ok {
+		zone := getZone(r)
+		setHostsZone(zone, []*apiv1.HostInstance{host})
+		// Creating, starting or resuming a host is done once the host can actually be used.
+		if c.startingOps.has(zone, name) && host.Status == apiv1.HostStatusRunning {
+			if !c.healthChecker.WaitUntilReady(zone, host.Name, hostReadyWaitTimeout) {
+				return apperr.NewServiceUnavailableError(fmt.Sprintf("Host %q is not ready yet", host.Name), nil)
+			}
+			c.startingOps.remove(zone, name)
+			host.Ready = true
+		} else {
+			c.healthChecker.SetReadiness(zone, []*apiv1.HostInstance{host})
+		}
+	}
 	replyJSON(w, op, http.StatusOK)
 	return nil
 }
 
+// Keeps track of the operations creating, starting or resuming hosts, waiting for them also waits for the hosts
+// to be ready. Operations are only known to the replica that started them, waiting on other replicas returns as
+// soon as the operation is done.
+type startingOperations struct {
+	mu  sync.Mutex
+	ops map[string]time.Time
+}
+
+func newStartingOperations() *startingOperations {
+	return &startingOperations{ops: make(map[string]time.Time)}
+}
+
+func (s *startingOperations) add(zone, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.ops {
+		if time.Since(t) > startingOpRetention {
+			delete(s.ops, key)
+		}
+	}
+	s.ops[zone+"/"+name] = time.Now()
+}
+
+func (s *startingOperations) has(zone, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.ops[zone+"/"+name]
+	return ok
+}
+
+func (s *startingOperations) remove(zone, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.ops, zone+"/"+name)
+}
+
+// Wraps the host operation so that the operations it starts are tracked.
+func (s *startingOperations) track(fn hostOperationFunc) hostOperationFunc {
+	return func(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+		op, err := fn(zone, user, name)
+		if err == nil {
+			s.add(zone, op.Name)
+		}
+		return op, err
+	}
+}
+
 func (c *App) AuthHandler(w http.ResponseWriter, r *http.Request) error {
 	state := randomHexString()
 	s := session.Session{
@@ -279,7 +555,16 @@ func (c *App) OAuth2Callback(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	if err := c.storeUserCredentials(user, tk); err != nil {
+	if user == nil {
+		return apperr.NewUnauthenticatedError("Authentication required", nil)
+	}
+	// Credentials of users not allowed to use the service aren't stored.
+	if err := accounts.Admit(user, &c.config.AccountManager.Admission); err != nil {
+		return err
+	}
+	err = c.storeUserCredentials(user, tk)
+	c.recordAudit(newAuditEntry(r, user, "credentials.authorize"), errorStatusCode(err), err)
+	if err != nil {
 		return err
 	}
 	// Don't return a real page here since any resource (i.e JS module) will have access to the
@@ -378,7 +663,7 @@ func (a *App) RescindAuthorizationHandler(w http.ResponseWriter, r *http.Request
 	}
 	defer func() {
 		if err := a.databaseService.DeleteBuildAPICredentials(user.Username()); err != nil {
-			log.Printf("Failed to delete credentials from database: %v", err)
+			logging.FromContext(r.Context()).Error("Failed to delete credentials from database", "error", err)
 		}
 	}()
 	if err := a.oauth2Helper.Revoke(tk); err != nil {
@@ -388,6 +673,201 @@ func (a *App) RescindAuthorizationHandler(w http.ResponseWriter, r *http.Request
 	return nil
 }
 
+func (a *App) listCredentials(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	usernames, err := a.databaseService.ListBuildAPICredentialsUsernames()
+	if err != nil {
+		return fmt.Errorf("Error listing user credentials: %w", err)
+	}
+	replyJSON(w, &apiv1.ListCredentialsResponse{Usernames: usernames}, http.StatusOK)
+	return nil
+}
+
+func (a *App) revokeCredentials(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	username := mux.Vars(r)["user"]
+	encryptedCreds, err := a.databaseService.FetchBuildAPICredentials(username)
+	if err != nil {
+		return fmt.Errorf("Error getting user credentials: %w", err)
+	}
+	if encryptedCreds == nil {
+		return apperr.NewNotFoundError(fmt.Sprintf("No credentials found for user %q", username), nil)
+	}
+	// The credentials are deleted even if they can't be revoked, the user has to authorize the system again
+	// either way.
+	if err := a.revokeStoredCredentials(encryptedCreds); err != nil {
+		logging.FromContext(r.Context()).Warn("Failed to revoke credentials", "user", username, "error", err)
+	}
+	if err := a.databaseService.DeleteBuildAPICredentials(username); err != nil {
+		return fmt.Errorf("Failed to delete credentials: %w", err)
+	}
+	replyJSON(w, struct{}{}, http.StatusOK)
+	return nil
+}
+
+func (a *App) revokeStoredCredentials(encryptedCreds []byte) error {
+	creds, err := a.encryptionService.Decrypt(encryptedCreds)
+	if err != nil {
+		return err
+	}
+	tk := &oauth2.Token{}
+	if err := json.Unmarshal(creds, tk); err != nil {
+		return fmt.Errorf("Error deserializing token: %w", err)
+	}
+	return a.oauth2Helper.Revoke(tk)
+}
+
+const (
+	queryParamUser   = "user"
+	queryParamAction = "action"
+	queryParamZone   = "zone"
+	queryParamHost   = "host"
+	queryParamSince  = "since"
+	queryParamUntil  = "until"
+	queryParamLimit  = "limit"
+	// Number of audit entries returned when no limit is given.
+	defaultAuditEntriesLimit = 100
+)
+
+func (a *App) listAuditEntries(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if a.auditSink == nil {
+		return apperr.NewNotFoundError("Audit logging is disabled", nil)
+	}
+	query := r.URL.Query()
+	q := &audit.Query{
+		User:   query.Get(queryParamUser),
+		Action: query.Get(queryParamAction),
+		Zone:   query.Get(queryParamZone),
+		Host:   query.Get(queryParamHost),
+		Limit:  defaultAuditEntriesLimit,
+	}
+	var err error
+	if q.Since, err = timeQueryParam(r, queryParamSince); err != nil {
+		return err
+	}
+	if q.Until, err = timeQueryParam(r, queryParamUntil); err != nil {
+		return err
+	}
+	if value := query.Get(queryParamLimit); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return newInvalidQueryParamError(queryParamLimit, value, err)
+		}
+		q.Limit = limit
+	}
+	entries, err := a.auditSink.ListAuditEntries(q)
+	if err != nil {
+		return err
+	}
+	replyJSON(w, &apiv1.ListAuditEntriesResponse{Items: entries}, http.StatusOK)
+	return nil
+}
+
+// Parses an RFC 3339 time query parameter, zero if missing.
+func timeQueryParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, newInvalidQueryParamError(name, value, err)
+	}
+	return t, nil
+}
+
+func (a *App) checkAPITokensEnabled() error {
+	if a.config.AccountManager.APITokens == nil {
+		return apperr.NewNotFoundError("API tokens are disabled", nil)
+	}
+	return nil
+}
+
+func (a *App) createAPIToken(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if err := a.checkAPITokensEnabled(); err != nil {
+		return err
+	}
+	// Otherwise a leaked token could outlive its revocation and expiration through the tokens it creates.
+	if accounts.IsAPITokenUser(user) {
+		return apperr.NewForbiddenError("API tokens can't be created with an API token", nil)
+	}
+	var msg apiv1.CreateAPITokenRequest
+	if err := a.decodeJSONBody(r, &msg); err != nil {
+		return err
+	}
+	if msg.TTLSeconds < 0 {
+		return apperr.NewBadRequestError("Invalid CreateAPITokenRequest: negative ttl", nil)
+	}
+	ttl := time.Duration(msg.TTLSeconds) * time.Second
+	if maxTTLDays := a.config.AccountManager.APITokens.MaxTTLDays; maxTTLDays > 0 {
+		maxTTL := time.Duration(maxTTLDays) * 24 * time.Hour
+		if ttl == 0 || ttl > maxTTL {
+			return apperr.NewBadRequestError(
+				fmt.Sprintf("Invalid CreateAPITokenRequest: ttl must be set and at most %d days", maxTTLDays), nil)
+		}
+	}
+	secret, err := apitokens.Generate()
+	if err != nil {
+		return fmt.Errorf("Failed to generate API token: %w", err)
+	}
+	now := time.Now()
+	t := &apitokens.Token{
+		ID:           uuid.New().String(),
+		Username:     user.Username(),
+		Email:        accounts.UserEmail(user),
+		Name:         msg.Name,
+		Hash:         apitokens.Hash(secret),
+		CreationTime: now,
+	}
+	if ttl > 0 {
+		t.ExpirationTime = now.Add(ttl)
+	}
+	if err := a.databaseService.CreateAPIToken(t); err != nil {
+		return fmt.Errorf("Failed to store API token: %w", err)
+	}
+	res := t.ToAPI()
+	res.Token = secret
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+func (a *App) listAPITokens(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if err := a.checkAPITokensEnabled(); err != nil {
+		return err
+	}
+	tokens, err := a.databaseService.ListAPITokens(user.Username())
+	if err != nil {
+		return err
+	}
+	res := &apiv1.ListAPITokensResponse{Items: []*apiv1.APIToken{}}
+	for _, t := range tokens {
+		res.Items = append(res.Items, t.ToAPI())
+	}
+	replyJSON(w, res, http.StatusOK)
+	return nil
+}
+
+func (a *App) revokeAPIToken(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+	if err := a.checkAPITokensEnabled(); err != nil {
+		return err
+	}
+	id := mux.Vars(r)["id"]
+	tokens, err := a.databaseService.ListAPITokens(user.Username())
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, t := range tokens {
+		found = found || t.ID == id
+	}
+	if !found {
+		return apperr.NewNotFoundError(fmt.Sprintf("API token %q not found", id), nil)
+	}
+	if err := a.databaseService.DeleteAPIToken(user.Username(), id); err != nil {
+		return fmt.Errorf("Failed to delete API token: %w", err)
+	}
+	replyJSON(w, struct{}{}, http.StatusOK)
+	return nil
+}
+
 func (a *App) ConfigHandler(w http.ResponseWriter, r *http.Request, user accounts.User) error {
 	res := apiv1.Config{
 		InstanceManagerType: string(a.config.InstanceManager.Type),
@@ -454,7 +934,7 @@ func (c *App) fetchUserCredentials(user accounts.User) (*oauth2.Token, error) {
 		// It's unlikely to be able to recover from this error in the future, the best approach is
 		// probably to delete the user credentials and ask for authorization again.
 		if err := c.databaseService.DeleteBuildAPICredentials(user.Username()); err != nil {
-			log.Println("Error deleting user credentials: ", err)
+			logging.Default().Error("Error deleting user credentials", "user", user.Username(), "error", err)
 		}
 		return nil, err
 	}
@@ -462,7 +942,7 @@ func (c *App) fetchUserCredentials(user accounts.User) (*oauth2.Token, error) {
 	if err := json.Unmarshal(creds, tk); err != nil {
 		// This is also likely unrecoverable.
 		if err := c.databaseService.DeleteBuildAPICredentials(user.Username()); err != nil {
-			log.Println("Error deleting user credentials: ", err)
+			logging.Default().Error("Error deleting user credentials", "user", user.Username(), "error", err)
 		}
 		return nil, fmt.Errorf("Error deserializing token: %w", err)
 	}
@@ -471,11 +951,13 @@ func (c *App) fetchUserCredentials(user accounts.User) (*oauth2.Token, error) {
 		tks := c.oauth2Helper.TokenSource(context.TODO(), tk)
 		tk, err = tks.Token()
 		if err != nil {
+			oauth2TokenRefreshes.Inc("failure")
 			return nil, fmt.Errorf("Error refreshing token: %w", err)
 		}
+		oauth2TokenRefreshes.Inc("success")
 		if err := c.storeUserCredentials(user, tk); err != nil {
 			// This won't stop the current operation, but will force a refresh in future requests.
-			log.Println("Error storing refreshed tokens: ", err)
+			logging.Default().Error("Error storing refreshed tokens", "user", user.Username(), "error", err)
 		}
 	}
 	return tk, nil
@@ -488,6 +970,16 @@ func (c *App) fetchUserCredentials(user accounts.User) (*oauth2.Token, error) {
 // authenticated, otherwise it may choose to return an error or respond with
 // an HTTP redirect to the login page.
 func (a *App) Authenticate(fn AuthHTTPHandler) HTTPHandler {
+	return a.authenticate(a.controlPlaneLimiter, fn)
+}
+
+// Like Authenticate, but the requests count towards the proxied traffic rate limit instead of the control
+// plane one.
+func (a *App) AuthenticateProxied(fn AuthHTTPHandler) HTTPHandler {
+	return a.authenticate(a.proxyLimiter, fn)
+}
+
+func (a *App) authenticate(limiter *ratelimit.Limiter, fn AuthHTTPHandler) HTTPHandler {
 	return func(w http.ResponseWriter, r *http.Request) error {
 		user, err := a.accountManager.UserFromRequest(r)
 		if err != nil {
@@ -496,21 +988,209 @@ func (a *App) Authenticate(fn AuthHTTPHandler) HTTPHandler {
 		if user == nil {
 			return apperr.NewUnauthenticatedError("Authentication required", nil)
 		}
+		if err := accounts.Admit(user, &a.config.AccountManager.Admission); err != nil {
+			return err
+		}
+		if ok, wait := limiter.Allow(user.Username()); !ok {
+			return apperr.NewRateLimitedError("Rate limit exceeded, retry later", wait)
+		}
+		user = accounts.ConfigureUser(user, &a.config.AccountManager)
+		return fn(w, r, user)
+	}
+}
+
+// Decodes the JSON body of the request into v. Bodies bigger than the configured limit are rejected.
+func (a *App) decodeJSONBody(r *http.Request, v any) error {
+	limit := a.config.RateLimit.RequestBodyLimit()
+	body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return apperr.NewBadRequestError("Failed reading request body", err)
+	}
+	if int64(len(body)) > limit {
+		return apperr.NewRequestEntityTooLargeError(fmt.Sprintf("Request body exceeds %d bytes", limit), nil)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return apperr.NewBadRequestError("Malformed JSON in request", err)
+	}
+	return nil
+}
+
+// Returns the received handler wrapped in another that only passes the request to it if the user's role
+// grants the permissions of the given role.
+func RequireRole(role accounts.Role, fn AuthHTTPHandler) AuthHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+		if !accounts.HasRole(user, role) {
+			return apperr.NewForbiddenError(fmt.Sprintf("The %q role is required", role), nil)
+		}
 		return fn(w, r, user)
 	}
 }
 
+// Like RequireRole, but requests that don't modify anything, i.e. GET and HEAD requests, are passed to the
+// handler regardless of the user's role.
+func RequireRoleToWrite(role accounts.Role, fn AuthHTTPHandler) AuthHTTPHandler {
+	guarded := RequireRole(role, fn)
+	return func(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return fn(w, r, user)
+		}
+		return guarded(w, r, user)
+	}
+}
+
+// Returns the received handler wrapped in another that records the request and its outcome in the audit
+// log under the given action.
+func (a *App) Audit(action string, fn AuthHTTPHandler) AuthHTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+		if a.auditSink == nil {
+			return fn(w, r, user)
+		}
+		// Built before calling the handler, which may modify the request.
+		e := newAuditEntry(r, user, action)
+		sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		err := fn(sw, r, user)
+		statusCode := sw.statusCode
+		if err != nil {
+			statusCode = errorStatusCode(err)
+		}
+		a.recordAudit(e, statusCode, err)
+		return err
+	}
+}
+
+// Like Audit, but only requests that may modify something, i.e. other than GET and HEAD requests, are
+// recorded.
+func (a *App) AuditWrites(action string, fn AuthHTTPHandler) AuthHTTPHandler {
+	audited := a.Audit(action, fn)
+	return func(w http.ResponseWriter, r *http.Request, user accounts.User) error {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return fn(w, r, user)
+		}
+		return audited(w, r, user)
+	}
+}
+
+func newAuditEntry(r *http.Request, user accounts.User, action string) *apiv1.AuditEntry {
+	return &apiv1.AuditEntry{
+		Time:      time.Now().UTC(),
+		RequestID: requestID(r),
+		User:      user.Username(),
+		Action:    action,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Zone:      getZone(r),
+		Host:      getHost(r),
+	}
+}
+
+// Sets the outcome of the entry and stores it. Failing to store the entry doesn't fail the request, whatever
+// it did is already done.
+func (a *App) recordAudit(e *apiv1.AuditEntry, statusCode int, err error) {
+	if a.auditSink == nil {
+		return
+	}
+	e.StatusCode = statusCode
+	e.Outcome = apiv1.AuditOutcomeSuccess
+	if err != nil || statusCode >= http.StatusBadRequest {
+		e.Outcome = apiv1.AuditOutcomeFailure
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if err := a.auditSink.StoreAuditEntry(e); err != nil {
+		logging.Default().Error("Failed to record audit entry", "request_id", e.RequestID, "entry", e, "error", err)
+	}
+}
+
+const headerNameRequestID = "X-Request-Id"
+
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Returns the ID of the request, one is generated and added to the request if it doesn't have a valid one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(headerNameRequestID)
+	if !requestIDRe.MatchString(id) {
+		id = randomHexString()[:32]
+		r.Header.Set(headerNameRequestID, id)
+	}
+	return id
+}
+
+// Records the status code and the size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	written     int64
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if !w.wroteHeader {
+		w.statusCode = statusCode
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Allows connection upgrades, e.g. to websockets, through the wrapper.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
+	}
+	return h.Hijack()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Returns the status code of the error response, 200 if there is no error.
+func errorStatusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	var e *apperr.AppError
+	if errors.As(err, &e) {
+		return e.StatusCode
+	}
+	return http.StatusInternalServerError
+}
+
 type AuthHTTPHandler func(http.ResponseWriter, *http.Request, accounts.User) error
 type HTTPHandler func(http.ResponseWriter, *http.Request) error
 
 // Intercept errors returned by the HTTPHandler and transform them into HTTP
 // error responses
 func (h HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	log.Println(r.Method, " ", r.URL, " ", r.RemoteAddr)
 	if err := h(w, r); err != nil {
-		log.Println("Error: ", err)
+		level := logging.LevelError
+		if errorStatusCode(err) < http.StatusInternalServerError {
+			level = logging.LevelWarn
+		}
+		logging.FromContext(r.Context()).Log(level, "Request failed", "error", err)
 		var e *apperr.AppError
 		if errors.As(err, &e) {
+			if e.RetryAfter > 0 {
+				// Rounded up, so clients don't retry too early.
+				w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(e.RetryAfter.Seconds())), 10))
+			}
+			if e.Challenge != "" {
+				w.Header().Set("WWW-Authenticate", e.Challenge)
+			}
 			replyJSON(w, e.JSONResponse(), e.StatusCode)
 		} else {
 			replyJSON(w, apiv1.Error{ErrorMsg: "Internal Server Error"}, http.StatusInternalServerError)
@@ -542,7 +1222,8 @@ func randomHexString() string {
 }
 
 const (
-	queryParamMaxResults = "maxResults"
+	queryParamMaxResults    = "maxResults"
+	queryParamLabelSelector = "labelSelector"
 )
 
 func BuildListHostsRequest(r *http.Request) (*instances.ListHostsRequest, error) {
@@ -551,9 +1232,15 @@ func BuildListHostsRequest(r *http.Request) (*instances.ListHostsRequest, error)
 	if err != nil {
 		return nil, newInvalidQueryParamError(queryParamMaxResults, maxResultsRaw, err)
 	}
+	selector, err := instances.ParseLabelSelector(r.URL.Query().Get(queryParamLabelSelector))
+	if err != nil {
+		return nil, err
+	}
 	res := &instances.ListHostsRequest{
-		MaxResults: maxResults,
-		PageToken:  r.URL.Query().Get("pageToken"),
+		MaxResults:    maxResults,
+		PageToken:     r.URL.Query().Get("pageToken"),
+		Status:        r.URL.Query().Get("status"),
+		LabelSelector: selector,
 	}
 	return res, nil
 }
@@ -621,12 +1308,35 @@ func replyJSON(w http.ResponseWriter, obj any, statusCode int) error {
 	return encoder.Encode(obj)
 }
 
+// Hosts may be referred to by handle in zone routes too, the zone in the handle takes precedence then.
 func getZone(r *http.Request) string {
+	if zone, _, ok := apiv1.ParseHostHandle(mux.Vars(r)["host"]); ok {
+		return zone
+	}
 	return mux.Vars(r)["zone"]
 }
 
 func getHost(r *http.Request) string {
-	return mux.Vars(r)["host"]
+	host := mux.Vars(r)["host"]
+	if _, name, ok := apiv1.ParseHostHandle(host); ok {
+		return name
+	}
+	return host
+}
+
+// Returns a handler rewriting requests to zone agnostic host routes into the equivalent zone route.
+func serveByHostHandle(router http.Handler) HTTPHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		handle := mux.Vars(r)["handle"]
+		zone, host, ok := apiv1.ParseHostHandle(handle)
+		if !ok {
+			return apperr.NewBadRequestError(fmt.Sprintf("Invalid host handle: %q", handle), nil)
+		}
+		r.URL.Path = "/v1/zones/" + zone + "/hosts/" + host + strings.TrimPrefix(r.URL.Path, "/v1/hosts/"+handle)
+		r.URL.RawPath = ""
+		router.ServeHTTP(w, r)
+		return nil
+	}
 }
 
 func notAllowedHttpHandler(w http.ResponseWriter, r *http.Request) error {
@@ -24,6 +24,7 @@ import (
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -36,9 +37,12 @@ import (
 	"github.com/google/cloud-android-orchestration/pkg/app/encryption"
 	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
 	"github.com/google/cloud-android-orchestration/pkg/app/instances"
+	"github.com/google/cloud-android-orchestration/pkg/app/instances/pluginstub"
 	appOAuth2 "github.com/google/cloud-android-orchestration/pkg/app/oauth2"
+	"github.com/google/cloud-android-orchestration/pkg/app/ratelimit"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/oauth2"
 )
@@ -63,6 +67,16 @@ func (m *testAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Req
 
 type testInstanceManager struct {
 	hostClientFactory func(zone, host string) instances.HostClient
+	// Hosts the user is not allowed to access.
+	deniedHosts []string
+	// Result of every operation, an empty object if nil.
+	waitOperationResult any
+	// Hosts by zone, every zone is listed.
+	hosts map[string][]string
+	// Hosts of other users by zone, only listed when the hosts of every user are requested.
+	otherUsersHosts map[string][]string
+	// Whether each deleted host was deleted with admin rights, by host name.
+	deletedWithAdminRights map[string]bool
 }
 
 func (m *testInstanceManager) GetHostURL(zone string, host string) (*url.URL, error) {
@@ -70,7 +84,12 @@ func (m *testInstanceManager) GetHostURL(zone string, host string) (*url.URL, er
 }
 
 func (m *testInstanceManager) ListZones() (*apiv1.ListZonesResponse, error) {
-	return &apiv1.ListZonesResponse{}, nil
+	res := &apiv1.ListZonesResponse{}
+	for zone := range m.hosts {
+		res.Items = append(res.Items, &apiv1.Zone{Name: zone})
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Name < res.Items[j].Name })
+	return res, nil
 }
 
 func (m *testInstanceManager) CreateHost(_ string, _ *apiv1.CreateHostRequest, _ accounts.User) (*apiv1.Operation, error) {
@@ -78,17 +97,64 @@ func (m *testInstanceManager) CreateHost(_ string, _ *apiv1.CreateHostRequest, _
 }
 
 func (m *testInstanceManager) ListHosts(zone string, user accounts.User, req *instances.ListHostsRequest) (*apiv1.ListHostsResponse, error) {
-	return &apiv1.ListHostsResponse{}, nil
+	res := &apiv1.ListHostsResponse{}
+	names := m.hosts[zone]
+	if req.AllUsers {
+		names = append(append([]string{}, names...), m.otherUsersHosts[zone]...)
+	}
+	for _, name := range names {
+		res.Items = append(res.Items, &apiv1.HostInstance{Name: name, Status: apiv1.HostStatusStopped})
+	}
+	return res, nil
 }
 
 func (m *testInstanceManager) DeleteHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	if m.deletedWithAdminRights != nil {
+		m.deletedWithAdminRights[name] = accounts.HasAdminRights(user)
+	}
 	return &apiv1.Operation{}, nil
 }
 
+func (m *testInstanceManager) ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error) {
+	return &apiv1.Operation{Name: "extend-" + name}, nil
+}
+
+func (m *testInstanceManager) DeleteExpiredHosts() error {
+	return nil
+}
+
+func (m *testInstanceManager) StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return &apiv1.Operation{Name: "stop-" + name}, nil
+}
+
+func (m *testInstanceManager) StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return &apiv1.Operation{Name: "start-" + name}, nil
+}
+
+func (m *testInstanceManager) SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return &apiv1.Operation{Name: "suspend-" + name}, nil
+}
+
+func (m *testInstanceManager) ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return &apiv1.Operation{Name: "resume-" + name}, nil
+}
+
 func (m *testInstanceManager) WaitOperation(_ string, _ accounts.User, _ string) (any, error) {
+	if m.waitOperationResult != nil {
+		return m.waitOperationResult, nil
+	}
 	return struct{}{}, nil
 }
 
+func (m *testInstanceManager) AuthorizeHostAccess(zone string, user accounts.User, name string) error {
+	for _, h := range m.deniedHosts {
+		if h == name {
+			return apperr.NewNotFoundError("host not found", nil)
+		}
+	}
+	return nil
+}
+
 func (m *testInstanceManager) GetHostClient(zone string, host string) (instances.HostClient, error) {
 	return m.hostClientFactory(zone, host), nil
 }
@@ -110,7 +176,7 @@ func (hc *testHostClient) GetReverseProxy() *httputil.ReverseProxy {
 }
 
 func TestListZonesSucceeds(t *testing.T) {
-	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 	ts := httptest.NewServer(controller.Handler())
 	defer ts.Close()
 
@@ -123,7 +189,7 @@ func TestListZonesSucceeds(t *testing.T) {
 }
 
 func TestCreateHostSucceeds(t *testing.T) {
-	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 	ts := httptest.NewServer(controller.Handler())
 	defer ts.Close()
 
@@ -137,7 +203,7 @@ func TestCreateHostSucceeds(t *testing.T) {
 }
 
 func TestWaitOperatioSucceeds(t *testing.T) {
-	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 	ts := httptest.NewServer(controller.Handler())
 	defer ts.Close()
 
@@ -150,6 +216,770 @@ func TestWaitOperatioSucceeds(t *testing.T) {
 	}
 }
 
+func TestExtendHostSucceeds(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/:extend",
+		strings.NewReader(`{"ttl_seconds": 3600}`))
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.Operation
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(apiv1.Operation{Name: "extend-bar"}, got); diff != "" {
+		t.Errorf("operation mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWaitOperationWaitsUntilHostReady(t *testing.T) {
+	im := &testInstanceManager{
+		waitOperationResult: &apiv1.HostInstance{Name: "bar", Status: apiv1.HostStatusRunning},
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{}
+		},
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/:start", nil)
+	makeRequest(httptest.NewRecorder(), req, controller)
+	w := httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/operations/start-bar/:wait", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.HostInstance
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := apiv1.HostInstance{Name: "bar", Zone: "foo", Handle: "foo~bar", Status: apiv1.HostStatusRunning, Ready: true}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("host mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWaitOperationDoesNotWaitForExtendedHostsToBeReady(t *testing.T) {
+	im := &testInstanceManager{
+		waitOperationResult: &apiv1.HostInstance{Name: "bar", Status: apiv1.HostStatusRunning},
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{}
+		},
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/:extend",
+		strings.NewReader(`{"ttl_seconds": 3600}`))
+	makeRequest(httptest.NewRecorder(), req, controller)
+	w := httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/operations/extend-bar/:wait", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.HostInstance
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	// The host is only probed in the background, it's not known to be ready yet.
+	want := apiv1.HostInstance{Name: "bar", Zone: "foo", Handle: "foo~bar", Status: apiv1.HostStatusRunning}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("host mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWaitOperationCompletesPluginHosts(t *testing.T) {
+	// Answers 404 to the health checks, the host orchestrator is up.
+	hostOrchestrator := httptest.NewServer(http.NotFoundHandler())
+	defer hostOrchestrator.Close()
+	plugin := httptest.NewServer(pluginstub.NewStub(hostOrchestrator.URL))
+	defer plugin.Close()
+	im, err := instances.NewPluginInstanceManager(instances.Config{Plugin: &instances.PluginIMConfig{Endpoint: plugin.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/"+pluginstub.Zone+"/hosts",
+		strings.NewReader(`{"host_instance": {}}`))
+	makeRequest(w, req, controller)
+	var op apiv1.Operation
+	if err := json.NewDecoder(w.Result().Body).Decode(&op); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodPost,
+		"http://test.com/v1/zones/"+pluginstub.Zone+"/operations/"+op.Name+"/:wait", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.HostInstance
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Zone != pluginstub.Zone || got.Handle != pluginstub.Zone+"~"+got.Name || !got.Ready {
+		t.Errorf("expected host with zone, handle and readiness set, got: %+v", got)
+	}
+}
+
+func TestListAllHostsSucceeds(t *testing.T) {
+	im := &testInstanceManager{
+		hosts: map[string][]string{
+			"us-central1-a":  {"foo", "bar"},
+			"us-central1-b":  {},
+			"europe-west1-b": {"baz"},
+		},
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/hosts", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.ListHostsResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := apiv1.ListHostsResponse{
+		Items: []*apiv1.HostInstance{
+			{Name: "baz", Zone: "europe-west1-b", Handle: "europe-west1-b~baz", Status: apiv1.HostStatusStopped},
+			{Name: "foo", Zone: "us-central1-a", Handle: "us-central1-a~foo", Status: apiv1.HostStatusStopped},
+			{Name: "bar", Zone: "us-central1-a", Handle: "us-central1-a~bar", Status: apiv1.HostStatusStopped},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func withRole(role accounts.Role) *config.Config {
+	return &config.Config{
+		AccountManager: accounts.Config{Roles: map[string]accounts.Role{testUsername: role}},
+	}
+}
+
+func TestAdminRoutesRequireAdminRole(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, database.NewInMemoryDBService(), nil,
+		"", nil, config.WebRTCConfig{}, &config.Config{})
+	tests := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodGet, "http://test.com/v1/admin/hosts"},
+		{http.MethodDelete, "http://test.com/v1/admin/zones/foo/hosts/bar"},
+		{http.MethodGet, "http://test.com/v1/admin/credentials"},
+		{http.MethodDelete, "http://test.com/v1/admin/credentials/janedoe"},
+		{http.MethodGet, "http://test.com/metrics"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tc.method, tc.url, nil)
+
+			makeRequest(w, req, controller)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
+
+func TestReadOnlyUserCannotModifyHosts(t *testing.T) {
+	im := &testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{&url.URL{Scheme: "http", Host: "127.0.0.1:0"}}
+		},
+		hosts: map[string][]string{"foo": {"bar"}},
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, withRole(accounts.RoleReadOnly))
+	forbidden := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodPost, "http://test.com/v1/zones/foo/hosts"},
+		{http.MethodDelete, "http://test.com/v1/zones/foo/hosts/bar"},
+		{http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/:stop"},
+		{http.MethodPost, "http://test.com/v1/hosts/foo~bar/:extend"},
+		{http.MethodPost, "http://test.com/v1/zones/foo/operations/baz/:wait"},
+		{http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/cvds"},
+		{http.MethodPost, "http://test.com/v1/apitokens"},
+	}
+	for _, tc := range forbidden {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tc.method, tc.url, strings.NewReader("{}"))
+
+			makeRequest(w, req, controller)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusForbidden)
+			}
+		})
+	}
+	for _, reqURL := range []string{"http://test.com/v1/zones/foo/hosts", "http://test.com/v1/hosts"} {
+		t.Run("GET "+reqURL, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, reqURL, nil)
+
+			makeRequest(w, req, controller)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestAdminListsEveryUsersHosts(t *testing.T) {
+	im := &testInstanceManager{
+		hosts:           map[string][]string{"us-central1-a": {"foo"}},
+		otherUsersHosts: map[string][]string{"us-central1-a": {"bar"}},
+	}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, withRole(accounts.RoleAdmin))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/admin/hosts", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.ListHostsResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := apiv1.ListHostsResponse{
+		Items: []*apiv1.HostInstance{
+			{Name: "foo", Zone: "us-central1-a", Handle: "us-central1-a~foo", Status: apiv1.HostStatusStopped},
+			{Name: "bar", Zone: "us-central1-a", Handle: "us-central1-a~bar", Status: apiv1.HostStatusStopped},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOnlyAdminRoutesActWithAdminRights(t *testing.T) {
+	im := &testInstanceManager{deletedWithAdminRights: map[string]bool{}}
+	controller := NewApp(im, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, withRole(accounts.RoleAdmin))
+
+	for _, url := range []string{
+		"http://test.com/v1/zones/foo/hosts/bar",
+		"http://test.com/v1/admin/zones/foo/hosts/baz",
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodDelete, url, nil)
+
+		makeRequest(w, req, controller)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status code <<%d>> for %s, want: %d", w.Code, url, http.StatusOK)
+		}
+	}
+
+	want := map[string]bool{"bar": false, "baz": true}
+	if diff := cmp.Diff(want, im.deletedWithAdminRights); diff != "" {
+		t.Errorf("admin rights mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAdminRevokesCredentials(t *testing.T) {
+	es := encryption.NewFakeEncryptionService()
+	dbs := database.NewInMemoryDBService()
+	for _, username := range []string{"janedoe", "johndoe"} {
+		creds, _ := json.Marshal(&oauth2.Token{AccessToken: "token-" + username})
+		encrypted, err := es.Encrypt(creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbs.StoreBuildAPICredentials(username, encrypted)
+	}
+	revoked := []string{}
+	oauth2Helper := &appOAuth2.Helper{Revoke: func(tk *oauth2.Token) error {
+		revoked = append(revoked, tk.AccessToken)
+		return nil
+	}}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, oauth2Helper, es, dbs, nil, "", nil,
+		config.WebRTCConfig{}, withRole(accounts.RoleAdmin))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "http://test.com/v1/admin/credentials/janedoe", nil)
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	if diff := cmp.Diff([]string{"token-janedoe"}, revoked); diff != "" {
+		t.Errorf("revoked tokens mismatch (-want +got):\n%s", diff)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "http://test.com/v1/admin/credentials", nil)
+	makeRequest(w, req, controller)
+	var got apiv1.ListCredentialsResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(apiv1.ListCredentialsResponse{Usernames: []string{"johndoe"}}, got); diff != "" {
+		t.Errorf("credentials mismatch (-want +got):\n%s", diff)
+	}
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodDelete, "http://test.com/v1/admin/credentials/janedoe", nil)
+	makeRequest(w, req, controller)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestAuditRecordsStateChangingRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	im := &testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{hostURL}
+		},
+	}
+	dbs := database.NewInMemoryDBService()
+	controller := NewApp(im, &testAccountManager{}, nil, nil, dbs, dbs, "", nil, config.WebRTCConfig{}, withRole(accounts.RoleAdmin))
+	requests := []struct {
+		method string
+		url    string
+	}{
+		{http.MethodDelete, "http://test.com/v1/hosts/foo~bar"},
+		{http.MethodPost, "http://test.com/v1/zones/foo/hosts/baz/cvds"},
+		// Not recorded.
+		{http.MethodGet, "http://test.com/v1/zones/foo/hosts/baz/cvds"},
+		{http.MethodGet, "http://test.com/v1/zones/foo/hosts"},
+	}
+	for i, tc := range requests {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+		req.Header.Set("X-Request-Id", fmt.Sprintf("request-%d", i))
+		makeRequest(httptest.NewRecorder(), req, controller)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/admin/audit?user=johndoe&zone=foo", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	var got apiv1.ListAuditEntriesResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []*apiv1.AuditEntry{
+		{
+			RequestID:  "request-1",
+			User:       testUsername,
+			Action:     "host.proxy",
+			Method:     http.MethodPost,
+			Path:       "/v1/zones/foo/hosts/baz/cvds",
+			Zone:       "foo",
+			Host:       "baz",
+			Outcome:    apiv1.AuditOutcomeFailure,
+			StatusCode: http.StatusConflict,
+		},
+		{
+			RequestID:  "request-0",
+			User:       testUsername,
+			Action:     "host.delete",
+			Method:     http.MethodDelete,
+			Path:       "/v1/zones/foo/hosts/bar",
+			Zone:       "foo",
+			Host:       "bar",
+			Outcome:    apiv1.AuditOutcomeSuccess,
+			StatusCode: http.StatusOK,
+		},
+	}
+	ignoreTime := cmp.FilterPath(func(p cmp.Path) bool { return p.Last().String() == ".Time" }, cmp.Ignore())
+	if diff := cmp.Diff(want, got.Items, ignoreTime); diff != "" {
+		t.Errorf("audit entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMetricsRecordRequestsByRoute(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{},
+		withRole(accounts.RoleAdmin))
+	for _, reqURL := range []string{"http://test.com/v1/zones", "http://test.com/v1/hosts/foo~bar/:stop"} {
+		req, _ := http.NewRequest(http.MethodPost, reqURL, nil)
+		makeRequest(httptest.NewRecorder(), req, controller)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/metrics", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	for _, want := range []string{
+		`cloud_orchestrator_http_requests_total{route="unmatched",method="POST",status="405"} `,
+		`cloud_orchestrator_http_requests_total{route="/v1/zones/{zone}/hosts/{host}/:stop",method="POST",status="200"} `,
+		`cloud_orchestrator_http_request_duration_seconds_count{route="/v1/zones/{zone}/hosts/{host}/:stop",method="POST"} `,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, w.Body.String())
+		}
+	}
+}
+
+func TestHostForwarderRecordsProxyMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	hostURLs := map[string]string{"metrics-up": ts.URL, "metrics-down": unreachable.URL}
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, host string) instances.HostClient {
+			hostURL, _ := url.Parse(hostURLs[host])
+			return &testHostClient{hostURL}
+		},
+	}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, withRole(accounts.RoleAdmin))
+	for host := range hostURLs {
+		req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts/"+host+"/cvds", strings.NewReader("12345678"))
+		makeRequest(httptest.NewRecorder(), req, controller)
+	}
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/metrics", nil)
+
+	makeRequest(w, req, controller)
+
+	for _, want := range []string{
+		`cloud_orchestrator_proxy_bytes_total{zone="foo",host="metrics-up",direction="request"} 8` + "\n",
+		`cloud_orchestrator_proxy_bytes_total{zone="foo",host="metrics-up",direction="response"} 5` + "\n",
+		`cloud_orchestrator_proxy_errors_total{zone="foo",host="metrics-down"} 1` + "\n",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, w.Body.String())
+		}
+	}
+
+	req, _ = http.NewRequest(http.MethodDelete, "http://test.com/v1/zones/foo/hosts/metrics-up", nil)
+	makeRequest(httptest.NewRecorder(), req, controller)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "http://test.com/metrics", nil)
+
+	makeRequest(w, req, controller)
+
+	if strings.Contains(w.Body.String(), `host="metrics-up"`) {
+		t.Errorf("expected metrics of deleted host to be removed, got:\n%s", w.Body.String())
+	}
+}
+
+func TestRequestIDIsForwardedAndReturned(t *testing.T) {
+	var forwardedIDs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedIDs = append(forwardedIDs, r.Header.Get("X-Request-Id"))
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{hostURL}
+		},
+	}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	tests := map[string]string{
+		"provided": "req-123",
+		"missing":  "",
+		"invalid":  "bad id\n",
+	}
+	for name, id := range tests {
+		t.Run(name, func(t *testing.T) {
+			forwardedIDs = nil
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones/foo/hosts/bar/cvds", nil)
+			if id != "" {
+				req.Header.Set("X-Request-Id", id)
+			}
+
+			makeRequest(w, req, controller)
+
+			got := w.Header().Get("X-Request-Id")
+			if !requestIDRe.MatchString(got) {
+				t.Fatalf("invalid request id: %q", got)
+			}
+			if name == "provided" && got != id {
+				t.Errorf("expected request id %q, got %q", id, got)
+			}
+			if diff := cmp.Diff([]string{got}, forwardedIDs); diff != "" {
+				t.Errorf("forwarded request ids mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRateLimitsControlPlaneAndProxiedTrafficSeparately(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{hostURL}
+		},
+	}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{
+		RateLimit: ratelimit.Config{
+			ControlPlane: &ratelimit.LimitConfig{RequestsPerSecond: 0.5, Burst: 1},
+			Proxy:        &ratelimit.LimitConfig{RequestsPerSecond: 0.5, Burst: 2},
+		},
+	})
+	doGet := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		makeRequest(w, req, controller)
+		return w
+	}
+
+	if w := doGet("http://test.com/v1/zones/foo/hosts"); w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+	w := doGet("http://test.com/v1/zones/foo/hosts")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if diff := cmp.Diff("2", w.Header().Get("Retry-After")); diff != "" {
+		t.Errorf("Retry-After mismatch (-want +got):\n%s", diff)
+	}
+	for i := 0; i < 2; i++ {
+		if w := doGet("http://test.com/v1/zones/foo/hosts/bar/cvds"); w.Code != http.StatusOK {
+			t.Errorf("expected proxied request %d to be allowed, got %d", i, w.Code)
+		}
+	}
+	if w := doGet("http://test.com/v1/zones/foo/hosts/bar/cvds"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+}
+
+func TestCreateHostRejectsBigBodies(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{},
+		&config.Config{RateLimit: ratelimit.Config{MaxRequestBodyBytes: 16}})
+	tests := map[string]int{
+		"{}":                                  http.StatusOK,
+		`{"host_instance":{"name":"foobar"}}`: http.StatusRequestEntityTooLarge,
+	}
+	for body, want := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts", strings.NewReader(body))
+
+		makeRequest(w, req, controller)
+
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+}
+
+// Authenticates no request.
+type anonymousAccountManager struct{}
+
+func (m *anonymousAccountManager) UserFromRequest(r *http.Request) (accounts.User, error) {
+	return nil, nil
+}
+
+func (m *anonymousAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (accounts.User, error) {
+	return nil, nil
+}
+
+func TestAPITokens(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{APITokens: &accounts.APITokensConfig{}}}
+	// Tokens are created by users authenticated otherwise.
+	interactive := NewApp(&testInstanceManager{}, accounts.NewAPITokenAccountManager(dbs, &testAccountManager{}),
+		nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+	ci := NewApp(&testInstanceManager{}, accounts.NewAPITokenAccountManager(dbs, &anonymousAccountManager{}),
+		nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+	doRequest := func(controller *App, method, url, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		makeRequest(w, req, controller)
+		return w
+	}
+
+	w := doRequest(interactive, http.MethodPost, "http://test.com/v1/apitokens", "", `{"name":"ci"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	created := &apiv1.APIToken{}
+	if err := json.NewDecoder(w.Result().Body).Decode(created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" || created.Name != "ci" {
+		t.Fatalf("unexpected token: %+v", created)
+	}
+	if w := doRequest(ci, http.MethodGet, "http://test.com/v1/zones/foo/hosts", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d without token, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if w := doRequest(ci, http.MethodGet, "http://test.com/v1/zones/foo/hosts", "cotk_wrong", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d with wrong token, got %d", http.StatusUnauthorized, w.Code)
+	}
+	w = doRequest(ci, http.MethodGet, "http://test.com/v1/apitokens", created.Token, "")
+	var list apiv1.ListAPITokensResponse
+	if err := json.NewDecoder(w.Result().Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	want := *created
+	want.Token = ""
+	if diff := cmp.Diff([]*apiv1.APIToken{&want}, list.Items); diff != "" {
+		t.Errorf("tokens mismatch (-want +got):\n%s", diff)
+	}
+	if w := doRequest(ci, http.MethodDelete, "http://test.com/v1/apitokens/unknown", created.Token, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected %d revoking unknown token, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := doRequest(interactive, http.MethodDelete, "http://test.com/v1/apitokens/"+created.ID, "", ""); w.Code != http.StatusOK {
+		t.Errorf("expected %d revoking token, got %d", http.StatusOK, w.Code)
+	}
+	if w := doRequest(ci, http.MethodGet, "http://test.com/v1/zones/foo/hosts", created.Token, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d with revoked token, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestAPITokenUsersCannotCreateTokens(t *testing.T) {
+	for name, roles := range map[string]map[string]accounts.Role{
+		"default role":    nil,
+		"configured role": {testUsername: accounts.RoleAdmin},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dbs := database.NewInMemoryDBService()
+			cfg := &config.Config{AccountManager: accounts.Config{
+				APITokens: &accounts.APITokensConfig{},
+				Roles:     roles,
+			}}
+			interactive := NewApp(&testInstanceManager{}, accounts.NewAPITokenAccountManager(dbs, &testAccountManager{}),
+				nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+			ci := NewApp(&testInstanceManager{}, accounts.NewAPITokenAccountManager(dbs, &anonymousAccountManager{}),
+				nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/apitokens", strings.NewReader(`{"name":"ci"}`))
+			makeRequest(w, req, interactive)
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+			}
+			created := &apiv1.APIToken{}
+			if err := json.NewDecoder(w.Result().Body).Decode(created); err != nil {
+				t.Fatal(err)
+			}
+
+			w = httptest.NewRecorder()
+			req, _ = http.NewRequest(http.MethodPost, "http://test.com/v1/apitokens", strings.NewReader(`{"name":"copy"}`))
+			req.Header.Set("Authorization", "Bearer "+created.Token)
+			makeRequest(w, req, ci)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected %d, got %d", http.StatusForbidden, w.Code)
+			}
+			if tokens, _ := dbs.ListAPITokens(testUsername); len(tokens) != 1 {
+				t.Errorf("expected a single token, got %d", len(tokens))
+			}
+		})
+	}
+}
+
+func TestCreateAPITokenEnforcesMaxTTL(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{APITokens: &accounts.APITokensConfig{MaxTTLDays: 1}}}
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, nil, "", nil,
+		config.WebRTCConfig{}, cfg)
+	tests := map[string]int{
+		`{"name":"ci"}`:                     http.StatusBadRequest,
+		`{"name":"ci","ttl_seconds":90000}`: http.StatusBadRequest,
+		`{"name":"ci","ttl_seconds":3600}`:  http.StatusOK,
+	}
+	for body, want := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/apitokens", strings.NewReader(body))
+
+		makeRequest(w, req, controller)
+
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+		}
+	}
+}
+
+func TestHostHandleRoutes(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	tests := map[string]string{
+		"http://test.com/v1/hosts/foo~bar/:stop":           "stop-bar",
+		"http://test.com/v1/zones/baz/hosts/foo~bar/:stop": "stop-bar",
+		"http://test.com/v1/hosts/foo~bar/:extend":         "extend-bar",
+	}
+	for reqURL, opName := range tests {
+		t.Run(reqURL, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, reqURL, strings.NewReader("{}"))
+
+			makeRequest(w, req, controller)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+			}
+			var got apiv1.Operation
+			if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(apiv1.Operation{Name: opName}, got); diff != "" {
+				t.Errorf("operation mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHostHandleRoutesInvalidHandle(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/hosts/bar/:stop", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHostOperationsSucceed(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	for _, name := range []string{"stop", "start", "suspend", "resume"} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/zones/foo/hosts/bar/:"+name, nil)
+
+			makeRequest(w, req, controller)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+			}
+			var got apiv1.Operation
+			if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(apiv1.Operation{Name: name + "-bar"}, got); diff != "" {
+				t.Errorf("operation mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestBuildListHostsRequest(t *testing.T) {
 
 	t.Run("default", func(t *testing.T) {
@@ -196,8 +1026,43 @@ func TestBuildListHostsRequest(t *testing.T) {
 			MaxResults: 1,
 			PageToken:  "foo",
 		}
-		if *listReq != expected {
-			t.Errorf("expected <<%+v>>, got %+v", expected, listReq)
+		if diff := cmp.Diff(expected, *listReq); diff != "" {
+			t.Errorf("list hosts request mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("label selector", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "http://abc.com/query?labelSelector=team%3Dcamera%2Cci-run", nil)
+
+		listReq, _ := BuildListHostsRequest(r)
+
+		expected := instances.LabelSelector{
+			{Key: "team", Op: instances.LabelOpEquals, Value: "camera"},
+			{Key: "ci-run", Op: instances.LabelOpExists},
+		}
+		if diff := cmp.Diff(expected, listReq.LabelSelector); diff != "" {
+			t.Errorf("label selector mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid label selector", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "http://abc.com/query?labelSelector=team%3DCamera", nil)
+
+		listReq, err := BuildListHostsRequest(r)
+
+		assertIsAppError(t, err)
+		if listReq != nil {
+			t.Errorf("expected nil, got %+v", listReq)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "http://abc.com/query?status=STOPPED", nil)
+
+		listReq, _ := BuildListHostsRequest(r)
+
+		if listReq.Status != "STOPPED" {
+			t.Errorf("expected <<%q>>, got %q", "STOPPED", listReq.Status)
 		}
 	})
 }
@@ -208,7 +1073,7 @@ func TestDeleteHostIsHandled(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 
 	makeRequest(rr, req, controller)
 
@@ -250,7 +1115,7 @@ func TestHostForwarderRequest(t *testing.T) {
 		hostClientFactory: func(_, _ string) instances.HostClient {
 			return &testHostClient{hostURL}
 		},
-	}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 
 	tests := []struct {
 		method  string
@@ -282,6 +1147,52 @@ func TestHostForwarderRequest(t *testing.T) {
 	}
 }
 
+func TestHostForwarderHostNotOwned(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request was forwarded to a host not owned by the user")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	hostURL, _ := url.Parse(ts.URL)
+	controller := NewApp(&testInstanceManager{
+		hostClientFactory: func(_, _ string) instances.HostClient {
+			return &testHostClient{hostURL}
+		},
+		deniedHosts: []string{"bar"},
+	}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+
+	for _, path := range []string{"devices", "infra_config"} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones/foo/hosts/bar/"+path, nil)
+
+			makeRequest(w, req, controller)
+
+			if diff := cmp.Diff(http.StatusNotFound, w.Result().StatusCode); diff != "" {
+				t.Errorf("status code mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInfraConfigSucceeds(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil,
+		config.WebRTCConfig{STUNServers: []string{"stun:foo.com:1234"}}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones/foo/hosts/bar/infra_config", nil)
+
+	makeRequest(w, req, controller)
+
+	var got apiv1.InfraConfig
+	if err := json.NewDecoder(w.Result().Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := apiv1.InfraConfig{IceServers: []apiv1.IceServer{{URLs: []string{"stun:foo.com:1234"}}}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("infra config mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestHostForwarderInvalidRequests(t *testing.T) {
 	zone := "foo"
 	host := "bar"
@@ -356,7 +1267,7 @@ func TestHostForwarderInjectCredentialsUsingHTTPHeader(t *testing.T) {
 		hostClientFactory: func(_, _ string) instances.HostClient {
 			return &testHostClient{hostURL}
 		},
-	}, &testAccountManager{}, nil, encryption.NewFakeEncryptionService(), dbs, "", nil, config.WebRTCConfig{}, &config.Config{})
+	}, &testAccountManager{}, nil, encryption.NewFakeEncryptionService(), dbs, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, reqURL, nil)
 	req.Header.Set(headerNameCOInjectBuildAPICreds, "")
@@ -412,7 +1323,7 @@ func TestHostForwarderDoesNotInjectCredentials(t *testing.T) {
 		hostClientFactory: func(_, _ string) instances.HostClient {
 			return &testHostClient{hostURL}
 		},
-	}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, &config.Config{})
+	}, &testAccountManager{}, nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodPost, reqURL, bytes.NewBuffer(msg))
@@ -438,7 +1349,7 @@ func TestBadCSRFTokensInRescindAuth(t *testing.T) {
 				Key:         sessionId,
 				OAuth2State: "righttoken",
 			})
-			controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, "", nil, config.WebRTCConfig{}, &config.Config{})
+			controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 			ts := httptest.NewServer(controller.Handler())
 			defer ts.Close()
 
@@ -474,7 +1385,7 @@ func TestBadCSRFTokensInRescindAuth(t *testing.T) {
 }
 
 func TestGetConfigSucceeds(t *testing.T) {
-	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
 	ts := httptest.NewServer(controller.Handler())
 	defer ts.Close()
 
@@ -498,3 +1409,170 @@ func makeRequest(w http.ResponseWriter, r *http.Request, controller *App) {
 	router := controller.Handler()
 	router.ServeHTTP(w, r)
 }
+
+func TestAuthenticateChecksAdmission(t *testing.T) {
+	tests := map[string]struct {
+		admission accounts.AdmissionConfig
+		want      int
+	}{
+		"no restrictions":   {accounts.AdmissionConfig{}, http.StatusOK},
+		"allowed user":      {accounts.AdmissionConfig{AllowedUsers: []string{testUsername}}, http.StatusOK},
+		"not allowed":       {accounts.AdmissionConfig{AllowedDomains: []string{"example.com"}}, http.StatusForbidden},
+		"denied user":       {accounts.AdmissionConfig{DeniedUsers: []string{testUsername}}, http.StatusForbidden},
+		"denied and listed": {accounts.AdmissionConfig{AllowedUsers: []string{testUsername}, DeniedUsers: []string{testUsername}}, http.StatusForbidden},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			controller := NewApp(&testInstanceManager{}, &testAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{},
+				&config.Config{AccountManager: accounts.Config{Admission: tc.admission}})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones", nil)
+
+			makeRequest(w, req, controller)
+
+			if w.Code != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+type challengeAccountManager struct{}
+
+func (m *challengeAccountManager) UserFromRequest(r *http.Request) (accounts.User, error) {
+	return nil, apperr.NewAuthenticationChallengeError("Authentication required", `Basic realm="test"`)
+}
+
+func (m *challengeAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (accounts.User, error) {
+	return nil, nil
+}
+
+func TestAuthenticationChallengeIsSent(t *testing.T) {
+	controller := NewApp(&testInstanceManager{}, &challengeAccountManager{}, nil, nil, nil, nil, "", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/v1/zones", nil)
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if diff := cmp.Diff(`Basic realm="test"`, w.Header().Get("WWW-Authenticate")); diff != "" {
+		t.Errorf("WWW-Authenticate mismatch (-want +got):\n%s", diff)
+	}
+}
+
+type emailUser struct {
+	testUser
+	email string
+}
+
+func (u *emailUser) Email() string { return u.email }
+
+// Authenticates every request as the test user with the given email.
+type emailAccountManager struct {
+	email string
+}
+
+func (m *emailAccountManager) UserFromRequest(r *http.Request) (accounts.User, error) {
+	return &emailUser{email: m.email}, nil
+}
+
+func (m *emailAccountManager) OnOAuth2Exchange(w http.ResponseWriter, r *http.Request, tk appOAuth2.IDTokenClaims) (accounts.User, error) {
+	return &emailUser{email: m.email}, nil
+}
+
+func TestAPITokensAreAdmittedAsTheirCreatorsEmail(t *testing.T) {
+	dbs := database.NewInMemoryDBService()
+	cfg := &config.Config{AccountManager: accounts.Config{
+		APITokens: &accounts.APITokensConfig{},
+		Admission: accounts.AdmissionConfig{AllowedDomains: []string{"example.com"}},
+	}}
+	interactive := NewApp(&testInstanceManager{},
+		accounts.NewAPITokenAccountManager(dbs, &emailAccountManager{email: "johndoe@example.com"}),
+		nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+	ci := NewApp(&testInstanceManager{}, accounts.NewAPITokenAccountManager(dbs, &anonymousAccountManager{}),
+		nil, nil, dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com/v1/apitokens", strings.NewReader(`{"name":"ci"}`))
+	makeRequest(w, req, interactive)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d", w.Code, http.StatusOK)
+	}
+	created := &apiv1.APIToken{}
+	if err := json.NewDecoder(w.Result().Body).Decode(created); err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "http://test.com/v1/zones/foo/hosts", nil)
+	req.Header.Set("Authorization", "Bearer "+created.Token)
+	makeRequest(w, req, ci)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestOAuth2CallbackChecksAdmission(t *testing.T) {
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"email": "johndoe@partner.com"}).
+		SignedString([]byte("secret"))
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, map[string]any{"access_token": "foo", "token_type": "Bearer", "id_token": idToken}, http.StatusOK)
+	}))
+	defer tokenServer.Close()
+	oauth2Helper := &appOAuth2.Helper{Config: oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}}}
+	dbs := database.NewInMemoryDBService()
+	dbs.CreateOrUpdateSession(session.Session{Key: "somesessionid", OAuth2State: "somestate"})
+	cfg := &config.Config{AccountManager: accounts.Config{
+		Admission: accounts.AdmissionConfig{AllowedDomains: []string{"example.com"}},
+	}}
+	controller := NewApp(&testInstanceManager{}, &emailAccountManager{email: "johndoe@partner.com"}, oauth2Helper,
+		encryption.NewFakeEncryptionService(), dbs, nil, "", nil, config.WebRTCConfig{}, cfg)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/oauth2callback?state=somestate&code=somecode", nil)
+	req.AddCookie(&http.Cookie{Name: sessionIdCookie, Value: "somesessionid"})
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected %d, got %d", http.StatusForbidden, w.Code)
+	}
+	if creds, _ := dbs.FetchBuildAPICredentials(testUsername); creds != nil {
+		t.Error("expected no credentials to be stored")
+	}
+}
+
+func TestOAuth2CallbackIdentifiesOIDCUsersWithoutToken(t *testing.T) {
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"email": "johndoe@example.com"}).
+		SignedString([]byte("secret"))
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, map[string]any{"access_token": "foo", "token_type": "Bearer", "id_token": idToken}, http.StatusOK)
+	}))
+	defer tokenServer.Close()
+	oauth2Helper := &appOAuth2.Helper{Config: oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}}}
+	dbs := database.NewInMemoryDBService()
+	dbs.CreateOrUpdateSession(session.Session{Key: "somesessionid", OAuth2State: "somestate"})
+	am, err := accounts.NewOIDCAccountManager(accounts.OIDCConfig{
+		Issuer:    "https://idp.example.com",
+		Audiences: []string{"cloud-orchestrator"},
+	}, accounts.UsernameConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	controller := NewApp(&testInstanceManager{}, am, oauth2Helper, encryption.NewFakeEncryptionService(), dbs, nil,
+		"", nil, config.WebRTCConfig{}, &config.Config{})
+	w := httptest.NewRecorder()
+	// Like the browser redirect, without Authorization header.
+	req, _ := http.NewRequest(http.MethodGet, "http://test.com/oauth2callback?state=somestate&code=somecode", nil)
+	req.AddCookie(&http.Cookie{Name: sessionIdCookie, Value: "somesessionid"})
+
+	makeRequest(w, req, controller)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code <<%d>>, want: %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if creds, _ := dbs.FetchBuildAPICredentials("johndoe"); creds == nil {
+		t.Error("expected the user's credentials to be stored")
+	}
+}
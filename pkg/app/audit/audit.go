@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit records the state changing requests handled by the cloud orchestrator, allowing to tell who
+// did what and when.
+package audit
+
+import (
+	"sort"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+const (
+	JSONLSinkType    = "JSONL"
+	DatabaseSinkType = "Database"
+)
+
+type JSONLConfig struct {
+	// File the entries are appended to, one JSON object per line.
+	Path string
+}
+
+type Config struct {
+	// Nothing is recorded if empty.
+	Type  string
+	JSONL *JSONLConfig
+}
+
+// Stores audit entries. Implemented by the JSONL sink and by the database service.
+type Sink interface {
+	StoreAuditEntry(e *apiv1.AuditEntry) error
+	// Returns the entries matching the query, most recent first.
+	ListAuditEntries(q *Query) ([]*apiv1.AuditEntry, error)
+}
+
+type Query struct {
+	// Only entries with these values are returned, any value if empty.
+	User   string
+	Action string
+	Zone   string
+	Host   string
+	// Only entries recorded in the [Since, Until) interval are returned, unbounded if zero.
+	Since time.Time
+	Until time.Time
+	// Maximum number of entries returned, every entry if zero.
+	Limit int
+}
+
+func (q *Query) Matches(e *apiv1.AuditEntry) bool {
+	return matchesValue(q.User, e.User) &&
+		matchesValue(q.Action, e.Action) &&
+		matchesValue(q.Zone, e.Zone) &&
+		matchesValue(q.Host, e.Host) &&
+		(q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until))
+}
+
+func matchesValue(want, got string) bool {
+	return want == "" || want == got
+}
+
+// Sorts the entries most recent first and returns the first limit of them, all of them if limit is zero.
+func Latest(entries []*apiv1.AuditEntry, limit int) []*apiv1.AuditEntry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+// Appends entries to a file, one JSON object per line. Queries read the whole file, so it's meant for
+// single server deployments with moderate traffic, rotate the file to keep queries fast.
+type JSONLSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewJSONLSink(path string) (*JSONLSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log file: %w", err)
+	}
+	return &JSONLSink{path: path, file: file}, nil
+}
+
+func (s *JSONLSink) StoreAuditEntry(e *apiv1.AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+func (s *JSONLSink) ListAuditEntries(q *Query) ([]*apiv1.AuditEntry, error) {
+	// Holding the lock keeps concurrent writes from showing up as half written lines.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log file: %w", err)
+	}
+	defer file.Close()
+	entries := []*apiv1.AuditEntry{}
+	dec := json.NewDecoder(file)
+	for {
+		e := &apiv1.AuditEntry{}
+		if err := dec.Decode(e); err == io.EOF {
+			break
+		} else if err == io.ErrUnexpectedEOF {
+			// The last line is incomplete if the server stopped while writing it.
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read audit log file: %w", err)
+		}
+		if q.Matches(e) {
+			entries = append(entries, e)
+		}
+	}
+	return Latest(entries, q.Limit), nil
+}
+
+func (s *JSONLSink) Close() error {
+	return s.file.Close()
+}
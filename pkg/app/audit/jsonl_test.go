@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestJSONLSinkListAuditEntries(t *testing.T) {
+	s, err := NewJSONLSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	start := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	entries := []*apiv1.AuditEntry{
+		{Time: start, User: "johndoe", Action: "host.create", Zone: "us-central1-a"},
+		{Time: start.Add(time.Minute), User: "janedoe", Action: "host.delete", Zone: "us-central1-a", Host: "foo"},
+		{Time: start.Add(2 * time.Minute), User: "johndoe", Action: "host.delete", Zone: "us-central1-a", Host: "bar"},
+		{Time: start.Add(3 * time.Minute), User: "johndoe", Action: "host.delete", Zone: "us-central1-b", Host: "baz"},
+	}
+	for _, e := range entries {
+		if err := s.StoreAuditEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := map[string]struct {
+		query Query
+		want  []*apiv1.AuditEntry
+	}{
+		"all":    {Query{}, []*apiv1.AuditEntry{entries[3], entries[2], entries[1], entries[0]}},
+		"user":   {Query{User: "janedoe"}, []*apiv1.AuditEntry{entries[1]}},
+		"action": {Query{Action: "host.delete", Zone: "us-central1-a"}, []*apiv1.AuditEntry{entries[2], entries[1]}},
+		"host":   {Query{Host: "baz"}, []*apiv1.AuditEntry{entries[3]}},
+		"interval": {
+			Query{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)},
+			[]*apiv1.AuditEntry{entries[2], entries[1]},
+		},
+		"limit": {Query{User: "johndoe", Limit: 2}, []*apiv1.AuditEntry{entries[3], entries[2]}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := s.ListAuditEntries(&tc.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("entries mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJSONLSinkListAuditEntriesSkipsIncompleteLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := NewJSONLSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	entry := &apiv1.AuditEntry{Time: time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC), User: "johndoe", Action: "host.create"}
+	if err := s.StoreAuditEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.file.WriteString(`{"time":"2023-05-01T10:01:00Z","user":"jo`); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.ListAuditEntries(&Query{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.AuditEntry{entry}, got); diff != "" {
+		t.Errorf("entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestJSONLSinkConcurrentStoreAndList(t *testing.T) {
+	s, err := NewJSONLSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := s.StoreAuditEntry(&apiv1.AuditEntry{User: "johndoe", Action: "host.create"}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := s.ListAuditEntries(&Query{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := s.ListAuditEntries(&Query{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 10 {
+		t.Errorf("expected 10 entries, got: %d", len(got))
+	}
+}
@@ -18,9 +18,12 @@ import (
 	"os"
 
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
 	"github.com/google/cloud-android-orchestration/pkg/app/database"
 	"github.com/google/cloud-android-orchestration/pkg/app/encryption"
 	"github.com/google/cloud-android-orchestration/pkg/app/instances"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+	"github.com/google/cloud-android-orchestration/pkg/app/ratelimit"
 	"github.com/google/cloud-android-orchestration/pkg/app/secrets"
 
 	toml "github.com/pelletier/go-toml"
@@ -30,6 +33,12 @@ type WebRTCConfig struct {
 	STUNServers []string
 }
 
+// The server is served over HTTPS with the given certificate and key, both PEM encoded.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
 type Config struct {
 	WebStaticFilesPath string
 	CORSAllowedOrigins []string
@@ -38,7 +47,12 @@ type Config struct {
 	InstanceManager    instances.Config
 	EncryptionService  encryption.Config
 	DatabaseService    database.Config
+	Audit              audit.Config
 	WebRTC             WebRTCConfig
+	Logging            logging.Config
+	RateLimit          ratelimit.Config
+	// Served over plain HTTP if nil, e.g. behind a load balancer terminating TLS.
+	TLS *TLSConfig
 }
 
 const DefaultConfFile = "conf.toml"
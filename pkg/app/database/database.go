@@ -15,16 +15,24 @@
 package database
 
 import (
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 )
 
 type Service interface {
+	// Database backed sink of the audit log.
+	audit.Sink
+	// Stores the hashes of the API tokens.
+	apitokens.Store
 	// Credentials are usually stored encrypted hence the []byte type.
 	// If no credentials are available for the given user Fetch returns nil, nil.
 	FetchBuildAPICredentials(username string) ([]byte, error)
 	// Store new credentials or overwrite existing ones for the given user.
 	StoreBuildAPICredentials(username string, credentials []byte) error
 	DeleteBuildAPICredentials(username string) error
+	// Returns the usernames of the users with stored credentials, sorted.
+	ListBuildAPICredentialsUsernames() ([]string, error)
 	// Create or update a user session.
 	CreateOrUpdateSession(s session.Session) error
 	// Fetch a session. Returns nil, nil if the session doesn't exist.
@@ -0,0 +1,139 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
+	"github.com/google/cloud-android-orchestration/pkg/app/metrics"
+	"github.com/google/cloud-android-orchestration/pkg/app/session"
+)
+
+var (
+	callDuration = metrics.DefaultRegistry.NewHistogramVec("cloud_orchestrator_database_call_duration_seconds",
+		"Latency of the database service calls, by method.", metrics.DefaultBuckets, "method")
+	callErrors = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_database_call_errors_total",
+		"Failed database service calls, by method.", "method")
+)
+
+// Returns a database service reporting the latency and errors of the calls to the given one.
+func NewInstrumentedService(s Service) Service {
+	return &instrumentedService{s}
+}
+
+type instrumentedService struct {
+	s Service
+}
+
+func observe(method string, start time.Time, err error) {
+	callDuration.ObserveSince(start, method)
+	if err != nil {
+		callErrors.Inc(method)
+	}
+}
+
+func (i *instrumentedService) FetchBuildAPICredentials(username string) ([]byte, error) {
+	start := time.Now()
+	res, err := i.s.FetchBuildAPICredentials(username)
+	observe("FetchBuildAPICredentials", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) StoreBuildAPICredentials(username string, credentials []byte) error {
+	start := time.Now()
+	err := i.s.StoreBuildAPICredentials(username, credentials)
+	observe("StoreBuildAPICredentials", start, err)
+	return err
+}
+
+func (i *instrumentedService) DeleteBuildAPICredentials(username string) error {
+	start := time.Now()
+	err := i.s.DeleteBuildAPICredentials(username)
+	observe("DeleteBuildAPICredentials", start, err)
+	return err
+}
+
+func (i *instrumentedService) ListBuildAPICredentialsUsernames() ([]string, error) {
+	start := time.Now()
+	res, err := i.s.ListBuildAPICredentialsUsernames()
+	observe("ListBuildAPICredentialsUsernames", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) CreateOrUpdateSession(s session.Session) error {
+	start := time.Now()
+	err := i.s.CreateOrUpdateSession(s)
+	observe("CreateOrUpdateSession", start, err)
+	return err
+}
+
+func (i *instrumentedService) FetchSession(key string) (*session.Session, error) {
+	start := time.Now()
+	res, err := i.s.FetchSession(key)
+	observe("FetchSession", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) DeleteSession(key string) error {
+	start := time.Now()
+	err := i.s.DeleteSession(key)
+	observe("DeleteSession", start, err)
+	return err
+}
+
+func (i *instrumentedService) StoreAuditEntry(e *apiv1.AuditEntry) error {
+	start := time.Now()
+	err := i.s.StoreAuditEntry(e)
+	observe("StoreAuditEntry", start, err)
+	return err
+}
+
+func (i *instrumentedService) ListAuditEntries(q *audit.Query) ([]*apiv1.AuditEntry, error) {
+	start := time.Now()
+	res, err := i.s.ListAuditEntries(q)
+	observe("ListAuditEntries", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) CreateAPIToken(t *apitokens.Token) error {
+	start := time.Now()
+	err := i.s.CreateAPIToken(t)
+	observe("CreateAPIToken", start, err)
+	return err
+}
+
+func (i *instrumentedService) FetchAPITokenByHash(hash string) (*apitokens.Token, error) {
+	start := time.Now()
+	res, err := i.s.FetchAPITokenByHash(hash)
+	observe("FetchAPITokenByHash", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) ListAPITokens(username string) ([]*apitokens.Token, error) {
+	start := time.Now()
+	res, err := i.s.ListAPITokens(username)
+	observe("ListAPITokens", start, err)
+	return res, err
+}
+
+func (i *instrumentedService) DeleteAPIToken(username, id string) error {
+	start := time.Now()
+	err := i.s.DeleteAPIToken(username, id)
+	observe("DeleteAPIToken", start, err)
+	return err
+}
@@ -15,6 +15,12 @@
 package database
 
 import (
+	"sort"
+	"sync"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 )
 
@@ -26,8 +32,13 @@ type SpannerConfig struct {
 
 // Simple in memory database to use for testing or local development.
 type InMemoryDBService struct {
-	credentials map[string][]byte
-	session     session.Session
+	// Guards every field, the service is used by concurrent requests.
+	mu           sync.Mutex
+	credentials  map[string][]byte
+	session      session.Session
+	auditEntries []*apiv1.AuditEntry
+	// In creation order.
+	apiTokens []*apitokens.Token
 }
 
 func NewInMemoryDBService() *InMemoryDBService {
@@ -37,25 +48,112 @@ func NewInMemoryDBService() *InMemoryDBService {
 }
 
 func (dbs *InMemoryDBService) FetchBuildAPICredentials(username string) ([]byte, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	return dbs.credentials[username], nil
 }
 
 func (dbs *InMemoryDBService) StoreBuildAPICredentials(username string, credentials []byte) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	dbs.credentials[username] = credentials
 	return nil
 }
 
 func (dbs *InMemoryDBService) DeleteBuildAPICredentials(username string) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	delete(dbs.credentials, username)
 	return nil
 }
 
+func (dbs *InMemoryDBService) ListBuildAPICredentialsUsernames() ([]string, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	usernames := []string{}
+	for username := range dbs.credentials {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames, nil
+}
+
+func (dbs *InMemoryDBService) StoreAuditEntry(e *apiv1.AuditEntry) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	entryCopy := *e
+	dbs.auditEntries = append(dbs.auditEntries, &entryCopy)
+	return nil
+}
+
+func (dbs *InMemoryDBService) ListAuditEntries(q *audit.Query) ([]*apiv1.AuditEntry, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	entries := []*apiv1.AuditEntry{}
+	for _, e := range dbs.auditEntries {
+		if q.Matches(e) {
+			entryCopy := *e
+			entries = append(entries, &entryCopy)
+		}
+	}
+	return audit.Latest(entries, q.Limit), nil
+}
+
+func (dbs *InMemoryDBService) CreateAPIToken(t *apitokens.Token) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	tokenCopy := *t
+	dbs.apiTokens = append(dbs.apiTokens, &tokenCopy)
+	return nil
+}
+
+func (dbs *InMemoryDBService) FetchAPITokenByHash(hash string) (*apitokens.Token, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	for _, t := range dbs.apiTokens {
+		if t.Hash == hash {
+			tokenCopy := *t
+			return &tokenCopy, nil
+		}
+	}
+	return nil, nil
+}
+
+func (dbs *InMemoryDBService) ListAPITokens(username string) ([]*apitokens.Token, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	tokens := []*apitokens.Token{}
+	for _, t := range dbs.apiTokens {
+		if t.Username == username {
+			tokenCopy := *t
+			tokens = append(tokens, &tokenCopy)
+		}
+	}
+	return tokens, nil
+}
+
+func (dbs *InMemoryDBService) DeleteAPIToken(username, id string) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
+	for i, t := range dbs.apiTokens {
+		if t.Username == username && t.ID == id {
+			dbs.apiTokens = append(dbs.apiTokens[:i], dbs.apiTokens[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
 func (dbs *InMemoryDBService) CreateOrUpdateSession(s session.Session) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	dbs.session = s
 	return nil
 }
 
 func (dbs *InMemoryDBService) FetchSession(key string) (*session.Session, error) {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	if dbs.session.Key != key {
 		return nil, nil
 	}
@@ -64,6 +162,8 @@ func (dbs *InMemoryDBService) FetchSession(key string) (*session.Session, error)
 }
 
 func (dbs *InMemoryDBService) DeleteSession(key string) error {
+	dbs.mu.Lock()
+	defer dbs.mu.Unlock()
 	if dbs.session.Key == key {
 		dbs.session = session.Session{}
 	}
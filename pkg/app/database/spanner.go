@@ -16,13 +16,19 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/apitokens"
+	"github.com/google/cloud-android-orchestration/pkg/app/audit"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
 	"github.com/google/cloud-android-orchestration/pkg/app/session"
 
 	"cloud.google.com/go/spanner"
+	"github.com/google/uuid"
 	"google.golang.org/grpc/codes"
 )
 
@@ -38,6 +44,24 @@ const (
 	sessionOAuth2StateColumn = "oauth2_state"
 	sessionAccessColumn      = "accessed_at"
 
+	auditEntriesTable     = "AuditEntries"
+	auditEntryIDColumn    = "entry_id"
+	auditRecordedAtColumn = "recorded_at"
+	auditUsernameColumn   = "username"
+	auditActionColumn     = "action"
+	auditZoneColumn       = "zone"
+	auditHostColumn       = "host"
+	auditEntryColumn      = "entry"
+
+	apiTokensTable               = "APITokens"
+	apiTokenHashColumn           = "token_hash"
+	apiTokenIDColumn             = "token_id"
+	apiTokenUsernameColumn       = "username"
+	apiTokenEmailColumn          = "email"
+	apiTokenNameColumn           = "name"
+	apiTokenCreationTimeColumn   = "created_at"
+	apiTokenExpirationTimeColumn = "expires_at"
+
 	sessionStateValidityHours = 48
 )
 
@@ -52,6 +76,24 @@ const (
 //	  oauth2_state string
 //	  accessed_at timestamp
 //	}
+//	table AuditEntries {
+//	  entry_id string primary key
+//	  recorded_at timestamp
+//	  username string
+//	  action string
+//	  zone string
+//	  host string
+//	  entry byte array # JSON-serialized apiv1.AuditEntry object
+//	}
+//	table APITokens {
+//	  token_hash string primary key
+//	  token_id string
+//	  username string
+//	  email string # empty if the user's email is unknown
+//	  name string
+//	  created_at timestamp
+//	  expires_at timestamp # null if the token never expires
+//	}
 type SpannerDBService struct {
 	db string
 }
@@ -114,6 +156,203 @@ func (dbs *SpannerDBService) DeleteBuildAPICredentials(username string) error {
 	return err
 }
 
+func (dbs *SpannerDBService) ListBuildAPICredentialsUsernames() ([]string, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	// Rows are read in primary key order, so the usernames are already sorted.
+	usernames := []string{}
+	iter := client.Single().Read(ctx, credentialsTable, spanner.AllKeys(), []string{usernameColumn})
+	err = iter.Do(func(row *spanner.Row) error {
+		var username string
+		if err := row.Column(0, &username); err != nil {
+			return err
+		}
+		usernames = append(usernames, username)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error querying database: %w", err)
+	}
+	return usernames, nil
+}
+
+func (dbs *SpannerDBService) StoreAuditEntry(e *apiv1.AuditEntry) error {
+	entry, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	columns := []string{auditEntryIDColumn, auditRecordedAtColumn, auditUsernameColumn, auditActionColumn,
+		auditZoneColumn, auditHostColumn, auditEntryColumn}
+	mutation := spanner.Insert(auditEntriesTable, columns,
+		[]interface{}{uuid.New().String(), e.Time, e.User, e.Action, e.Zone, e.Host, entry})
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (dbs *SpannerDBService) ListAuditEntries(q *audit.Query) ([]*apiv1.AuditEntry, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	conds := []string{"true"}
+	params := map[string]interface{}{}
+	for _, f := range []struct{ column, value string }{
+		{auditUsernameColumn, q.User},
+		{auditActionColumn, q.Action},
+		{auditZoneColumn, q.Zone},
+		{auditHostColumn, q.Host},
+	} {
+		if f.value != "" {
+			conds = append(conds, fmt.Sprintf("%s = @%s", f.column, f.column))
+			params[f.column] = f.value
+		}
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, fmt.Sprintf("%s >= @since", auditRecordedAtColumn))
+		params["since"] = q.Since
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, fmt.Sprintf("%s < @until", auditRecordedAtColumn))
+		params["until"] = q.Until
+	}
+	sql := fmt.Sprintf("select %s from %s where %s order by %s desc",
+		auditEntryColumn, auditEntriesTable, strings.Join(conds, " and "), auditRecordedAtColumn)
+	if q.Limit > 0 {
+		sql += " limit @limit"
+		params["limit"] = int64(q.Limit)
+	}
+	entries := []*apiv1.AuditEntry{}
+	iter := client.Single().Query(ctx, spanner.Statement{SQL: sql, Params: params})
+	err = iter.Do(func(row *spanner.Row) error {
+		var entry []byte
+		if err := row.Column(0, &entry); err != nil {
+			return err
+		}
+		e := &apiv1.AuditEntry{}
+		if err := json.Unmarshal(entry, e); err != nil {
+			return err
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error querying database: %w", err)
+	}
+	return entries, nil
+}
+
+var apiTokenColumns = []string{apiTokenHashColumn, apiTokenIDColumn, apiTokenUsernameColumn, apiTokenEmailColumn,
+	apiTokenNameColumn, apiTokenCreationTimeColumn, apiTokenExpirationTimeColumn}
+
+func (dbs *SpannerDBService) CreateAPIToken(t *apitokens.Token) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	expiration := spanner.NullTime{Time: t.ExpirationTime, Valid: !t.ExpirationTime.IsZero()}
+	mutation := spanner.Insert(apiTokensTable, apiTokenColumns,
+		[]interface{}{t.Hash, t.ID, t.Username, t.Email, t.Name, t.CreationTime, expiration})
+	_, err = client.Apply(ctx, []*spanner.Mutation{mutation})
+	return err
+}
+
+func (dbs *SpannerDBService) FetchAPITokenByHash(hash string) (*apitokens.Token, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	row, err := client.Single().ReadRow(ctx, apiTokensTable, spanner.Key{hash}, apiTokenColumns)
+	if err != nil {
+		if spanner.ErrCode(err) == codes.NotFound {
+			// Not found is not an error
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error querying database: %w", err)
+	}
+	return apiTokenFromRow(row)
+}
+
+func (dbs *SpannerDBService) ListAPITokens(username string) ([]*apitokens.Token, error) {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create db client: %w", err)
+	}
+	defer client.Close()
+
+	stmt := spanner.Statement{
+		SQL: fmt.Sprintf("select %s from %s where %s = @username order by %s",
+			strings.Join(apiTokenColumns, ", "), apiTokensTable, apiTokenUsernameColumn, apiTokenCreationTimeColumn),
+		Params: map[string]interface{}{"username": username},
+	}
+	tokens := []*apitokens.Token{}
+	err = client.Single().Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		t, err := apiTokenFromRow(row)
+		if err != nil {
+			return err
+		}
+		tokens = append(tokens, t)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error querying database: %w", err)
+	}
+	return tokens, nil
+}
+
+func (dbs *SpannerDBService) DeleteAPIToken(username, id string) error {
+	ctx := context.TODO()
+	client, err := spanner.NewClient(ctx, dbs.db)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	_, err = client.ReadWriteTransaction(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: fmt.Sprintf("delete from %s where %s = @username and %s = @id",
+				apiTokensTable, apiTokenUsernameColumn, apiTokenIDColumn),
+			Params: map[string]interface{}{"username": username, "id": id},
+		}
+		_, err := txn.Update(ctx, stmt)
+		return err
+	})
+	return err
+}
+
+// The row must have the apiTokenColumns columns.
+func apiTokenFromRow(row *spanner.Row) (*apitokens.Token, error) {
+	t := &apitokens.Token{}
+	var expiration spanner.NullTime
+	if err := row.Columns(&t.Hash, &t.ID, &t.Username, &t.Email, &t.Name, &t.CreationTime, &expiration); err != nil {
+		return nil, err
+	}
+	if expiration.Valid {
+		t.ExpirationTime = expiration.Time
+	}
+	return t, nil
+}
+
 func (dbs *SpannerDBService) CreateOrUpdateSession(s session.Session) error {
 	ctx := context.TODO()
 	client, err := spanner.NewClient(ctx, dbs.db)
@@ -178,7 +417,7 @@ func (dbs *SpannerDBService) deleteExpiredSessions() {
 	ctx := context.TODO()
 	client, err := spanner.NewClient(ctx, dbs.db)
 	if err != nil {
-		log.Println("Failed to create db client to delete expired sessions")
+		logging.Default().Error("Failed to create db client to delete expired sessions", "error", err)
 		return
 	}
 	defer client.Close()
@@ -193,10 +432,10 @@ func (dbs *SpannerDBService) deleteExpiredSessions() {
 		if err != nil {
 			return err
 		}
-		log.Printf("%d expired session(s) deleted.\n", rowCount)
+		logging.Default().Info("Expired sessions deleted", "count", rowCount)
 		return nil
 	})
 	if err != nil {
-		log.Println("Failed to delete expired sessions: ", err)
+		logging.Default().Error("Failed to delete expired sessions", "error", err)
 	}
 }
@@ -16,6 +16,7 @@ package errors
 
 import (
 	"net/http"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 )
@@ -24,6 +25,10 @@ type AppError struct {
 	Msg        string
 	StatusCode int
 	Err        error
+	// How long the client should wait before retrying, sent in the Retry-After header if not zero.
+	RetryAfter time.Duration
+	// How the client should authenticate, sent in the WWW-Authenticate header if not empty.
+	Challenge string
 }
 
 func (e *AppError) Error() string {
@@ -68,6 +73,27 @@ func NewUnauthenticatedError(msg string, e error) error {
 	return &AppError{Msg: msg, StatusCode: http.StatusUnauthorized, Err: e}
 }
 
+// Like NewUnauthenticatedError, but also tells the client how to authenticate, e.g. `Basic realm="foo"`.
+func NewAuthenticationChallengeError(msg string, challenge string) error {
+	return &AppError{Msg: msg, StatusCode: http.StatusUnauthorized, Challenge: challenge}
+}
+
 func NewServiceUnavailableError(msg string, e error) error {
 	return &AppError{Msg: msg, StatusCode: http.StatusServiceUnavailable, Err: e}
 }
+
+func NewForbiddenError(msg string, e error) error {
+	return &AppError{Msg: msg, StatusCode: http.StatusForbidden, Err: e}
+}
+
+func NewTooManyRequestsError(msg string, e error) error {
+	return &AppError{Msg: msg, StatusCode: http.StatusTooManyRequests, Err: e}
+}
+
+func NewRateLimitedError(msg string, retryAfter time.Duration) error {
+	return &AppError{Msg: msg, StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+func NewRequestEntityTooLargeError(msg string, e error) error {
+	return &AppError{Msg: msg, StatusCode: http.StatusRequestEntityTooLarge, Err: e}
+}
@@ -17,15 +17,20 @@ package instances
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/http"
 	"net/url"
 	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
 	"github.com/google/cloud-android-orchestration/pkg/app/errors"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
 
+	"github.com/hashicorp/go-multierror"
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -38,12 +43,55 @@ type GCPIMConfig struct {
 	HostOrchestratorPort int
 	// If true, instances created should be compatible with `acloud CLI`.
 	AcloudCompatible bool
+	// Boot disk size in GB of new hosts, the image size is used if zero.
+	BootDiskSizeGB int64
+	// Maximum boot disk size in GB users can request, users can't choose the size if zero.
+	MaxBootDiskSizeGB int64
+	// Network of new hosts, e.g. "projects/foo/global/networks/bar". The default network is used if empty.
+	Network string
+	// Subnetwork of new hosts, e.g. "regions/us-central1/subnetworks/foo".
+	Subnetwork string
+	// Other subnetworks users can request.
+	AllowedSubnetworks []string
+	// Network tags added to every new host, e.g. to match firewall rules.
+	NetworkTags []string
+	// Additional network tags users can request.
+	AllowedNetworkTags []string
+	// If true, hosts are created without an external IP address.
+	NoExternalIP bool
+	// Email of the service account attached to new hosts, none is attached if empty.
+	ServiceAccount string
+	// OAuth2 scopes granted to the service account, the cloud-platform scope is used if empty.
+	ServiceAccountScopes []string
+	// Whether users can request Spot VMs.
+	AllowSpot bool
+	// Paths of startup script template files, see StartupScriptVars for the available variables. The
+	// rendered templates are concatenated in order into the startup script of new hosts. The files are read
+	// on every host creation, changes don't require a restart. Hosts from warm pools are rendered before they
+	// have an owner, with empty Owner and Labels.
+	StartupScriptTemplates []string
+	// Pools of ready hosts handed to users right away when they request a host of the same zone and machine
+	// type, without any other customization.
+	WarmPools []WarmPoolConfig
+	// Whether this server keeps the warm pools at their target size. Refills aren't coordinated between
+	// servers, when several replicas share the configuration only one of them may enable it or the pools
+	// overshoot their size. Every replica hands out hosts from the pools either way.
+	RefillWarmPools bool
+	// Interval between checks that the warm pools have their target size, 5 minutes if zero. Pools are also
+	// refilled as soon as a host is taken from them.
+	WarmPoolRefillIntervalMinutes int
 }
 
 const (
 	labelPrefix          = "cf-"
 	labelAcloudCreatedBy = "created_by" // required for acloud backwards compatibility
 	labelCreatedBy       = labelPrefix + "created_by"
+	// Unix time in seconds at which the host expires.
+	labelExpiresAt = labelPrefix + "expires_at"
+	// Set on unowned warm pool hosts, the value is the machine type of the pool.
+	labelWarmPool = labelPrefix + "warm_pool"
+	// Group owning the host, if any.
+	labelGroup = labelPrefix + "group"
 )
 
 // GCP implementation of the instance manager.
@@ -51,6 +99,9 @@ type GCEInstanceManager struct {
 	Config                Config
 	Service               *compute.Service
 	InstanceNameGenerator NameGenerator
+	// Signals the warm pool refiller a host was taken from a pool.
+	warmPoolRefillCh chan struct{}
+	hostCache        *hostCache
 }
 
 func NewGCEInstanceManager(cfg Config, service *compute.Service, nameGenerator NameGenerator) *GCEInstanceManager {
@@ -58,6 +109,8 @@ func NewGCEInstanceManager(cfg Config, service *compute.Service, nameGenerator N
 		Config:                cfg,
 		Service:               service,
 		InstanceNameGenerator: nameGenerator,
+		warmPoolRefillCh:      make(chan struct{}, 1),
+		hostCache:             newHostCache(time.Duration(cfg.HostClientCacheTTLSeconds) * time.Second),
 	}
 }
 
@@ -82,13 +135,17 @@ func (m *GCEInstanceManager) GetHostAddr(zone string, host string) (string, erro
 	if err != nil {
 		return "", err
 	}
+	return instanceAddr(zone, host, instance)
+}
+
+func instanceAddr(zone string, host string, instance *compute.Instance) (string, error) {
 	ilen := len(instance.NetworkInterfaces)
 	if ilen == 0 {
-		log.Printf("host instance %s in zone %s is missing a network interface", host, zone)
+		logging.Default().Error("Host instance is missing a network interface", "zone", zone, "host", host)
 		return "", errors.NewInternalError("host instance missing a network interface", nil)
 	}
 	if ilen > 1 {
-		log.Printf("host instance %s in zone %s has %d network interfaces", host, zone, ilen)
+		logging.Default().Warn("Host instance has several network interfaces", "zone", zone, "host", host, "count", ilen)
 	}
 	return instance.NetworkInterfaces[0].NetworkIP, nil
 }
@@ -98,6 +155,10 @@ func (m *GCEInstanceManager) GetHostURL(zone string, host string) (*url.URL, err
 	if err != nil {
 		return nil, err
 	}
+	return m.hostURL(addr)
+}
+
+func (m *GCEInstanceManager) hostURL(addr string) (*url.URL, error) {
 	return url.Parse(fmt.Sprintf("%s://%s:%d", m.Config.HostOrchestratorProtocol, addr, m.Config.GCP.HostOrchestratorPort))
 }
 
@@ -107,38 +168,130 @@ func (m *GCEInstanceManager) CreateHost(zone string, req *apiv1.CreateHostReques
 	if err := validateRequest(req); err != nil {
 		return nil, err
 	}
+	if err := validateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+	// Leaves room for the labels set by the orchestrator.
+	if len(req.Labels) > maxLabels-4 {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Too many labels, at most %d allowed", maxLabels-4), nil)
+	}
+	if err := validateGroup(req.Group, user); err != nil {
+		return nil, err
+	}
+	if err := m.validateShape(req.HostInstance.GCP); err != nil {
+		return nil, err
+	}
+	if err := m.checkQuota(zone, user, req.HostInstance.GCP.MachineType); err != nil {
+		return nil, err
+	}
+	if m.hasWarmPool(zone, req.HostInstance.GCP) {
+		if op, ok := m.claimWarmHost(zone, user, req); ok {
+			return op, nil
+		}
+	}
+	payload := m.buildInstance(zone, req.HostInstance.GCP)
+	payload.Labels = ownerLabels(m.Config.GCP, user, req)
+	err := m.setStartupScript(payload, &StartupScriptVars{
+		Owner:    user.Username(),
+		HostName: payload.Name,
+		Zone:     zone,
+		Labels:   req.Labels,
+	})
+	if err != nil {
+		return nil, err
+	}
+	op, err := m.Service.Instances.
+		Insert(m.Config.GCP.ProjectID, zone, payload).
+		Context(context.TODO()).
+		Do()
+	if err != nil {
+		return nil, toAppError(err)
+	}
+	return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, nil
+}
+
+// Builds a new instance with the given shape, without labels.
+func (m *GCEInstanceManager) buildInstance(zone string, gcp *apiv1.GCPInstance) *compute.Instance {
 	payload := &compute.Instance{
 		Name: m.InstanceNameGenerator.NewName(),
 		// This is required in the format: "zones/zone/machineTypes/machine-type".
 		// Read more: https://cloud.google.com/compute/docs/reference/rest/v1/instances/insert#request-body
-		MachineType:    fmt.Sprintf("zones/%s/machineTypes/%s", zone, req.HostInstance.GCP.MachineType),
-		MinCpuPlatform: req.HostInstance.GCP.MinCPUPlatform,
+		MachineType:    fmt.Sprintf("zones/%s/machineTypes/%s", zone, gcp.MachineType),
+		MinCpuPlatform: gcp.MinCPUPlatform,
 		Disks: []*compute.AttachedDisk{
 			{
 				InitializeParams: &compute.AttachedDiskInitializeParams{
 					SourceImage: m.Config.GCP.HostImageFamily,
+					DiskSizeGb:  m.Config.GCP.BootDiskSizeGB,
 				},
 				Boot: true,
 			},
 		},
-		NetworkInterfaces: []*compute.NetworkInterface{
+		NetworkInterfaces: []*compute.NetworkInterface{m.buildNetworkInterface(gcp)},
+		Labels:            map[string]string{},
+	}
+	if gcp.BootDiskSizeGB != 0 {
+		payload.Disks[0].InitializeParams.DiskSizeGb = gcp.BootDiskSizeGB
+	}
+	tags := append([]string{}, m.Config.GCP.NetworkTags...)
+	for _, tag := range gcp.NetworkTags {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > 0 {
+		payload.Tags = &compute.Tags{Items: tags}
+	}
+	if m.Config.GCP.ServiceAccount != "" {
+		scopes := m.Config.GCP.ServiceAccountScopes
+		if len(scopes) == 0 {
+			scopes = []string{compute.CloudPlatformScope}
+		}
+		payload.ServiceAccounts = []*compute.ServiceAccount{
 			{
-				Name: buildDefaultNetworkName(m.Config.GCP.ProjectID),
-				AccessConfigs: []*compute.AccessConfig{
-					{
-						Name: "External NAT",
-						Type: "ONE_TO_ONE_NAT",
-					},
-				},
+				Email:  m.Config.GCP.ServiceAccount,
+				Scopes: scopes,
 			},
-		},
-		Labels: map[string]string{
-			labelCreatedBy: user.Username(),
-		},
+		}
 	}
-	if m.Config.GCP.AcloudCompatible {
-		payload.Labels[labelAcloudCreatedBy] = user.Username()
-		startupScript := acloudSetupScript
+	if gcp.Spot {
+		payload.Scheduling = &compute.Scheduling{
+			ProvisioningModel:         provisioningModelSpot,
+			InstanceTerminationAction: "STOP",
+			// Required by Spot VMs.
+			OnHostMaintenance: "TERMINATE",
+			AutomaticRestart:  googleapi.Bool(false),
+		}
+	}
+	return payload
+}
+
+// Labels identifying the owner of the host and the user's choices.
+func ownerLabels(cfg *GCPIMConfig, user accounts.User, req *apiv1.CreateHostRequest) map[string]string {
+	labels := map[string]string{
+		labelCreatedBy: user.Username(),
+	}
+	for k, v := range req.Labels {
+		labels[k] = v
+	}
+	if req.TTLSeconds > 0 {
+		labels[labelExpiresAt] = expiresAtLabelValue(req.TTLSeconds)
+	}
+	if cfg.AcloudCompatible {
+		labels[labelAcloudCreatedBy] = user.Username()
+	}
+	if req.Group != "" {
+		labels[labelGroup] = req.Group
+	}
+	return labels
+}
+
+func (m *GCEInstanceManager) setStartupScript(payload *compute.Instance, vars *StartupScriptVars) error {
+	startupScript, err := m.buildStartupScript(vars)
+	if err != nil {
+		return err
+	}
+	if startupScript != "" {
 		payload.Metadata = &compute.Metadata{
 			Items: []*compute.MetadataItems{
 				{
@@ -148,18 +301,172 @@ func (m *GCEInstanceManager) CreateHost(zone string, req *apiv1.CreateHostReques
 			},
 		}
 	}
-	op, err := m.Service.Instances.
-		Insert(m.Config.GCP.ProjectID, zone, payload).
+	return nil
+}
+
+func (m *GCEInstanceManager) buildStartupScript(vars *StartupScriptVars) (string, error) {
+	script := ""
+	if m.Config.GCP.AcloudCompatible {
+		script = acloudSetupScript
+	}
+	if len(m.Config.GCP.StartupScriptTemplates) == 0 {
+		return script, nil
+	}
+	templates, err := ParseStartupScriptTemplates(m.Config.GCP.StartupScriptTemplates)
+	if err != nil {
+		return "", errors.NewInternalError("Failed loading startup script templates", err)
+	}
+	rendered, err := renderStartupScript(templates, vars)
+	if err != nil {
+		return "", errors.NewInternalError("Failed rendering startup script", err)
+	}
+	return script + rendered, nil
+}
+
+// Checks the quota allows the user to create a new host. This is best effort, hosts created concurrently
+// may exceed the limits.
+func (m *GCEInstanceManager) checkQuota(zone string, user accounts.User, machineType string) error {
+	quota := &m.Config.Quota
+	if !quota.userLimited() && !quota.zoneLimited() {
+		return nil
+	}
+	usage := quotaUsage{}
+	newVCPUs := 0
+	vcpus := machineTypeVCPUsCache{}
+	if quota.userLimited() {
+		err := m.Service.Instances.
+			AggregatedList(m.Config.GCP.ProjectID).
+			Filter(fmt.Sprintf("labels.%s:%s", labelCreatedBy, user.Username())).
+			Pages(context.TODO(), func(page *compute.InstanceAggregatedList) error {
+				for _, scoped := range page.Items {
+					for _, ins := range scoped.Instances {
+						usage.UserHosts++
+						if quota.MaxVCPUsPerUser == 0 {
+							continue
+						}
+						n, err := m.machineTypeVCPUs(path.Base(ins.Zone), path.Base(ins.MachineType), vcpus)
+						if err != nil {
+							return err
+						}
+						usage.UserVCPUs += n
+					}
+				}
+				return nil
+			})
+		if err != nil {
+			return toAppError(err)
+		}
+		if quota.MaxVCPUsPerUser > 0 {
+			if newVCPUs, err = m.machineTypeVCPUs(zone, machineType, vcpus); err != nil {
+				return toAppError(err)
+			}
+		}
+	}
+	if quota.zoneLimited() {
+		err := m.Service.Instances.
+			List(m.Config.GCP.ProjectID, zone).
+			Filter(fmt.Sprintf("labels.%s:*", labelCreatedBy)).
+			Pages(context.TODO(), func(page *compute.InstanceList) error {
+				usage.ZoneHosts += len(page.Items)
+				return nil
+			})
+		if err != nil {
+			return toAppError(err)
+		}
+	}
+	return quota.check(usage, newVCPUs)
+}
+
+// Number of vCPUs by zone and machine type name.
+type machineTypeVCPUsCache map[string]int
+
+func (m *GCEInstanceManager) machineTypeVCPUs(zone, machineType string, cache machineTypeVCPUsCache) (int, error) {
+	key := zone + "/" + machineType
+	if n, ok := cache[key]; ok {
+		return n, nil
+	}
+	mt, err := m.Service.MachineTypes.
+		Get(m.Config.GCP.ProjectID, zone, machineType).
 		Context(context.TODO()).
 		Do()
 	if err != nil {
-		return nil, toAppError(err)
+		return 0, err
 	}
-	return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, nil
+	cache[key] = int(mt.GuestCpus)
+	return cache[key], nil
+}
+
+const provisioningModelSpot = "SPOT"
+
+// Validates the requested host shape is within the limits set by the administrator.
+func (m *GCEInstanceManager) validateShape(in *apiv1.GCPInstance) error {
+	cfg := m.Config.GCP
+	if in.BootDiskSizeGB < 0 || in.BootDiskSizeGB > cfg.MaxBootDiskSizeGB {
+		return errors.NewBadRequestError(
+			fmt.Sprintf("Invalid boot disk size: %d GB, the maximum allowed is %d GB", in.BootDiskSizeGB, cfg.MaxBootDiskSizeGB), nil)
+	}
+	if in.Subnetwork != "" && in.Subnetwork != cfg.Subnetwork && !contains(cfg.AllowedSubnetworks, in.Subnetwork) {
+		return errors.NewBadRequestError(fmt.Sprintf("Subnetwork not allowed: %q", in.Subnetwork), nil)
+	}
+	for _, tag := range in.NetworkTags {
+		if !contains(cfg.NetworkTags, tag) && !contains(cfg.AllowedNetworkTags, tag) {
+			return errors.NewBadRequestError(fmt.Sprintf("Network tag not allowed: %q", tag), nil)
+		}
+	}
+	if in.Spot && !cfg.AllowSpot {
+		return errors.NewBadRequestError("Spot VMs are not allowed", nil)
+	}
+	return nil
+}
+
+func (m *GCEInstanceManager) buildNetworkInterface(in *apiv1.GCPInstance) *compute.NetworkInterface {
+	cfg := m.Config.GCP
+	result := &compute.NetworkInterface{}
+	subnetwork := cfg.Subnetwork
+	if in.Subnetwork != "" {
+		subnetwork = in.Subnetwork
+	}
+	if cfg.Network == "" && subnetwork == "" {
+		result.Name = buildDefaultNetworkName(cfg.ProjectID)
+	} else {
+		result.Network = cfg.Network
+		result.Subnetwork = subnetwork
+	}
+	if !cfg.NoExternalIP && !in.NoExternalIP {
+		result.AccessConfigs = []*compute.AccessConfig{
+			{
+				Name: "External NAT",
+				Type: "ONE_TO_ONE_NAT",
+			},
+		}
+	}
+	return result
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
 }
 
 const listHostsRequestMaxResultsLimit uint32 = 500
 
+// GCE instance statuses map one to one to host statuses.
+var hostStatuses = map[string]bool{
+	apiv1.HostStatusProvisioning: true,
+	apiv1.HostStatusStaging:      true,
+	apiv1.HostStatusRunning:      true,
+	apiv1.HostStatusStopping:     true,
+	apiv1.HostStatusStopped:      true,
+	apiv1.HostStatusSuspending:   true,
+	apiv1.HostStatusSuspended:    true,
+	apiv1.HostStatusRepairing:    true,
+	apiv1.HostStatusTerminated:   true,
+}
+
 func (m *GCEInstanceManager) ListHosts(zone string, user accounts.User, req *ListHostsRequest) (*apiv1.ListHostsResponse, error) {
 	var maxResults uint32
 	if req.MaxResults <= listHostsRequestMaxResultsLimit {
@@ -167,14 +474,25 @@ func (m *GCEInstanceManager) ListHosts(zone string, user accounts.User, req *Lis
 	} else {
 		maxResults = listHostsRequestMaxResultsLimit
 	}
-	statusFilterExpr := "status=RUNNING"
-	ownerFilterExpr := fmt.Sprintf("labels.%s:%s", labelCreatedBy, user.Username())
+	filterExpr := ownerFilterExpr(user)
+	if req.AllUsers {
+		filterExpr = allUsersFilterExpr
+	}
+	if req.Status != "" {
+		if !hostStatuses[req.Status] {
+			return nil, errors.NewBadRequestError(fmt.Sprintf("Invalid host status: %q", req.Status), nil)
+		}
+		filterExpr += " AND status=" + req.Status
+	}
+	for _, r := range req.LabelSelector {
+		filterExpr += " AND " + labelRequirementFilterExpr(r)
+	}
 	res, err := m.Service.Instances.
 		List(m.Config.GCP.ProjectID, zone).
 		Context(context.TODO()).
 		MaxResults(int64(maxResults)).
 		PageToken(req.PageToken).
-		Filter(fmt.Sprintf("%s AND %s", ownerFilterExpr, statusFilterExpr)).
+		Filter(filterExpr).
 		Do()
 	if err != nil {
 		return nil, toAppError(err)
@@ -195,11 +513,14 @@ func (m *GCEInstanceManager) ListHosts(zone string, user accounts.User, req *Lis
 
 func (m *GCEInstanceManager) DeleteHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
 	nameFilterExpr := "name=" + name
-	ownerFilterExpr := fmt.Sprintf("labels.%s:%s", labelCreatedBy, user.Username())
+	ownerExpr := ownerFilterExpr(user)
+	if accounts.HasAdminRights(user) {
+		ownerExpr = allUsersFilterExpr
+	}
 	res, err := m.Service.Instances.
 		List(m.Config.GCP.ProjectID, zone).
 		Context(context.TODO()).
-		Filter(fmt.Sprintf("%s AND %s", nameFilterExpr, ownerFilterExpr)).
+		Filter(fmt.Sprintf("%s AND %s", nameFilterExpr, ownerExpr)).
 		Do()
 	if err != nil {
 		return nil, toAppError(err)
@@ -214,6 +535,109 @@ func (m *GCEInstanceManager) DeleteHost(zone string, user accounts.User, name st
 	if err != nil {
 		return nil, toAppError(err)
 	}
+	m.hostCache.invalidate(zone, name)
+	return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, nil
+}
+
+func (m *GCEInstanceManager) ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error) {
+	if req.TTLSeconds <= 0 {
+		return nil, errors.NewBadRequestError("invalid ExtendHostRequest: ttl must be positive", nil)
+	}
+	ins, err := m.getHostInstance(zone, name)
+	if err != nil {
+		return nil, toAppError(err)
+	}
+	if !isOwner(ins, user) {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Host instance %q not found.", name), nil)
+	}
+	labels := map[string]string{}
+	for k, v := range ins.Labels {
+		labels[k] = v
+	}
+	labels[labelExpiresAt] = expiresAtLabelValue(req.TTLSeconds)
+	setLabelsReq := &compute.InstancesSetLabelsRequest{
+		Labels: labels,
+		// Makes the request fail if the labels were modified since they were read.
+		LabelFingerprint: ins.LabelFingerprint,
+	}
+	op, err := m.Service.Instances.
+		SetLabels(m.Config.GCP.ProjectID, zone, name, setLabelsReq).
+		Context(context.TODO()).
+		Do()
+	if err != nil {
+		return nil, toAppError(err)
+	}
+	return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, nil
+}
+
+func (m *GCEInstanceManager) DeleteExpiredHosts() error {
+	now := time.Now()
+	var merr error
+	err := m.Service.Instances.
+		AggregatedList(m.Config.GCP.ProjectID).
+		Filter(fmt.Sprintf("labels.%s:*", labelExpiresAt)).
+		Pages(context.TODO(), func(page *compute.InstanceAggregatedList) error {
+			for _, scoped := range page.Items {
+				for _, ins := range scoped.Instances {
+					expiresAt, ok := instanceExpiration(ins)
+					if !ok || expiresAt.After(now) {
+						continue
+					}
+					zone := path.Base(ins.Zone)
+					logging.Default().Info("Deleting expired host instance", "zone", zone, "host", ins.Name, "expired_at", expiresAt)
+					_, err := m.Service.Instances.
+						Delete(m.Config.GCP.ProjectID, zone, ins.Name).
+						Context(context.TODO()).
+						Do()
+					if err != nil {
+						merr = multierror.Append(merr, fmt.Errorf("failed to delete host %q: %w", ins.Name, err))
+					}
+					m.hostCache.invalidate(zone, ins.Name)
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return toAppError(err)
+	}
+	return merr
+}
+
+func (m *GCEInstanceManager) StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.doHostOperation(zone, user, name, func() (*compute.Operation, error) {
+		return m.Service.Instances.Stop(m.Config.GCP.ProjectID, zone, name).Context(context.TODO()).Do()
+	})
+}
+
+func (m *GCEInstanceManager) StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.doHostOperation(zone, user, name, func() (*compute.Operation, error) {
+		return m.Service.Instances.Start(m.Config.GCP.ProjectID, zone, name).Context(context.TODO()).Do()
+	})
+}
+
+func (m *GCEInstanceManager) SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.doHostOperation(zone, user, name, func() (*compute.Operation, error) {
+		return m.Service.Instances.Suspend(m.Config.GCP.ProjectID, zone, name).Context(context.TODO()).Do()
+	})
+}
+
+func (m *GCEInstanceManager) ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.doHostOperation(zone, user, name, func() (*compute.Operation, error) {
+		return m.Service.Instances.Resume(m.Config.GCP.ProjectID, zone, name).Context(context.TODO()).Do()
+	})
+}
+
+// Runs the given operation on the host instance after checking the user owns it.
+func (m *GCEInstanceManager) doHostOperation(zone string, user accounts.User, name string, do func() (*compute.Operation, error)) (*apiv1.Operation, error) {
+	if err := m.AuthorizeHostAccess(zone, user, name); err != nil {
+		return nil, err
+	}
+	op, err := do()
+	if err != nil {
+		return nil, toAppError(err)
+	}
+	// The host's address may change, e.g. when it's started again.
+	m.hostCache.invalidate(zone, name)
 	return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, nil
 }
 
@@ -222,6 +646,9 @@ func (m *GCEInstanceManager) WaitOperation(zone string, user accounts.User, name
 	if err != nil {
 		return nil, toAppError(err)
 	}
+	if err := m.authorizeOperationAccess(user, op); err != nil {
+		return nil, err
+	}
 	if op.Status != operationStatusDone {
 		return nil, errors.NewServiceUnavailableError("Wait for operation timed out", nil)
 	}
@@ -229,12 +656,63 @@ func (m *GCEInstanceManager) WaitOperation(zone string, user accounts.User, name
 	return getter.Get()
 }
 
+// Ownership is always read from the instance, it changes when warm pool hosts are claimed or labels are edited.
+func (m *GCEInstanceManager) AuthorizeHostAccess(zone string, user accounts.User, name string) error {
+	ins, err := m.getHostInstance(zone, name)
+	if err != nil {
+		return toAppError(err)
+	}
+	if !canAccessHost(ins.Labels[labelCreatedBy], ins.Labels[labelGroup], user) {
+		return errors.NewNotFoundError(fmt.Sprintf("Host instance %q not found.", name), nil)
+	}
+	return nil
+}
+
+// Operations don't carry ownership information, the owner of the target instance is used instead. If the
+// instance doesn't exist, because it was deleted or never created, there is nothing left to protect and access
+// is granted so the user is able to see the result of the operation.
+func (m *GCEInstanceManager) authorizeOperationAccess(user accounts.User, op *compute.Operation) error {
+	matches := instanceTargetLinkRe.FindStringSubmatch(op.TargetLink)
+	if len(matches) != 4 {
+		return errors.NewNotFoundError(fmt.Sprintf("Operation %q not found.", op.Name), nil)
+	}
+	ins, err := m.Service.Instances.
+		Get(matches[1], matches[2], matches[3]).
+		Context(context.TODO()).
+		Do()
+	if err != nil {
+		if isNotFoundError(err) {
+			return nil
+		}
+		return toAppError(err)
+	}
+	if !isOwner(ins, user) {
+		return errors.NewNotFoundError(fmt.Sprintf("Operation %q not found.", op.Name), nil)
+	}
+	return nil
+}
+
+// Host clients are cached so repeated requests to the same host, like WebRTC polling, don't look up the
+// host every time and reuse the same connections.
 func (m *GCEInstanceManager) GetHostClient(zone string, host string) (HostClient, error) {
-	url, err := m.GetHostURL(zone, host)
+	if client, ok := m.hostCache.get(zone, host); ok {
+		return client, nil
+	}
+	ins, err := m.getHostInstance(zone, host)
+	if err != nil {
+		return nil, toAppError(err)
+	}
+	addr, err := instanceAddr(zone, host, ins)
 	if err != nil {
 		return nil, err
 	}
-	return NewNetHostClient(url, m.Config.AllowSelfSignedHostSSLCertificate), nil
+	url, err := m.hostURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	client := NewNetHostClient(url, m.Config.AllowSelfSignedHostSSLCertificate)
+	m.hostCache.put(zone, host, client)
+	return client, nil
 }
 
 func (m *GCEInstanceManager) getHostInstance(zone string, host string) (*compute.Instance, error) {
@@ -248,6 +726,16 @@ func validateRequest(r *apiv1.CreateHostRequest) error {
 	if r.HostInstance == nil ||
 		r.HostInstance.Name != "" ||
 		r.HostInstance.BootDiskSizeGB != 0 ||
+		r.HostInstance.ExpirationTime != "" ||
+		r.HostInstance.Status != "" ||
+		r.HostInstance.CreationTime != "" ||
+		r.HostInstance.Owner != "" ||
+		r.HostInstance.Group != "" ||
+		r.HostInstance.InternalIP != "" ||
+		r.HostInstance.ExternalIP != "" ||
+		len(r.HostInstance.Labels) != 0 ||
+		r.HostInstance.Ready ||
+		r.TTLSeconds < 0 ||
 		r.HostInstance.GCP == nil ||
 		r.HostInstance.GCP.MachineType == "" {
 		return errors.NewBadRequestError("invalid CreateHostRequest", nil)
@@ -255,6 +743,55 @@ func validateRequest(r *apiv1.CreateHostRequest) error {
 	return nil
 }
 
+func isOwner(ins *compute.Instance, user accounts.User) bool {
+	return canAccessHost(ins.Labels[labelCreatedBy], ins.Labels[labelGroup], user)
+}
+
+// Filter expression matching the hosts created by any user, warm hosts excluded.
+const allUsersFilterExpr = "labels." + labelCreatedBy + ":*"
+
+// Filter expression matching the hosts owned by the user.
+func ownerFilterExpr(user accounts.User) string {
+	expr := fmt.Sprintf("labels.%s:%s", labelCreatedBy, user.Username())
+	terms := []string{}
+	for _, g := range accounts.UserGroups(user) {
+		// Other groups can't own hosts and aren't safe to embed in the expression.
+		if labelValueRe.MatchString(g) && g != "" {
+			terms = append(terms, fmt.Sprintf("(labels.%s:%s)", labelGroup, g))
+		}
+	}
+	if len(terms) == 0 {
+		return expr
+	}
+	return "((" + expr + ") OR " + strings.Join(terms, " OR ") + ")"
+}
+
+// Label requirements are validated when parsed, so they are safe to embed in the filter expression.
+func labelRequirementFilterExpr(r LabelRequirement) string {
+	if r.Op == LabelOpExists {
+		return fmt.Sprintf("labels.%s:*", r.Key)
+	}
+	return fmt.Sprintf("labels.%s%s%q", r.Key, r.Op, r.Value)
+}
+
+func expiresAtLabelValue(ttlSeconds int64) string {
+	return strconv.FormatInt(time.Now().Unix()+ttlSeconds, 10)
+}
+
+// Returns the time the instance expires at and whether it expires at all.
+func instanceExpiration(in *compute.Instance) (time.Time, bool) {
+	value, ok := in.Labels[labelExpiresAt]
+	if !ok {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logging.Default().Warn("Invalid host instance: malformed label", "instance", in.SelfLink, "label", labelExpiresAt, "value", value)
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0).UTC(), true
+}
+
 func buildDefaultNetworkName(projectID string) string {
 	return fmt.Sprintf("projects/%s/global/networks/default", projectID)
 }
@@ -262,20 +799,50 @@ func buildDefaultNetworkName(projectID string) string {
 func BuildHostInstance(in *compute.Instance) (*apiv1.HostInstance, error) {
 	disksLen := len(in.Disks)
 	if disksLen == 0 {
-		log.Printf("invalid host instance %q: has 0 disks", in.SelfLink)
+		logging.Default().Error("Invalid host instance: has 0 disks", "instance", in.SelfLink)
 		return nil, errors.NewInternalError("invalid host instance: has 0 disks", nil)
 	}
 	if disksLen > 1 {
-		log.Printf("invalid host instance %q: has %d (more than one) disks", in.SelfLink, disksLen)
+		logging.Default().Warn("Invalid host instance: has more than one disk", "instance", in.SelfLink, "count", disksLen)
 	}
-	return &apiv1.HostInstance{
+	result := &apiv1.HostInstance{
 		Name:           in.Name,
 		BootDiskSizeGB: in.Disks[0].DiskSizeGb,
+		Status:         in.Status,
+		CreationTime:   in.CreationTimestamp,
+		Owner:          in.Labels[labelCreatedBy],
+		Group:          in.Labels[labelGroup],
 		GCP: &apiv1.GCPInstance{
 			MachineType:    path.Base(in.MachineType),
 			MinCPUPlatform: in.MinCpuPlatform,
 		},
-	}, nil
+	}
+	if in.Tags != nil {
+		result.GCP.NetworkTags = in.Tags.Items
+	}
+	if in.Scheduling != nil && in.Scheduling.ProvisioningModel == provisioningModelSpot {
+		result.GCP.Spot = true
+	}
+	if expiresAt, ok := instanceExpiration(in); ok {
+		result.ExpirationTime = expiresAt.Format(time.RFC3339)
+	}
+	if len(in.NetworkInterfaces) > 0 {
+		ni := in.NetworkInterfaces[0]
+		result.InternalIP = ni.NetworkIP
+		if len(ni.AccessConfigs) > 0 {
+			result.ExternalIP = ni.AccessConfigs[0].NatIP
+		}
+	}
+	for k, v := range in.Labels {
+		if isReservedLabel(k) {
+			continue
+		}
+		if result.Labels == nil {
+			result.Labels = map[string]string{}
+		}
+		result.Labels[k] = v
+	}
+	return result, nil
 }
 
 const hostInstanceNamePrefix = "cf-"
@@ -296,6 +863,16 @@ var (
 	instanceTargetLinkRe = regexp.MustCompile(`^https://.+/compute/v1/projects/(.+)/zones/(.+)/instances/(.+)$`)
 )
 
+// Types of operations whose result is the target instance.
+var instanceResultOperationTypes = map[string]bool{
+	"insert":    true,
+	"setLabels": true,
+	"stop":      true,
+	"start":     true,
+	"suspend":   true,
+	"resume":    true,
+}
+
 type opResultGetter struct {
 	Service *compute.Service
 	Op      *compute.Operation
@@ -316,16 +893,16 @@ func (g *opResultGetter) Get() (any, error) {
 	if g.Op.OperationType == "delete" && instanceTargetLinkRe.MatchString(g.Op.TargetLink) {
 		return struct{}{}, nil
 	}
-	if g.Op.OperationType == "insert" && instanceTargetLinkRe.MatchString(g.Op.TargetLink) {
-		return g.buildCreateInstanceResult()
+	if instanceResultOperationTypes[g.Op.OperationType] && instanceTargetLinkRe.MatchString(g.Op.TargetLink) {
+		return g.buildInstanceResult()
 	}
 	return nil, errors.NewNotFoundError("operation result not found", nil)
 }
 
-func (g *opResultGetter) buildCreateInstanceResult() (*apiv1.HostInstance, error) {
+func (g *opResultGetter) buildInstanceResult() (*apiv1.HostInstance, error) {
 	matches := instanceTargetLinkRe.FindStringSubmatch(g.Op.TargetLink)
 	if len(matches) != 4 {
-		err := fmt.Errorf("invalid target link for instance insert operation: %q", g.Op.TargetLink)
+		err := fmt.Errorf("invalid target link for instance %s operation: %q", g.Op.OperationType, g.Op.TargetLink)
 		return nil, err
 	}
 	ins, err := g.Service.Instances.
@@ -338,6 +915,11 @@ func (g *opResultGetter) buildCreateInstanceResult() (*apiv1.HostInstance, error
 	return BuildHostInstance(ins)
 }
 
+func isNotFoundError(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
 // Converts compute API errors to AppError if relevant, return the same error otherwise
 func toAppError(err error) error {
 	apiErr, ok := err.(*googleapi.Error)
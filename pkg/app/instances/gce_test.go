@@ -23,11 +23,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
 	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
 
 	"github.com/google/go-cmp/cmp"
@@ -53,6 +58,16 @@ func (i *TestUser) Username() string {
 	return fakeUsername
 }
 
+// Returns the user as a member of the "camera" group.
+func cameraMember(user accounts.User) accounts.User {
+	return accounts.WithConfiguredGroups(user, map[string][]string{"camera": {user.Username()}})
+}
+
+// Returns the user with the admin role.
+func admin(user accounts.User) accounts.User {
+	return accounts.ConfigureUser(user, &accounts.Config{Roles: map[string]accounts.Role{user.Username(): accounts.RoleAdmin}})
+}
+
 func TestCreateHostInvalidRequests(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		replyJSON(w, &compute.Operation{})
@@ -81,6 +96,12 @@ func TestCreateHostInvalidRequests(t *testing.T) {
 		{func(r *apiv1.CreateHostRequest) { r.HostInstance = nil }},
 		{func(r *apiv1.CreateHostRequest) { r.HostInstance.Name = "foo" }},
 		{func(r *apiv1.CreateHostRequest) { r.HostInstance.BootDiskSizeGB = 1 }},
+		{func(r *apiv1.CreateHostRequest) { r.HostInstance.ExpirationTime = "2023-01-01T08:00:00Z" }},
+		{func(r *apiv1.CreateHostRequest) { r.TTLSeconds = -1 }},
+		{func(r *apiv1.CreateHostRequest) { r.Labels = map[string]string{"cf-created_by": "janedoe"} }},
+		{func(r *apiv1.CreateHostRequest) { r.Labels = map[string]string{"created_by": "janedoe"} }},
+		{func(r *apiv1.CreateHostRequest) { r.Labels = map[string]string{"Team": "camera"} }},
+		{func(r *apiv1.CreateHostRequest) { r.HostInstance.Labels = map[string]string{"team": "camera"} }},
 		{func(r *apiv1.CreateHostRequest) { r.HostInstance.GCP = nil }},
 		{func(r *apiv1.CreateHostRequest) { r.HostInstance.GCP.MachineType = "" }},
 	}
@@ -178,6 +199,245 @@ func TestCreateHostRequestBody(t *testing.T) {
 	}
 }
 
+func TestCreateHostCustomShapeRequestBody(t *testing.T) {
+	var bodySent compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &bodySent)
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	config := Config{
+		GCP: &GCPIMConfig{
+			ProjectID:          "google.com:test-project",
+			HostImageFamily:    "projects/test-project-releases/global/images/family/foo",
+			BootDiskSizeGB:     100,
+			MaxBootDiskSizeGB:  500,
+			Network:            "projects/test-project/global/networks/bar",
+			Subnetwork:         "regions/us-central1/subnetworks/baz",
+			AllowedSubnetworks: []string{"regions/us-central1/subnetworks/qux"},
+			NetworkTags:        []string{"cf-host"},
+			AllowedNetworkTags: []string{"allow-ssh"},
+			ServiceAccount:     "hosts@test-project.iam.gserviceaccount.com",
+			AllowSpot:          true,
+		},
+	}
+	im := NewGCEInstanceManager(config, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{
+					MachineType:    "n1-standard-1",
+					BootDiskSizeGB: 300,
+					Subnetwork:     "regions/us-central1/subnetworks/qux",
+					NetworkTags:    []string{"allow-ssh"},
+					NoExternalIP:   true,
+					Spot:           true,
+				},
+			},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "disks": [
+    {
+      "boot": true,
+      "initializeParams": {
+        "diskSizeGb": "300",
+        "sourceImage": "projects/test-project-releases/global/images/family/foo"
+      }
+    }
+  ],
+  "labels": {
+    "cf-created_by": "` + fakeUsername + `"
+  },
+  "machineType": "zones/us-central1-a/machineTypes/n1-standard-1",
+  "name": "foo",
+  "networkInterfaces": [
+    {
+      "network": "projects/test-project/global/networks/bar",
+      "subnetwork": "regions/us-central1/subnetworks/qux"
+    }
+  ],
+  "scheduling": {
+    "automaticRestart": false,
+    "instanceTerminationAction": "STOP",
+    "onHostMaintenance": "TERMINATE",
+    "provisioningModel": "SPOT"
+  },
+  "serviceAccounts": [
+    {
+      "email": "hosts@test-project.iam.gserviceaccount.com",
+      "scopes": [
+        "https://www.googleapis.com/auth/cloud-platform"
+      ]
+    }
+  ],
+  "tags": {
+    "items": [
+      "cf-host",
+      "allow-ssh"
+    ]
+  }
+}`
+	r := prettyJSON(t, &bodySent)
+	if r != expected {
+		t.Errorf("unexpected body, diff: %s", diffPrettyText(r, expected))
+	}
+}
+
+func TestCreateHostShapeNotAllowed(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request: %s", r.URL.Path)
+	}))
+	defer ts.Close()
+	config := Config{
+		GCP: &GCPIMConfig{
+			ProjectID:          "google.com:test-project",
+			HostImageFamily:    "projects/test-project-releases/global/images/family/foo",
+			MaxBootDiskSizeGB:  500,
+			AllowedSubnetworks: []string{"regions/us-central1/subnetworks/qux"},
+			AllowedNetworkTags: []string{"allow-ssh"},
+		},
+	}
+	im := NewGCEInstanceManager(config, buildTestService(t, ts), testNameGenerator)
+	tests := []struct {
+		name string
+		gcp  apiv1.GCPInstance
+	}{
+		{"disk too big", apiv1.GCPInstance{BootDiskSizeGB: 501}},
+		{"subnetwork", apiv1.GCPInstance{Subnetwork: "regions/us-central1/subnetworks/baz"}},
+		{"network tag", apiv1.GCPInstance{NetworkTags: []string{"allow-ssh", "allow-all"}}},
+		{"spot", apiv1.GCPInstance{Spot: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gcp := tc.gcp
+			gcp.MachineType = "n1-standard-1"
+			req := &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{GCP: &gcp}}
+
+			_, err := im.CreateHost("us-central1-a", req, &TestUser{})
+
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected bad request error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateHostWithLabels(t *testing.T) {
+	var postedInstance compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &postedInstance)
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+			Labels: map[string]string{"team": "camera", "ci-run": "1234"},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{labelCreatedBy: fakeUsername, "team": "camera", "ci-run": "1234"}
+	if diff := cmp.Diff(want, postedInstance.Labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateHostQuota(t *testing.T) {
+	inserted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/aggregated/instances":
+			replyJSON(w, &compute.InstanceAggregatedList{
+				Items: map[string]compute.InstancesScopedList{
+					"zones/us-central1-a": {
+						Instances: []*compute.Instance{
+							{
+								Name:        "bar",
+								Zone:        "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a",
+								MachineType: "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a/machineTypes/n1-standard-4",
+							},
+						},
+					},
+				},
+			})
+		case "/projects/google.com:test-project/zones/us-central1-a/machineTypes/n1-standard-4":
+			replyJSON(w, &compute.MachineType{GuestCpus: 4})
+		case "/projects/google.com:test-project/zones/us-central1-a/instances":
+			if r.Method == http.MethodPost {
+				inserted = true
+				replyJSON(w, &compute.Operation{Name: "operation-1"})
+			} else {
+				replyJSON(w, &compute.InstanceList{Items: []*compute.Instance{{Name: "bar"}, {Name: "baz"}}})
+			}
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	req := &apiv1.CreateHostRequest{
+		HostInstance: &apiv1.HostInstance{
+			GCP: &apiv1.GCPInstance{MachineType: "n1-standard-4"},
+		},
+	}
+	tests := []struct {
+		name    string
+		quota   QuotaConfig
+		expCode int
+	}{
+		{"within limits", QuotaConfig{MaxHostsPerUser: 2, MaxVCPUsPerUser: 8, MaxHostsPerZone: 3}, 0},
+		{"hosts per user", QuotaConfig{MaxHostsPerUser: 1}, http.StatusForbidden},
+		{"vcpus per user", QuotaConfig{MaxVCPUsPerUser: 7}, http.StatusForbidden},
+		{"hosts per zone", QuotaConfig{MaxHostsPerZone: 2}, http.StatusTooManyRequests},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inserted = false
+			cfg := testConfig
+			cfg.Quota = test.quota
+			im := NewGCEInstanceManager(cfg, buildTestService(t, ts), testNameGenerator)
+
+			_, err := im.CreateHost("us-central1-a", req, &TestUser{})
+
+			if test.expCode == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !inserted {
+					t.Error("host was not inserted")
+				}
+				return
+			}
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) {
+				t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+			}
+			if diff := cmp.Diff(test.expCode, appErr.StatusCode); diff != "" {
+				t.Errorf("status code mismatch (-want +got):\n%s", diff)
+			}
+			if inserted {
+				t.Error("host was inserted despite exceeding the quota")
+			}
+		})
+	}
+}
+
 func TestCreateHostAcloudCompatible(t *testing.T) {
 	var postedInstance compute.Instance
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -224,6 +484,349 @@ func TestCreateHostAcloudCompatible(t *testing.T) {
 	}
 }
 
+func TestCreateHostStartupScriptTemplates(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.sh.tmpl")
+	second := filepath.Join(dir, "second.sh.tmpl")
+	if err := os.WriteFile(first, []byte("#!/bin/bash\necho {{.Owner}} {{.HostName}} {{.Zone}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("echo {{index .Labels \"team\"}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var postedInstance compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &postedInstance)
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	config := Config{
+		GCP: &GCPIMConfig{
+			ProjectID:              "google.com:test-project",
+			HostImageFamily:        "projects/test-project-releases/global/images/family/foo",
+			StartupScriptTemplates: []string{first, second},
+		},
+	}
+	im := NewGCEInstanceManager(config, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+			Labels: map[string]string{"team": "camera"},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("startup-script", postedInstance.Metadata.Items[0].Key); diff != "" {
+		t.Errorf("metadata item key (-want +got):\n%s", diff)
+	}
+	expected := "#!/bin/bash\necho johndoe foo us-central1-a\necho camera\n"
+	if diff := cmp.Diff(expected, *postedInstance.Metadata.Items[0].Value); diff != "" {
+		t.Errorf("metadata item value (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateHostStartupScriptTemplateFails(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "startup.sh.tmpl")
+	if err := os.WriteFile(tmpl, []byte("echo {{.Unknown}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request: %s", r.URL.Path)
+	}))
+	defer ts.Close()
+	config := Config{
+		GCP: &GCPIMConfig{
+			ProjectID:              "google.com:test-project",
+			HostImageFamily:        "projects/test-project-releases/global/images/family/foo",
+			StartupScriptTemplates: []string{tmpl},
+		},
+	}
+	im := NewGCEInstanceManager(config, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+		},
+		&TestUser{})
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected internal error, got: %v", err)
+	}
+}
+
+func TestCreateHostWithTTL(t *testing.T) {
+	var postedInstance compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &postedInstance)
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+	before := time.Now().Unix()
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+			TTLSeconds: 3600,
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresAt, err := strconv.ParseInt(postedInstance.Labels[labelExpiresAt], 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expiresAt < before+3600 || expiresAt > time.Now().Unix()+3600 {
+		t.Errorf("unexpected expiration <<%d>>, want around %d", expiresAt, before+3600)
+	}
+}
+
+func TestExtendHost(t *testing.T) {
+	var setLabelsReq compute.InstancesSetLabelsRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{
+				Name:             "foo",
+				Labels:           map[string]string{labelCreatedBy: fakeUsername, labelExpiresAt: "1"},
+				LabelFingerprint: "fingerprint",
+			})
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo/setLabels":
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &setLabelsReq)
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		default:
+			replyNotFound(w)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	op, err := im.ExtendHost("us-central1-a", &TestUser{}, "foo", &apiv1.ExtendHostRequest{TTLSeconds: 3600})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("operation-1", op.Name); diff != "" {
+		t.Errorf("operation name mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("fingerprint", setLabelsReq.LabelFingerprint); diff != "" {
+		t.Errorf("label fingerprint mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(fakeUsername, setLabelsReq.Labels[labelCreatedBy]); diff != "" {
+		t.Errorf("created by label mismatch (-want +got):\n%s", diff)
+	}
+	if setLabelsReq.Labels[labelExpiresAt] == "1" {
+		t.Error("expiration label was not updated")
+	}
+}
+
+func TestExtendHostInvalidRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: "janedoe"}})
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+	tests := []struct {
+		name    string
+		req     *apiv1.ExtendHostRequest
+		expCode int
+	}{
+		{"zero ttl", &apiv1.ExtendHostRequest{}, http.StatusBadRequest},
+		{"not owned", &apiv1.ExtendHostRequest{TTLSeconds: 3600}, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := im.ExtendHost("us-central1-a", &TestUser{}, "foo", test.req)
+
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) {
+				t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+			}
+			if diff := cmp.Diff(test.expCode, appErr.StatusCode); diff != "" {
+				t.Errorf("status code mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHostOperations(t *testing.T) {
+	var pathSent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: fakeUsername}})
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/bar":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: "janedoe"}})
+		default:
+			pathSent = path
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+	tests := []struct {
+		name string
+		run  func(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+	}{
+		{"stop", im.StopHost},
+		{"start", im.StartHost},
+		{"suspend", im.SuspendHost},
+		{"resume", im.ResumeHost},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pathSent = ""
+
+			op, err := test.run("us-central1-a", &TestUser{}, "foo")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff("operation-1", op.Name); diff != "" {
+				t.Errorf("operation name mismatch (-want +got):\n%s", diff)
+			}
+			expected := "/projects/google.com:test-project/zones/us-central1-a/instances/foo/" + test.name
+			if diff := cmp.Diff(expected, pathSent); diff != "" {
+				t.Errorf("url path mismatch (-want +got):\n%s", diff)
+			}
+		})
+		t.Run(test.name+" not owned", func(t *testing.T) {
+			pathSent = ""
+
+			_, err := test.run("us-central1-a", &TestUser{}, "bar")
+
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) {
+				t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+			}
+			if diff := cmp.Diff(http.StatusNotFound, appErr.StatusCode); diff != "" {
+				t.Errorf("status code mismatch (-want +got):\n%s", diff)
+			}
+			if pathSent != "" {
+				t.Errorf("unexpected request to %q", pathSent)
+			}
+		})
+	}
+}
+
+func TestWaitStopInstanceOperationSucceeds(t *testing.T) {
+	operation := &compute.Operation{
+		Name:          "operation-1",
+		OperationType: "stop",
+		TargetLink:    "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a/instances/foo",
+		Status:        "DONE",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/operations/operation-1/wait":
+			replyJSON(w, operation)
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{
+				Disks:       []*compute.AttachedDisk{{DiskSizeGb: 10}},
+				Name:        "foo",
+				MachineType: "zones/us-central1-a/machineTypes/n1-standard-1",
+				Labels:      map[string]string{labelCreatedBy: fakeUsername},
+			})
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	res, err := im.WaitOperation("us-central1-a", &TestUser{}, "operation-1")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &apiv1.HostInstance{
+		Name:           "foo",
+		BootDiskSizeGB: 10,
+		Owner:          fakeUsername,
+		GCP:            &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+	}
+	if diff := cmp.Diff(want, res); diff != "" {
+		t.Errorf("result mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeleteExpiredHosts(t *testing.T) {
+	var deleted []string
+	var filter string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/projects/google.com:test-project/aggregated/instances":
+			filter = r.URL.Query().Get("filter")
+			replyJSON(w, &compute.InstanceAggregatedList{
+				Items: map[string]compute.InstancesScopedList{
+					"zones/us-central1-a": {
+						Instances: []*compute.Instance{
+							{
+								Name:   "expired",
+								Zone:   "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a",
+								Labels: map[string]string{labelExpiresAt: "1"},
+							},
+							{
+								Name:   "alive",
+								Zone:   "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a",
+								Labels: map[string]string{labelExpiresAt: "99999999999"},
+							},
+							{
+								Name:   "malformed",
+								Zone:   "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a",
+								Labels: map[string]string{labelExpiresAt: "foo"},
+							},
+						},
+					},
+				},
+			})
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, path)
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	err := im.DeleteExpiredHosts()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("labels.cf-expires_at:*", filter); diff != "" {
+		t.Errorf("filter mismatch (-want +got):\n%s", diff)
+	}
+	want := []string{"/projects/google.com:test-project/zones/us-central1-a/instances/expired"}
+	if diff := cmp.Diff(want, deleted); diff != "" {
+		t.Errorf("deleted hosts mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestCreateHostSuccess(t *testing.T) {
 	expectedName := "operation-1"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -325,18 +928,75 @@ func TestListHostsRequestQuery(t *testing.T) {
 	req := &ListHostsRequest{
 		MaxResults: 100,
 		PageToken:  "foo",
+		Status:     "RUNNING",
 	}
 
-	im.ListHosts("us-central1-a", &TestUser{}, req)
+	im.ListHosts("us-central1-a", &TestUser{}, req)
+
+	m, _ := url.ParseQuery(usedQuery)
+	got, expected := m["filter"][0], "labels.cf-created_by:johndoe AND status=RUNNING"
+	if got != expected {
+		t.Errorf("expected <<%q>>, got %q", expected, got)
+	}
+	got, expected = m["maxResults"][0], "100"
+	if got != expected {
+		t.Errorf("expected <<%q>>, got %q", expected, got)
+	}
+}
+
+func TestListHostsLabelSelector(t *testing.T) {
+	var usedQuery string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedQuery = r.URL.Query().Encode()
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+	selector, _ := ParseLabelSelector("team=camera,ci-run!=1234,pool")
+
+	im.ListHosts("us-central1-a", &TestUser{}, &ListHostsRequest{LabelSelector: selector})
+
+	m, _ := url.ParseQuery(usedQuery)
+	got := m["filter"][0]
+	expected := `labels.cf-created_by:johndoe AND labels.team="camera" AND labels.ci-run!="1234" AND labels.pool:*`
+	if got != expected {
+		t.Errorf("expected <<%q>>, got %q", expected, got)
+	}
+}
+
+func TestListHostsAnyStatus(t *testing.T) {
+	var usedQuery string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedQuery = r.URL.Query().Encode()
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	im.ListHosts("us-central1-a", &TestUser{}, &ListHostsRequest{})
 
 	m, _ := url.ParseQuery(usedQuery)
-	got, expected := m["filter"][0], "labels.cf-created_by:johndoe AND status=RUNNING"
+	got, expected := m["filter"][0], "labels.cf-created_by:johndoe"
 	if got != expected {
 		t.Errorf("expected <<%q>>, got %q", expected, got)
 	}
-	got, expected = m["maxResults"][0], "100"
-	if got != expected {
-		t.Errorf("expected <<%q>>, got %q", expected, got)
+}
+
+func TestListHostsInvalidStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request to %q", r.URL.Path)
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.ListHosts("us-central1-a", &TestUser{}, &ListHostsRequest{Status: "RUNNING OR labels.cf-created_by:*"})
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+	}
+	if diff := cmp.Diff(http.StatusBadRequest, appErr.StatusCode); diff != "" {
+		t.Errorf("status code mismatch (-want +got):\n%s", diff)
 	}
 }
 
@@ -473,13 +1133,17 @@ func TestWaitOperationAndOperationIsNotDone(t *testing.T) {
 	zone := "us-central1-a"
 	opName := "operation-1"
 	operation := &compute.Operation{
-		Name:   opName,
-		Status: "PENDING",
+		Name:          opName,
+		OperationType: "insert",
+		TargetLink:    "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a/instances/foo",
+		Status:        "PENDING",
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch path := r.URL.Path; path {
 		case "/projects/google.com:test-project/zones/us-central1-a/operations/operation-1/wait":
 			replyJSON(w, operation)
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: fakeUsername}})
 		default:
 			t.Fatalf("unexpected path: %q", path)
 		}
@@ -510,6 +1174,7 @@ func TestWaitCreateInstanceOperationSucceeds(t *testing.T) {
 		Name:           "foo",
 		MachineType:    "mt",
 		MinCpuPlatform: "mcp",
+		Labels:         map[string]string{labelCreatedBy: fakeUsername},
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch path := r.URL.Path; path {
@@ -542,7 +1207,15 @@ func TestWaitDeleteInstanceOperationSucceeds(t *testing.T) {
 		Status:        "DONE",
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		replyJSON(w, operation)
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/operations/operation-1/wait":
+			replyJSON(w, operation)
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			// The instance no longer exists.
+			replyNotFound(w)
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
 	}))
 	defer ts.Close()
 	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
@@ -577,6 +1250,10 @@ func TestWaitOperationInvalidDoneOperations(t *testing.T) {
 				return
 			}
 		}
+		if strings.HasSuffix(r.URL.Path, "/instances/foo") {
+			replyNotFound(w)
+			return
+		}
 		t.Fatalf("unexpected path: %q", r.URL.Path)
 	}))
 	defer ts.Close()
@@ -599,13 +1276,23 @@ func TestWaitOperationFailedOperation(t *testing.T) {
 	errorStatusCode := http.StatusNotFound
 	operation := &compute.Operation{
 		Name:                opName,
+		OperationType:       "insert",
+		TargetLink:          "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a/instances/foo",
 		Status:              "DONE",
 		Error:               &compute.OperationError{},
 		HttpErrorMessage:    errorMessage,
 		HttpErrorStatusCode: int64(errorStatusCode),
 	}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		replyJSON(w, operation)
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/operations/operation-1/wait":
+			replyJSON(w, operation)
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			// The instance was never created.
+			replyNotFound(w)
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
 	}))
 	defer ts.Close()
 	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
@@ -621,6 +1308,76 @@ func TestWaitOperationFailedOperation(t *testing.T) {
 	}
 }
 
+func TestWaitOperationHostNotOwned(t *testing.T) {
+	zone := "us-central1-a"
+	opName := "operation-1"
+	operation := &compute.Operation{
+		Name:          opName,
+		OperationType: "insert",
+		TargetLink:    "https://xyzzy.com/compute/v1/projects/google.com:test-project/zones/us-central1-a/instances/foo",
+		Status:        "DONE",
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/operations/operation-1/wait":
+			replyJSON(w, operation)
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{
+				Disks:  []*compute.AttachedDisk{{DiskSizeGb: 10}},
+				Name:   "foo",
+				Labels: map[string]string{labelCreatedBy: "janedoe"},
+			})
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	res, err := im.WaitOperation(zone, &TestUser{}, opName)
+
+	if res != nil {
+		t.Errorf("expected <<nil>>, got %+v", res)
+	}
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) {
+		t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+	}
+	if diff := cmp.Diff(http.StatusNotFound, appErr.StatusCode); diff != "" {
+		t.Errorf("status code mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorizeHostAccess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/foo":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: fakeUsername}})
+		case "/projects/google.com:test-project/zones/us-central1-a/instances/bar":
+			replyJSON(w, &compute.Instance{Labels: map[string]string{labelCreatedBy: "janedoe"}})
+		default:
+			replyNotFound(w)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	if err := im.AuthorizeHostAccess("us-central1-a", &TestUser{}, "foo"); err != nil {
+		t.Errorf("expected <<nil>>, got %+v", err)
+	}
+	for _, name := range []string{"bar", "baz"} {
+		err := im.AuthorizeHostAccess("us-central1-a", &TestUser{}, name)
+
+		var appErr *apperr.AppError
+		if !errors.As(err, &appErr) {
+			t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+		}
+		if diff := cmp.Diff(http.StatusNotFound, appErr.StatusCode); diff != "" {
+			t.Errorf("status code mismatch for %q (-want +got):\n%s", name, diff)
+		}
+	}
+}
+
 func TestBuildHostInstance(t *testing.T) {
 	input := &compute.Instance{
 		Disks:          []*compute.AttachedDisk{{DiskSizeGb: 10}},
@@ -647,6 +1404,64 @@ func TestBuildHostInstance(t *testing.T) {
 	}
 }
 
+func TestBuildHostInstanceFull(t *testing.T) {
+	input := &compute.Instance{
+		Disks:             []*compute.AttachedDisk{{DiskSizeGb: 10}},
+		Name:              "foo",
+		MachineType:       "zones/us-central1-a/machineTypes/n1-standard-1",
+		Status:            "STOPPED",
+		CreationTimestamp: "2023-01-01T00:00:00.000-08:00",
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				NetworkIP:     "10.0.0.2",
+				AccessConfigs: []*compute.AccessConfig{{NatIP: "203.0.113.7"}},
+			},
+		},
+		Labels: map[string]string{
+			labelCreatedBy:       fakeUsername,
+			labelAcloudCreatedBy: fakeUsername,
+			"team":               "camera",
+		},
+	}
+
+	got, err := BuildHostInstance(input)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &apiv1.HostInstance{
+		Name:           "foo",
+		BootDiskSizeGB: 10,
+		Status:         apiv1.HostStatusStopped,
+		CreationTime:   "2023-01-01T00:00:00.000-08:00",
+		Owner:          fakeUsername,
+		InternalIP:     "10.0.0.2",
+		ExternalIP:     "203.0.113.7",
+		Labels:         map[string]string{"team": "camera"},
+		GCP:            &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("instance mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestBuildHostInstanceWithExpiration(t *testing.T) {
+	input := &compute.Instance{
+		Disks:  []*compute.AttachedDisk{{DiskSizeGb: 10}},
+		Name:   "foo",
+		Labels: map[string]string{labelExpiresAt: "1672560000"},
+	}
+
+	got, err := BuildHostInstance(input)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("2023-01-01T08:00:00Z", got.ExpirationTime); diff != "" {
+		t.Errorf("expiration time mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestBuildHostInstanceNoDisk(t *testing.T) {
 	input := &compute.Instance{
 		Disks:          []*compute.AttachedDisk{},
@@ -698,6 +1513,12 @@ func replyJSON(w http.ResponseWriter, obj any) error {
 	return encoder.Encode(obj)
 }
 
+func replyNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+}
+
 type testConstantNameGenerator struct {
 	name string
 }
@@ -705,3 +1526,240 @@ type testConstantNameGenerator struct {
 func (g *testConstantNameGenerator) NewName() string {
 	return g.name
 }
+
+func TestGetHostClientIsCachedUntilHostIsDeleted(t *testing.T) {
+	gets := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == instancesPath+"/foo":
+			gets++
+			replyJSON(w, &compute.Instance{
+				Labels:            map[string]string{labelCreatedBy: fakeUsername},
+				NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "10.128.0.63"}},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == instancesPath:
+			replyJSON(w, &compute.InstanceList{Items: []*compute.Instance{{Name: "foo"}}})
+		case r.Method == http.MethodDelete && r.URL.Path == instancesPath+"/foo":
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		default:
+			t.Fatalf("unexpected request: %s %q", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	cfg := testConfig
+	cfg.HostOrchestratorProtocol = "http"
+	cfg.HostClientCacheTTLSeconds = 60
+	im := NewGCEInstanceManager(cfg, buildTestService(t, ts), testNameGenerator)
+
+	first, err := im.GetHostClient("us-central1-a", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := im.GetHostClient("us-central1-a", "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gets != 1 {
+		t.Errorf("expected the host to be looked up once, got %d", gets)
+	}
+	if first != second {
+		t.Error("expected the same host client to be reused")
+	}
+
+	if _, err := im.DeleteHost("us-central1-a", &TestUser{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := im.GetHostClient("us-central1-a", "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	if gets != 2 {
+		t.Errorf("expected the host to be looked up again after deletion, got %d lookups", gets)
+	}
+}
+
+func TestAuthorizeHostAccessIgnoresCachedHostClients(t *testing.T) {
+	owner := fakeUsername
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, &compute.Instance{
+			Labels:            map[string]string{labelCreatedBy: owner},
+			NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "10.128.0.63"}},
+		})
+	}))
+	defer ts.Close()
+	cfg := testConfig
+	cfg.HostOrchestratorProtocol = "http"
+	cfg.HostClientCacheTTLSeconds = 60
+	im := NewGCEInstanceManager(cfg, buildTestService(t, ts), testNameGenerator)
+	if _, err := im.GetHostClient("us-central1-a", "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.AuthorizeHostAccess("us-central1-a", &TestUser{}, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// E.g. relabeled by another server.
+	owner = "janedoe"
+
+	if err := im.AuthorizeHostAccess("us-central1-a", &TestUser{}, "foo"); err == nil {
+		t.Error("expected access to be denied after the owner changed")
+	}
+}
+
+func TestCreateHostOwnedByGroup(t *testing.T) {
+	var bodySent compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &bodySent)
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+			Group: "camera",
+		},
+		cameraMember(&TestUser{}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{labelCreatedBy: fakeUsername, labelGroup: "camera"}
+	if diff := cmp.Diff(expected, bodySent.Labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateHostNotGroupMember(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("unexpected request: %s %q", r.Method, r.URL.Path)
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-1"},
+			},
+			Group: "audio",
+		},
+		cameraMember(&TestUser{}))
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusForbidden {
+		t.Errorf("expected forbidden error, got: %v", err)
+	}
+}
+
+func TestListHostsIncludesGroupHosts(t *testing.T) {
+	var usedFilter string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedFilter = r.URL.Query().Get("filter")
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.ListHosts("us-central1-a", cameraMember(&TestUser{}), &ListHostsRequest{Status: "RUNNING"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "((labels.cf-created_by:johndoe) OR (labels.cf-group:camera)) AND status=RUNNING"
+	if diff := cmp.Diff(expected, usedFilter); diff != "" {
+		t.Errorf("filter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorizeHostAccessGroupMember(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, &compute.Instance{
+			Labels: map[string]string{labelCreatedBy: "janedoe", labelGroup: "camera"},
+		})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	if err := im.AuthorizeHostAccess("us-central1-a", cameraMember(&TestUser{}), "foo"); err != nil {
+		t.Errorf("expected group member to have access, got: %v", err)
+	}
+	err := im.AuthorizeHostAccess("us-central1-a", &TestUser{}, "foo")
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+}
+
+func TestListHostsAllUsers(t *testing.T) {
+	var usedFilter string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedFilter = r.URL.Query().Get("filter")
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.ListHosts("us-central1-a", admin(&TestUser{}), &ListHostsRequest{Status: "RUNNING", AllUsers: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("labels.cf-created_by:* AND status=RUNNING", usedFilter); diff != "" {
+		t.Errorf("filter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeleteHostAdminDeletesAnyHost(t *testing.T) {
+	var usedFilter string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedFilter = r.URL.Query().Get("filter")
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	im.DeleteHost("us-central1-a", accounts.WithAdminRights(admin(&TestUser{})), "foo")
+
+	if diff := cmp.Diff("name=foo AND labels.cf-created_by:*", usedFilter); diff != "" {
+		t.Errorf("filter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestDeleteHostAdminWithoutAdminRightsDeletesOwnHostsOnly(t *testing.T) {
+	var usedFilter string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedFilter = r.URL.Query().Get("filter")
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	im.DeleteHost("us-central1-a", admin(&TestUser{}), "foo")
+
+	if diff := cmp.Diff("name=foo AND labels.cf-created_by:johndoe", usedFilter); diff != "" {
+		t.Errorf("filter mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthorizeHostAccessAdminOtherUsersHosts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replyJSON(w, &compute.Instance{
+			Labels: map[string]string{labelCreatedBy: "janedoe"},
+		})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(testConfig, buildTestService(t, ts), testNameGenerator)
+
+	err := im.AuthorizeHostAccess("us-central1-a", admin(&TestUser{}), "foo")
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/metrics"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+var (
+	gceCallDuration = metrics.DefaultRegistry.NewHistogramVec("cloud_orchestrator_gce_call_duration_seconds",
+		"Latency of the Compute Engine API calls, by method.", metrics.DefaultBuckets, "method")
+	gceCallErrors = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_gce_call_errors_total",
+		"Failed Compute Engine API calls, by method and status code, 0 if no response was received.",
+		"method", "code")
+)
+
+// Creates a Compute Engine service authenticated with the default credentials whose calls are reported in the
+// GCE call metrics.
+func NewInstrumentedComputeService(ctx context.Context) (*compute.Service, error) {
+	trans, err := htransport.NewTransport(ctx, NewGCEMetricsTransport(http.DefaultTransport),
+		option.WithScopes(compute.CloudPlatformScope))
+	if err != nil {
+		return nil, err
+	}
+	return compute.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: trans}))
+}
+
+// Returns a transport recording the latency and errors of the Compute Engine API calls made through it.
+func NewGCEMetricsTransport(base http.RoundTripper) http.RoundTripper {
+	return &gceMetricsTransport{base}
+}
+
+type gceMetricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *gceMetricsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	method := gceMethodName(r)
+	start := time.Now()
+	res, err := t.base.RoundTrip(r)
+	gceCallDuration.ObserveSince(start, method)
+	if err != nil {
+		gceCallErrors.Inc(method, "0")
+	} else if res.StatusCode >= http.StatusBadRequest {
+		gceCallErrors.Inc(method, strconv.Itoa(res.StatusCode))
+	}
+	return res, err
+}
+
+var gceCollections = map[string]string{
+	"instances":  "instances",
+	"operations": "zoneOperations",
+	"zones":      "zones",
+}
+
+// Name of the API method called by the request, e.g. "instances.insert" or "zoneOperations.wait".
+func gceMethodName(r *http.Request) string {
+	segs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	n := len(segs)
+	_, isCollection := gceCollections[segs[n-1]]
+	// Custom methods: {collection}/{name}/{method}
+	if n >= 3 && r.Method == http.MethodPost && !isCollection {
+		if c, ok := gceCollections[segs[n-3]]; ok {
+			return c + "." + segs[n-1]
+		}
+	}
+	// Resources: {collection}/{name}
+	if n >= 2 {
+		if c, ok := gceCollections[segs[n-2]]; ok {
+			return c + "." + strings.ToLower(r.Method)
+		}
+	}
+	// Collections: {collection}
+	if c, ok := gceCollections[segs[n-1]]; ok {
+		switch r.Method {
+		case http.MethodGet:
+			return c + ".list"
+		case http.MethodPost:
+			return c + ".insert"
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGCEMethodName(t *testing.T) {
+	const zonePath = "/compute/v1/projects/test-project/zones/us-central1-a"
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{http.MethodGet, "/compute/v1/projects/test-project/zones", "zones.list"},
+		{http.MethodGet, zonePath + "/instances", "instances.list"},
+		{http.MethodPost, zonePath + "/instances", "instances.insert"},
+		{http.MethodGet, zonePath + "/instances/foo", "instances.get"},
+		{http.MethodDelete, zonePath + "/instances/foo", "instances.delete"},
+		{http.MethodPost, zonePath + "/instances/foo/setLabels", "instances.setLabels"},
+		{http.MethodPost, zonePath + "/operations/operation-1/wait", "zoneOperations.wait"},
+		{http.MethodGet, "/compute/v1/projects/test-project", "other"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			r, _ := http.NewRequest(tc.method, "https://compute.googleapis.com"+tc.path, nil)
+
+			if got := gceMethodName(r); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+// Path requested to the host orchestrators to check they are up, any response other than a server error
+// means the host orchestrator is ready.
+const healthCheckPath = "/"
+
+const (
+	// How long a probe result is used before probing the host again.
+	defaultReadinessTTL = 30 * time.Second
+	// Results of hosts not listed for this long are forgotten.
+	readinessRetention = time.Hour
+)
+
+// Implemented by host clients able to abandon a request after a timeout, so probes of unresponsive hosts don't
+// pile up.
+type healthProber interface {
+	Probe(path string, timeout time.Duration) (int, error)
+}
+
+type hostReadiness struct {
+	ready     bool
+	checkedAt time.Time
+	probing   bool
+}
+
+// Probes the host orchestrators of the hosts through HostClient to find out whether the hosts are ready to be
+// used. Listed hosts are probed in the background and their readiness cached, listing hosts never waits for a
+// probe.
+type HealthChecker struct {
+	manager Manager
+	// Maximum time a single probe may take.
+	ProbeTimeout time.Duration
+	// Time between probes while waiting for a host to become ready.
+	PollInterval time.Duration
+	// How long a probe result is used before probing the host again.
+	ReadinessTTL time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostReadiness
+	// Background probes in progress.
+	probes sync.WaitGroup
+}
+
+func NewHealthChecker(manager Manager) *HealthChecker {
+	return &HealthChecker{
+		manager:      manager,
+		ProbeTimeout: 5 * time.Second,
+		PollInterval: 2 * time.Second,
+		ReadinessTTL: defaultReadinessTTL,
+		hosts:        make(map[string]*hostReadiness),
+	}
+}
+
+// Probes the host orchestrator of the given host and returns whether it answers requests.
+func (c *HealthChecker) IsReady(zone, host string) bool {
+	ready := c.probe(zone, host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.entry(zone, host)
+	r.ready, r.checkedAt = ready, time.Now()
+	return ready
+}
+
+func (c *HealthChecker) probe(zone, host string) bool {
+	hc, err := c.manager.GetHostClient(zone, host)
+	if err != nil {
+		return false
+	}
+	if p, ok := hc.(healthProber); ok {
+		status, err := p.Probe(healthCheckPath, c.ProbeTimeout)
+		return err == nil && status < http.StatusInternalServerError
+	}
+	// Host clients that can't abandon requests are left running in the background, there is at most one
+	// background probe per host at any time.
+	ch := make(chan bool, 1)
+	go func() {
+		status, err := hc.Get(healthCheckPath, "", nil)
+		ch <- err == nil && status < http.StatusInternalServerError
+	}()
+	select {
+	case ready := <-ch:
+		return ready
+	case <-time.After(c.ProbeTimeout):
+		return false
+	}
+}
+
+// Must be called with the lock held.
+func (c *HealthChecker) entry(zone, host string) *hostReadiness {
+	key := zone + "/" + host
+	r, ok := c.hosts[key]
+	if !ok {
+		r = &hostReadiness{}
+		c.hosts[key] = r
+	}
+	return r
+}
+
+// Sets the Ready field of the given hosts from the cached probe results without waiting for any probe. Running
+// hosts never probed or with outdated results are probed in the background, they aren't ready until then.
+func (c *HealthChecker) SetReadiness(zone string, hosts []*apiv1.HostInstance) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgetOldHosts()
+	for _, h := range hosts {
+		if h.Status != apiv1.HostStatusRunning {
+			h.Ready = false
+			continue
+		}
+		r := c.entry(zone, h.Name)
+		h.Ready = r.ready
+		if r.probing || time.Since(r.checkedAt) < c.ReadinessTTL {
+			continue
+		}
+		r.probing = true
+		c.probes.Add(1)
+		go func(name string) {
+			defer c.probes.Done()
+			ready := c.probe(zone, name)
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			r := c.entry(zone, name)
+			r.ready, r.checkedAt, r.probing = ready, time.Now(), false
+		}(h.Name)
+	}
+}
+
+// Must be called with the lock held.
+func (c *HealthChecker) forgetOldHosts() {
+	for key, r := range c.hosts {
+		if !r.probing && time.Since(r.checkedAt) > readinessRetention {
+			delete(c.hosts, key)
+		}
+	}
+}
+
+// Blocks until the host is ready or the timeout expires, returns whether the host became ready.
+func (c *HealthChecker) WaitUntilReady(zone, host string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if c.IsReady(zone, host) {
+			return true
+		}
+		if time.Now().Add(c.PollInterval).After(deadline) {
+			return false
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type probeHostClient struct {
+	status int
+	err    error
+	delay  time.Duration
+}
+
+func (c *probeHostClient) Get(URLPath, URLQuery string, res *HostResponse) (int, error) {
+	time.Sleep(c.delay)
+	return c.status, c.err
+}
+
+func (c *probeHostClient) Post(URLPath, URLQuery string, bodyJSON any, res *HostResponse) (int, error) {
+	return -1, fmt.Errorf("not implemented")
+}
+
+func (c *probeHostClient) GetReverseProxy() *httputil.ReverseProxy {
+	return nil
+}
+
+// Only implements GetHostClient, the single method used by the health checker.
+type probeInstanceManager struct {
+	Manager
+	clients map[string]HostClient
+}
+
+func (m *probeInstanceManager) GetHostClient(zone string, host string) (HostClient, error) {
+	if c, ok := m.clients[host]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("host %q not found", host)
+}
+
+func newTestHealthChecker() *HealthChecker {
+	c := NewHealthChecker(&probeInstanceManager{
+		clients: map[string]HostClient{
+			"ready":       &probeHostClient{status: http.StatusNotFound},
+			"failing":     &probeHostClient{status: http.StatusBadGateway},
+			"unreachable": &probeHostClient{status: -1, err: fmt.Errorf("connection refused")},
+			"hanging":     &probeHostClient{status: http.StatusOK, delay: time.Second},
+		},
+	})
+	c.ProbeTimeout = 10 * time.Millisecond
+	c.PollInterval = 10 * time.Millisecond
+	return c
+}
+
+func TestHealthCheckerIsReady(t *testing.T) {
+	c := newTestHealthChecker()
+	tests := map[string]bool{
+		"ready":       true,
+		"failing":     false,
+		"unreachable": false,
+		"hanging":     false,
+		"unknown":     false,
+	}
+	for host, want := range tests {
+		t.Run(host, func(t *testing.T) {
+			if got := c.IsReady("us-central1-a", host); got != want {
+				t.Errorf("expected %t, got %t", want, got)
+			}
+		})
+	}
+}
+
+func TestHealthCheckerSetReadiness(t *testing.T) {
+	c := newTestHealthChecker()
+	hosts := []*apiv1.HostInstance{
+		{Name: "ready", Status: apiv1.HostStatusRunning},
+		{Name: "unreachable", Status: apiv1.HostStatusRunning},
+		// Not probed.
+		{Name: "ready", Status: apiv1.HostStatusStopped},
+	}
+
+	c.SetReadiness("us-central1-a", hosts)
+
+	// Hosts aren't ready until probed.
+	want := []*apiv1.HostInstance{
+		{Name: "ready", Status: apiv1.HostStatusRunning},
+		{Name: "unreachable", Status: apiv1.HostStatusRunning},
+		{Name: "ready", Status: apiv1.HostStatusStopped},
+	}
+	if diff := cmp.Diff(want, hosts); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+
+	c.probes.Wait()
+	c.SetReadiness("us-central1-a", hosts)
+
+	want = []*apiv1.HostInstance{
+		{Name: "ready", Status: apiv1.HostStatusRunning, Ready: true},
+		{Name: "unreachable", Status: apiv1.HostStatusRunning},
+		{Name: "ready", Status: apiv1.HostStatusStopped},
+	}
+	if diff := cmp.Diff(want, hosts); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHealthCheckerSetReadinessDoesNotWaitForProbes(t *testing.T) {
+	c := newTestHealthChecker()
+	c.ProbeTimeout = time.Minute
+	hosts := []*apiv1.HostInstance{{Name: "hanging", Status: apiv1.HostStatusRunning}}
+
+	start := time.Now()
+	c.SetReadiness("us-central1-a", hosts)
+	c.SetReadiness("us-central1-a", hosts)
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("listing hosts waited %v for probes", elapsed)
+	}
+	if hosts[0].Ready {
+		t.Error("expected host not to be ready before being probed")
+	}
+	c.probes.Wait()
+	c.SetReadiness("us-central1-a", hosts)
+	if !hosts[0].Ready {
+		t.Error("expected host to be ready after being probed")
+	}
+}
+
+func TestNetHostClientProbeTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	url, _ := url.Parse(ts.URL)
+	hc := NewNetHostClient(url, false)
+
+	start := time.Now()
+	if _, err := hc.Probe(healthCheckPath, 10*time.Millisecond); err == nil {
+		t.Error("expected probe to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("probe took %v", elapsed)
+	}
+}
+
+func TestHealthCheckerWaitUntilReadyTimesOut(t *testing.T) {
+	c := newTestHealthChecker()
+
+	if c.WaitUntilReady("us-central1-a", "unreachable", 50*time.Millisecond) {
+		t.Error("expected host not to become ready")
+	}
+	if !c.WaitUntilReady("us-central1-a", "ready", 50*time.Millisecond) {
+		t.Error("expected host to be ready")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"sync"
+	"time"
+)
+
+type hostCacheEntry struct {
+	client    HostClient
+	expiresAt time.Time
+}
+
+// Caches the clients of the hosts, and so their addresses, for a limited time. Only addresses are cached,
+// ownership changes at any time and is always read from the host. A nil cache caches nothing.
+type hostCache struct {
+	ttl time.Duration
+	// Replaceable in tests.
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]hostCacheEntry
+}
+
+func newHostCache(ttl time.Duration) *hostCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &hostCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]hostCacheEntry),
+	}
+}
+
+func hostCacheKey(zone, host string) string {
+	return zone + "/" + host
+}
+
+func (c *hostCache) get(zone, host string) (HostClient, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := hostCacheKey(zone, host)
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.client, true
+}
+
+func (c *hostCache) put(zone, host string, client HostClient) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	// Expired entries of hosts that are no longer used would otherwise stay forever.
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[hostCacheKey(zone, host)] = hostCacheEntry{client: client, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *hostCache) invalidate(zone, host string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, hostCacheKey(zone, host))
+}
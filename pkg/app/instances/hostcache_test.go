@@ -0,0 +1,62 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostCacheExpires(t *testing.T) {
+	now := time.Now()
+	c := newHostCache(time.Minute)
+	c.now = func() time.Time { return now }
+	h := &NetHostClient{}
+
+	c.put("us-central1-a", "foo", h)
+
+	if got, ok := c.get("us-central1-a", "foo"); !ok || got != h {
+		t.Errorf("expected cached host, got %v, %t", got, ok)
+	}
+	if _, ok := c.get("us-central1-b", "foo"); ok {
+		t.Error("expected no cached host in other zone")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.get("us-central1-a", "foo"); ok {
+		t.Error("expected cached host to expire")
+	}
+}
+
+func TestHostCacheInvalidate(t *testing.T) {
+	c := newHostCache(time.Minute)
+	c.put("us-central1-a", "foo", &NetHostClient{})
+
+	c.invalidate("us-central1-a", "foo")
+
+	if _, ok := c.get("us-central1-a", "foo"); ok {
+		t.Error("expected no cached host")
+	}
+}
+
+func TestHostCacheDisabled(t *testing.T) {
+	c := newHostCache(0)
+
+	c.put("us-central1-a", "foo", &NetHostClient{})
+	c.invalidate("us-central1-a", "bar")
+
+	if _, ok := c.get("us-central1-a", "foo"); ok {
+		t.Error("expected nothing cached")
+	}
+}
@@ -16,12 +16,14 @@ package instances
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 )
@@ -74,6 +76,24 @@ func (c *NetHostClient) Get(path, query string, out *HostResponse) (int, error)
 	return res.StatusCode, err
 }
 
+// Like Get, but the request is abandoned after the timeout and the response body is discarded.
+func (c *NetHostClient) Probe(path string, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	url := *c.url // Shallow copy
+	url.Path = path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url.String(), nil)
+	if err != nil {
+		return -1, err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to connect to device host: %w", err)
+	}
+	res.Body.Close()
+	return res.StatusCode, nil
+}
+
 func (c *NetHostClient) Post(path, query string, bodyJSON any, out *HostResponse) (int, error) {
 	bodyStr, err := json.Marshal(bodyJSON)
 	if err != nil {
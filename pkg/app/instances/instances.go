@@ -30,10 +30,26 @@ type Manager interface {
 	ListHosts(zone string, user accounts.User, req *ListHostsRequest) (*apiv1.ListHostsResponse, error)
 	// Deletes the given host instance.
 	DeleteHost(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+	// Sets a new expiration time for the given host instance. The result of the operation is the updated host.
+	ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error)
+	// Deletes the host instances of every user whose time to live has expired.
+	DeleteExpiredHosts() error
+	// Stops the given host instance. A stopped host keeps its disk but can't be used until started again.
+	StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+	// Starts the given stopped host instance.
+	StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+	// Suspends the given host instance, preserving its memory state.
+	SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error)
+	// Resumes the given suspended host instance.
+	ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error)
 	// Waits until operation is DONE or earlier. If DONE return the expected  response of the operation. If the
 	// original method returns no data on success, such as `Delete`, response will be empty. If the original method
 	// is standard `Get`/`Create`/`Update`, the response should be the relevant resource.
+	// Operations on hosts not owned by the user must be reported as not found.
 	WaitOperation(zone string, user accounts.User, name string) (any, error)
+	// Returns nil if the user is allowed to access the given host, an error otherwise. Hosts not owned by the
+	// user are reported as not found to avoid leaking their existence.
+	AuthorizeHostAccess(zone string, user accounts.User, name string) error
 	// Creates a connector to the given host.
 	GetHostClient(zone string, host string) (HostClient, error)
 }
@@ -59,6 +75,13 @@ type ListHostsRequest struct {
 	// Specifies a page token to use.
 	// Use the `NextPageToken` value returned by a previous List request.
 	PageToken string
+	// Only hosts with this status are listed, hosts with any status are listed if empty.
+	Status string
+	// Only hosts whose labels match the selector are listed.
+	LabelSelector LabelSelector
+	// The hosts of every user are listed instead of only those owned by the user. Callers must make sure the
+	// user is allowed to see them.
+	AllUsers bool
 }
 
 type IMType string
@@ -68,6 +91,14 @@ type Config struct {
 	// The protocol the host orchestrator expects, either http or https
 	HostOrchestratorProtocol          string
 	AllowSelfSignedHostSSLCertificate bool
-	GCP                               *GCPIMConfig
-	UNIX                              *UNIXIMConfig
+	// Interval in minutes between checks for expired hosts. Expired hosts are not deleted if zero.
+	HostReaperIntervalMinutes int
+	// Time in seconds host addresses are reused for when connecting to hosts, host ownership is never cached.
+	// Nothing is cached if zero.
+	HostClientCacheTTLSeconds int
+	Quota                     QuotaConfig
+	GCP                       *GCPIMConfig
+	UNIX                      *UNIXIMConfig
+	Process                   *ProcessIMConfig
+	Plugin                    *PluginIMConfig
 }
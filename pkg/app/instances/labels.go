@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/errors"
+)
+
+// Maximum number of labels a host can have, including the ones set by the orchestrator.
+// Read more: https://cloud.google.com/compute/docs/labeling-resources#requirements
+const maxLabels = 64
+
+var (
+	labelKeyRe   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValueRe = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+)
+
+// Reserved labels are set by the orchestrator itself, any other label is defined by the user.
+func isReservedLabel(key string) bool {
+	return strings.HasPrefix(key, labelPrefix) || key == labelAcloudCreatedBy
+}
+
+func validateLabelKey(key string) error {
+	if !labelKeyRe.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: must start with a lowercase letter and contain only "+
+			"lowercase letters, digits, underscores and dashes, up to 63 characters", key)
+	}
+	if isReservedLabel(key) {
+		return fmt.Errorf("invalid label key %q: reserved, keys can't start with %q", key, labelPrefix)
+	}
+	return nil
+}
+
+func validateLabelValue(value string) error {
+	if !labelValueRe.MatchString(value) {
+		return fmt.Errorf("invalid label value %q: must contain only lowercase letters, digits, "+
+			"underscores and dashes, up to 63 characters", value)
+	}
+	return nil
+}
+
+// Validates user defined labels.
+func validateLabels(labels map[string]string) error {
+	for k, v := range labels {
+		if err := validateLabelKey(k); err != nil {
+			return errors.NewBadRequestError(err.Error(), nil)
+		}
+		if err := validateLabelValue(v); err != nil {
+			return errors.NewBadRequestError(err.Error(), nil)
+		}
+	}
+	return nil
+}
+
+// Validates the group requested to own a new host, the user creating the host must be a member. Groups are
+// stored as label values.
+func validateGroup(group string, user accounts.User) error {
+	if group == "" {
+		return nil
+	}
+	if !labelValueRe.MatchString(group) {
+		return errors.NewBadRequestError(fmt.Sprintf("invalid group %q: must contain only lowercase letters, "+
+			"digits, underscores and dashes, up to 63 characters", group), nil)
+	}
+	if !accounts.IsGroupMember(user, group) {
+		return errors.NewForbiddenError(fmt.Sprintf("User is not a member of group %q", group), nil)
+	}
+	return nil
+}
+
+// Hosts are owned by their creator and the group they were created for, if any.
+func ownsHost(owner, group string, user accounts.User) bool {
+	return owner == user.Username() || accounts.IsGroupMember(user, group)
+}
+
+// Hosts can be accessed by their owners and by admins acting with their admin rights.
+func canAccessHost(owner, group string, user accounts.User) bool {
+	return ownsHost(owner, group, user) || accounts.HasAdminRights(user)
+}
+
+type LabelOperator string
+
+const (
+	LabelOpEquals    LabelOperator = "="
+	LabelOpNotEquals LabelOperator = "!="
+	LabelOpExists    LabelOperator = ""
+)
+
+type LabelRequirement struct {
+	Key string
+	Op  LabelOperator
+	// Ignored when the operator is LabelOpExists.
+	Value string
+}
+
+// Hosts match a label selector when they satisfy all of its requirements.
+type LabelSelector []LabelRequirement
+
+// Parses a comma separated list of requirements, each of the form `key=value`, `key!=value` or just `key`
+// to require the label to exist. E.g: `team=camera,ci-run`.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var result LabelSelector
+	for _, term := range strings.Split(s, ",") {
+		var r LabelRequirement
+		if k, v, found := strings.Cut(term, "!="); found {
+			r = LabelRequirement{Key: k, Op: LabelOpNotEquals, Value: v}
+		} else if k, v, found := strings.Cut(term, "="); found {
+			r = LabelRequirement{Key: k, Op: LabelOpEquals, Value: v}
+		} else {
+			r = LabelRequirement{Key: term, Op: LabelOpExists}
+		}
+		if err := validateLabelKey(r.Key); err != nil {
+			return nil, errors.NewBadRequestError("Invalid label selector: "+err.Error(), nil)
+		}
+		if err := validateLabelValue(r.Value); err != nil {
+			return nil, errors.NewBadRequestError("Invalid label selector: "+err.Error(), nil)
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.Key]
+		switch r.Op {
+		case LabelOpExists:
+			if !ok {
+				return false
+			}
+		case LabelOpEquals:
+			if !ok || v != r.Value {
+				return false
+			}
+		case LabelOpNotEquals:
+			if ok && v == r.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
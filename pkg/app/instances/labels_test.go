@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"errors"
+	"testing"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	got, err := ParseLabelSelector("team=camera,ci-run!=1234,pool,empty=")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LabelSelector{
+		{Key: "team", Op: LabelOpEquals, Value: "camera"},
+		{Key: "ci-run", Op: LabelOpNotEquals, Value: "1234"},
+		{Key: "pool", Op: LabelOpExists},
+		{Key: "empty", Op: LabelOpEquals, Value: ""},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("label selector mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLabelSelectorInvalid(t *testing.T) {
+	tests := []string{
+		"Team=camera",
+		"team=Camera",
+		"1team=camera",
+		"team=camera OR labels.cf-created_by:*",
+		"cf-created_by=johndoe",
+		"team=camera,",
+	}
+
+	for _, test := range tests {
+		_, err := ParseLabelSelector(test)
+
+		var appErr *apperr.AppError
+		if !errors.As(err, &appErr) {
+			t.Errorf("selector %q: unexpected error <<\"%v\">>, want \"%T\"", test, err, appErr)
+		}
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"team": "camera", "ci-run": "1234"}
+	tests := []struct {
+		selector string
+		exp      bool
+	}{
+		{"", true},
+		{"team=camera", true},
+		{"team=audio", false},
+		{"team!=audio", true},
+		{"ci-run!=1234", false},
+		{"pool!=foo", true},
+		{"ci-run", true},
+		{"pool", false},
+		{"team=camera,ci-run=1234", true},
+		{"team=camera,pool", false},
+	}
+
+	for _, test := range tests {
+		s, err := ParseLabelSelector(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := s.Matches(labels); got != test.exp {
+			t.Errorf("selector %q: expected <<%t>>, got %t", test.selector, test.exp, got)
+		}
+	}
+}
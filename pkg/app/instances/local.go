@@ -69,9 +69,19 @@ func (m *LocalInstanceManager) CreateHost(_ string, _ *apiv1.CreateHostRequest,
 }
 
 func (m *LocalInstanceManager) ListHosts(zone string, user accounts.User, req *ListHostsRequest) (*apiv1.ListHostsResponse, error) {
+	// The local host is always running and has no labels.
+	if req.Status != "" && req.Status != apiv1.HostStatusRunning {
+		return &apiv1.ListHostsResponse{}, nil
+	}
+	if !req.LabelSelector.Matches(nil) {
+		return &apiv1.ListHostsResponse{}, nil
+	}
 	return &apiv1.ListHostsResponse{
 		Items: []*apiv1.HostInstance{{
-			Name: "local",
+			Name:       "local",
+			Status:     apiv1.HostStatusRunning,
+			Owner:      user.Username(),
+			InternalIP: "127.0.0.1",
 		}},
 	}, nil
 }
@@ -80,10 +90,40 @@ func (m *LocalInstanceManager) DeleteHost(zone string, user accounts.User, name
 	return nil, fmt.Errorf("%T#DeleteHost is not implemented", *m)
 }
 
+func (m *LocalInstanceManager) ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error) {
+	return nil, fmt.Errorf("%T#ExtendHost is not implemented", *m)
+}
+
+func (m *LocalInstanceManager) DeleteExpiredHosts() error {
+	// The local host never expires.
+	return nil
+}
+
+func (m *LocalInstanceManager) StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, fmt.Errorf("%T#StopHost is not implemented", *m)
+}
+
+func (m *LocalInstanceManager) StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, fmt.Errorf("%T#StartHost is not implemented", *m)
+}
+
+func (m *LocalInstanceManager) SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, fmt.Errorf("%T#SuspendHost is not implemented", *m)
+}
+
+func (m *LocalInstanceManager) ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, fmt.Errorf("%T#ResumeHost is not implemented", *m)
+}
+
 func (m *LocalInstanceManager) WaitOperation(zone string, user accounts.User, name string) (any, error) {
 	return nil, fmt.Errorf("%T#WaitOperation is not implemented", *m)
 }
 
+func (m *LocalInstanceManager) AuthorizeHostAccess(zone string, user accounts.User, name string) error {
+	// Every request is made on behalf of the same local user.
+	return nil
+}
+
 func (m *LocalInstanceManager) GetHostClient(zone string, host string) (HostClient, error) {
 	url, err := m.GetHostURL(zone, host)
 	if err != nil {
@@ -0,0 +1,296 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/errors"
+)
+
+const PluginIMType IMType = "plugin"
+
+type PluginIMConfig struct {
+	// Base URL of the plugin, e.g. "http://localhost:9090". See api/v1/instancemanagerplugin.go for the
+	// protocol the plugin must implement.
+	Endpoint string
+	// Timeout of the requests to the plugin in seconds, WaitOperation requests included.
+	TimeoutSeconds int
+}
+
+const defaultPluginTimeout = 3 * time.Minute
+
+// Implements the Manager interface forwarding every call to an external plugin, allowing to back the
+// orchestrator with any infrastructure without modifying it.
+type PluginInstanceManager struct {
+	config   Config
+	endpoint string
+	client   *http.Client
+}
+
+func NewPluginInstanceManager(cfg Config) (*PluginInstanceManager, error) {
+	if cfg.Plugin == nil || cfg.Plugin.Endpoint == "" {
+		return nil, fmt.Errorf("plugin instance manager: no endpoint configured")
+	}
+	if _, err := url.Parse(cfg.Plugin.Endpoint); err != nil {
+		return nil, fmt.Errorf("plugin instance manager: invalid endpoint: %w", err)
+	}
+	if err := cfg.Quota.checkNoVCPUsLimit(); err != nil {
+		return nil, fmt.Errorf("plugin instance manager: %w", err)
+	}
+	timeout := defaultPluginTimeout
+	if cfg.Plugin.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.Plugin.TimeoutSeconds) * time.Second
+	}
+	return &PluginInstanceManager{
+		config:   cfg,
+		endpoint: strings.TrimSuffix(cfg.Plugin.Endpoint, "/"),
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (m *PluginInstanceManager) ListZones() (*apiv1.ListZonesResponse, error) {
+	res := &apiv1.ListZonesResponse{}
+	if err := m.call(apiv1.PluginMethodListZones, &apiv1.PluginListZonesRequest{}, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m *PluginInstanceManager) CreateHost(zone string, req *apiv1.CreateHostRequest, user accounts.User) (*apiv1.Operation, error) {
+	if err := validateGroup(req.Group, user); err != nil {
+		return nil, err
+	}
+	if err := m.checkQuota(zone, user); err != nil {
+		return nil, err
+	}
+	pluginReq := &apiv1.PluginCreateHostRequest{
+		Zone:    zone,
+		User:    user.Username(),
+		Request: req,
+	}
+	return m.callOperation(apiv1.PluginMethodCreateHost, pluginReq)
+}
+
+// Checks the quota allows the user to create a new host, counting the hosts listed by the plugin. This is best
+// effort, hosts created concurrently may exceed the limits.
+func (m *PluginInstanceManager) checkQuota(zone string, user accounts.User) error {
+	quota := &m.config.Quota
+	if !quota.userLimited() && !quota.zoneLimited() {
+		return nil
+	}
+	zones := []string{zone}
+	if quota.userLimited() {
+		res, err := m.ListZones()
+		if err != nil {
+			return err
+		}
+		for _, z := range res.Items {
+			if z.Name != zone {
+				zones = append(zones, z.Name)
+			}
+		}
+	}
+	usage := quotaUsage{}
+	for _, z := range zones {
+		err := m.forEachHost(z, func(h *apiv1.HostInstance) {
+			if h.Owner == user.Username() {
+				usage.UserHosts++
+			}
+			if z == zone {
+				usage.ZoneHosts++
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return quota.check(usage, 0)
+}
+
+// Calls f with every host in the zone, regardless of its owner.
+func (m *PluginInstanceManager) forEachHost(zone string, f func(*apiv1.HostInstance)) error {
+	req := &apiv1.PluginListHostsRequest{Zone: zone, AllUsers: true}
+	for {
+		res := &apiv1.ListHostsResponse{}
+		if err := m.call(apiv1.PluginMethodListHosts, req, res); err != nil {
+			return err
+		}
+		for _, h := range res.Items {
+			f(h)
+		}
+		if res.NextPageToken == "" {
+			return nil
+		}
+		req.PageToken = res.NextPageToken
+	}
+}
+
+func (m *PluginInstanceManager) ListHosts(zone string, user accounts.User, req *ListHostsRequest) (*apiv1.ListHostsResponse, error) {
+	pluginReq := &apiv1.PluginListHostsRequest{
+		Zone:       zone,
+		User:       user.Username(),
+		Groups:     accounts.UserGroups(user),
+		MaxResults: req.MaxResults,
+		PageToken:  req.PageToken,
+		Status:     req.Status,
+		AllUsers:   req.AllUsers,
+	}
+	for _, r := range req.LabelSelector {
+		pluginReq.LabelSelector = append(pluginReq.LabelSelector, apiv1.PluginLabelRequirement{
+			Key:      r.Key,
+			Operator: string(r.Op),
+			Value:    r.Value,
+		})
+	}
+	res := &apiv1.ListHostsResponse{}
+	if err := m.call(apiv1.PluginMethodListHosts, pluginReq, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (m *PluginInstanceManager) DeleteHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.callOperation(apiv1.PluginMethodDeleteHost, buildPluginHostRequest(zone, user, name))
+}
+
+func (m *PluginInstanceManager) ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error) {
+	pluginReq := &apiv1.PluginExtendHostRequest{
+		Zone:    zone,
+		User:    user.Username(),
+		Groups:  accounts.UserGroups(user),
+		Admin:   accounts.HasAdminRights(user),
+		Host:    name,
+		Request: req,
+	}
+	return m.callOperation(apiv1.PluginMethodExtendHost, pluginReq)
+}
+
+func (m *PluginInstanceManager) DeleteExpiredHosts() error {
+	return m.call(apiv1.PluginMethodDeleteExpiredHosts, &apiv1.PluginDeleteExpiredHostsRequest{}, nil)
+}
+
+func (m *PluginInstanceManager) StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.callOperation(apiv1.PluginMethodStopHost, buildPluginHostRequest(zone, user, name))
+}
+
+func (m *PluginInstanceManager) StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.callOperation(apiv1.PluginMethodStartHost, buildPluginHostRequest(zone, user, name))
+}
+
+func (m *PluginInstanceManager) SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.callOperation(apiv1.PluginMethodSuspendHost, buildPluginHostRequest(zone, user, name))
+}
+
+func (m *PluginInstanceManager) ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return m.callOperation(apiv1.PluginMethodResumeHost, buildPluginHostRequest(zone, user, name))
+}
+
+func (m *PluginInstanceManager) WaitOperation(zone string, user accounts.User, name string) (any, error) {
+	pluginReq := &apiv1.PluginWaitOperationRequest{
+		Zone:      zone,
+		User:      user.Username(),
+		Groups:    accounts.UserGroups(user),
+		Admin:     accounts.HasAdminRights(user),
+		Operation: name,
+	}
+	res := &apiv1.PluginWaitOperationResponse{}
+	if err := m.call(apiv1.PluginMethodWaitOperation, pluginReq, res); err != nil {
+		return nil, err
+	}
+	if len(res.Result) == 0 {
+		// Operations without result, like deletions.
+		return struct{}{}, nil
+	}
+	// Hosts are returned as such, so the orchestrator completes them like the ones of any other instance
+	// manager.
+	host := &apiv1.HostInstance{}
+	if err := json.Unmarshal(res.Result, host); err == nil && host.Name != "" {
+		return host, nil
+	}
+	return res.Result, nil
+}
+
+func (m *PluginInstanceManager) AuthorizeHostAccess(zone string, user accounts.User, name string) error {
+	return m.call(apiv1.PluginMethodAuthorizeHostAccess, buildPluginHostRequest(zone, user, name), nil)
+}
+
+func (m *PluginInstanceManager) GetHostClient(zone string, host string) (HostClient, error) {
+	res := &apiv1.PluginGetHostURLResponse{}
+	if err := m.call(apiv1.PluginMethodGetHostURL, &apiv1.PluginGetHostURLRequest{Zone: zone, Host: host}, res); err != nil {
+		return nil, err
+	}
+	url, err := url.Parse(res.URL)
+	if err != nil {
+		return nil, errors.NewInternalError("Invalid host URL returned by instance manager plugin", err)
+	}
+	return NewNetHostClient(url, m.config.AllowSelfSignedHostSSLCertificate), nil
+}
+
+func buildPluginHostRequest(zone string, user accounts.User, name string) *apiv1.PluginHostRequest {
+	return &apiv1.PluginHostRequest{
+		Zone:   zone,
+		User:   user.Username(),
+		Groups: accounts.UserGroups(user),
+		Admin:  accounts.HasAdminRights(user),
+		Host:   name,
+	}
+}
+
+func (m *PluginInstanceManager) callOperation(method string, req any) (*apiv1.Operation, error) {
+	res := &apiv1.Operation{}
+	if err := m.call(method, req, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Sends the request to the plugin and parses the response into res, if not nil. Errors reported by the plugin
+// are returned with the same status code.
+func (m *PluginInstanceManager) call(method string, req any, res any) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return errors.NewInternalError("Failed encoding instance manager plugin request", err)
+	}
+	httpRes, err := m.client.Post(m.endpoint+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.NewServiceUnavailableError("Instance manager plugin unavailable", err)
+	}
+	defer httpRes.Body.Close()
+	dec := json.NewDecoder(httpRes.Body)
+	if httpRes.StatusCode < 200 || httpRes.StatusCode > 299 {
+		errRes := &apiv1.Error{}
+		if err := dec.Decode(errRes); err != nil || errRes.ErrorMsg == "" {
+			return errors.NewInternalError(
+				fmt.Sprintf("Instance manager plugin %s failed with status code %d", method, httpRes.StatusCode), err)
+		}
+		return &errors.AppError{Msg: errRes.ErrorMsg, StatusCode: httpRes.StatusCode}
+	}
+	if res == nil {
+		return nil
+	}
+	if err := dec.Decode(res); err != nil {
+		return errors.NewInternalError(fmt.Sprintf("Failed decoding instance manager plugin %s response", method), err)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+	"github.com/google/cloud-android-orchestration/pkg/app/instances/pluginstub"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestPluginInstanceManager(t *testing.T) *PluginInstanceManager {
+	return newTestPluginInstanceManagerWithQuota(t, QuotaConfig{})
+}
+
+func newTestPluginInstanceManagerWithQuota(t *testing.T, quota QuotaConfig) *PluginInstanceManager {
+	ts := httptest.NewServer(pluginstub.NewStub("http://127.0.0.1:1081"))
+	t.Cleanup(ts.Close)
+	m, err := NewPluginInstanceManager(Config{Quota: quota, Plugin: &PluginIMConfig{Endpoint: ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func createPluginHost(t *testing.T, m *PluginInstanceManager, req *apiv1.CreateHostRequest) *apiv1.HostInstance {
+	op, err := m.CreateHost(pluginstub.Zone, req, &TestUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.WaitOperation(pluginstub.Zone, &TestUser{}, op.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, ok := res.(*apiv1.HostInstance)
+	if !ok {
+		t.Fatalf("expected a host instance, got: %#v", res)
+	}
+	return host
+}
+
+func TestPluginListZones(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+
+	res, err := m.ListZones()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&apiv1.ListZonesResponse{Items: []*apiv1.Zone{{Name: pluginstub.Zone}}}, res); diff != "" {
+		t.Errorf("zones mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPluginCreateAndListHosts(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	first := createPluginHost(t, m, &apiv1.CreateHostRequest{
+		HostInstance: &apiv1.HostInstance{},
+		Labels:       map[string]string{"team": "camera"},
+	})
+	createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+	selector, _ := ParseLabelSelector("team=camera")
+
+	res, err := m.ListHosts(pluginstub.Zone, &TestUser{}, &ListHostsRequest{LabelSelector: selector})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.HostInstance{first}, res.Items); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	if first.Owner != fakeUsername {
+		t.Errorf("expected owner %q, got %q", fakeUsername, first.Owner)
+	}
+}
+
+func TestPluginDeleteHost(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	host := createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	op, err := m.DeleteHost(pluginstub.Zone, &TestUser{}, host.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.WaitOperation(pluginstub.Zone, &TestUser{}, op.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(struct{}{}, res); diff != "" {
+		t.Errorf("delete result mismatch (-want +got):\n%s", diff)
+	}
+	list, err := m.ListHosts(pluginstub.Zone, &TestUser{}, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 0 {
+		t.Errorf("expected no hosts, got: %+v", list.Items)
+	}
+}
+
+func TestPluginGroupMembersShareHosts(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	op, err := m.CreateHost(pluginstub.Zone,
+		&apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}, Group: "camera"}, cameraMember(&TestUser{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.WaitOperation(pluginstub.Zone, &TestUser{}, op.Name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.WaitOperation(pluginstub.Zone, cameraMember(&otherUser{}), op.Name); err != nil {
+		t.Errorf("expected group member to wait for the group host operation, got: %v", err)
+	}
+
+	res, err := m.ListHosts(pluginstub.Zone, cameraMember(&otherUser{}), &ListHostsRequest{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 1 || res.Items[0].Group != "camera" {
+		t.Fatalf("expected the group host to be listed, got: %+v", res.Items)
+	}
+	if err := m.AuthorizeHostAccess(pluginstub.Zone, cameraMember(&otherUser{}), res.Items[0].Name); err != nil {
+		t.Errorf("expected group member to have access, got: %v", err)
+	}
+}
+
+func TestPluginAdminManagesEveryHost(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	host := createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	res, err := m.ListHosts(pluginstub.Zone, admin(&otherUser{}), &ListHostsRequest{AllUsers: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.HostInstance{host}, res.Items); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	if err := m.AuthorizeHostAccess(pluginstub.Zone, admin(&otherUser{}), host.Name); err == nil {
+		t.Error("expected admin not to have access to other users' hosts")
+	}
+	if _, err := m.DeleteHost(pluginstub.Zone, admin(&otherUser{}), host.Name); err == nil {
+		t.Error("expected admin not to delete other users' hosts without admin rights")
+	}
+	op, err := m.DeleteHost(pluginstub.Zone, accounts.WithAdminRights(admin(&otherUser{})), host.Name)
+	if err != nil {
+		t.Fatalf("expected admin to delete the host with admin rights, got: %v", err)
+	}
+	if _, err := m.WaitOperation(pluginstub.Zone, admin(&otherUser{}), op.Name); err != nil {
+		t.Errorf("expected admin to wait for the deletion, got: %v", err)
+	}
+	if _, err := m.WaitOperation(pluginstub.Zone, &TestUser{}, op.Name); err == nil {
+		t.Error("expected other users not to find the operation")
+	}
+}
+
+func TestPluginCreateHostQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   QuotaConfig
+		user    accounts.User
+		expCode int
+	}{
+		{"within limits", QuotaConfig{MaxHostsPerUser: 2, MaxHostsPerZone: 3}, &TestUser{}, 0},
+		{"hosts per user", QuotaConfig{MaxHostsPerUser: 1}, &TestUser{}, http.StatusForbidden},
+		{"other user", QuotaConfig{MaxHostsPerUser: 1}, &otherUser{}, 0},
+		{"hosts per zone", QuotaConfig{MaxHostsPerZone: 1}, &otherUser{}, http.StatusTooManyRequests},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newTestPluginInstanceManagerWithQuota(t, test.quota)
+			createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+			_, err := m.CreateHost(pluginstub.Zone, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}}, test.user)
+
+			if test.expCode == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) || appErr.StatusCode != test.expCode {
+				t.Fatalf("expected error with status code %d, got: %v", test.expCode, err)
+			}
+		})
+	}
+}
+
+func TestPluginRejectsVCPUsQuota(t *testing.T) {
+	cfg := Config{Quota: QuotaConfig{MaxVCPUsPerUser: 8}, Plugin: &PluginIMConfig{Endpoint: "http://localhost:9090"}}
+
+	if _, err := NewPluginInstanceManager(cfg); err == nil {
+		t.Error("expected an error for a quota the plugin instance manager can't enforce")
+	}
+}
+
+func TestPluginErrorsKeepStatusCode(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	host := createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	err := m.AuthorizeHostAccess(pluginstub.Zone, &otherUser{}, host.Name)
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected not found error, got: %v", err)
+	}
+	if diff := cmp.Diff(`Host instance "stub-host-1" not found.`, appErr.Msg); diff != "" {
+		t.Errorf("error message mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPluginGetHostClient(t *testing.T) {
+	m := newTestPluginInstanceManager(t)
+	host := createPluginHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	hc, err := m.GetHostClient(pluginstub.Zone, host.Name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hc.(*NetHostClient).url.String(); got != "http://127.0.0.1:1081" {
+		t.Errorf("unexpected host URL: %q", got)
+	}
+}
+
+func TestPluginUnavailable(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+	m, err := NewPluginInstanceManager(Config{Plugin: &PluginIMConfig{Endpoint: ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.ListZones()
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected service unavailable error, got: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pluginstub is a reference implementation of the instance manager plugin protocol defined in
+// api/v1/instancemanagerplugin.go. Hosts only exist in memory and every operation is done right away, it's
+// meant for tests and as a starting point for real plugins.
+package pluginstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+)
+
+// The only zone of the stub plugin.
+const Zone = "stub"
+
+type host struct {
+	owner    string
+	group    string
+	instance *apiv1.HostInstance
+	// Zero if the host never expires.
+	expiresAt time.Time
+}
+
+type operation struct {
+	// The user who started the operation.
+	owner string
+	// Group owning the host the operation is on, if any.
+	group  string
+	result any
+}
+
+type Stub struct {
+	// URL returned for every host, e.g. the address of a fake host orchestrator.
+	hostURL string
+	mux     *http.ServeMux
+
+	mu      sync.Mutex
+	hosts   map[string]*host
+	ops     map[string]*operation
+	hostSeq int
+	opSeq   int
+}
+
+func NewStub(hostURL string) *Stub {
+	s := &Stub{
+		hostURL: hostURL,
+		mux:     http.NewServeMux(),
+		hosts:   make(map[string]*host),
+		ops:     make(map[string]*operation),
+	}
+	handle(s, apiv1.PluginMethodListZones, s.listZones)
+	handle(s, apiv1.PluginMethodCreateHost, s.createHost)
+	handle(s, apiv1.PluginMethodListHosts, s.listHosts)
+	handle(s, apiv1.PluginMethodDeleteHost, s.deleteHost)
+	handle(s, apiv1.PluginMethodExtendHost, s.extendHost)
+	handle(s, apiv1.PluginMethodDeleteExpiredHosts, s.deleteExpiredHosts)
+	handle(s, apiv1.PluginMethodStopHost, s.setStatus(apiv1.HostStatusRunning, apiv1.HostStatusStopped))
+	handle(s, apiv1.PluginMethodStartHost, s.setStatus(apiv1.HostStatusStopped, apiv1.HostStatusRunning))
+	handle(s, apiv1.PluginMethodSuspendHost, s.setStatus(apiv1.HostStatusRunning, apiv1.HostStatusSuspended))
+	handle(s, apiv1.PluginMethodResumeHost, s.setStatus(apiv1.HostStatusSuspended, apiv1.HostStatusRunning))
+	handle(s, apiv1.PluginMethodWaitOperation, s.waitOperation)
+	handle(s, apiv1.PluginMethodAuthorizeHostAccess, s.authorizeHostAccess)
+	handle(s, apiv1.PluginMethodGetHostURL, s.getHostURL)
+	return s
+}
+
+func (s *Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func notFound(format string, a ...any) error {
+	return &statusError{code: http.StatusNotFound, msg: fmt.Sprintf(format, a...)}
+}
+
+func badRequest(format string, a ...any) error {
+	return &statusError{code: http.StatusBadRequest, msg: fmt.Sprintf(format, a...)}
+}
+
+// Registers a method handler taking a request of type T. The handlers run with the lock held.
+func handle[T any](s *Stub, method string, fn func(req *T) (any, error)) {
+	s.mux.HandleFunc("/"+method, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			reply(w, http.StatusMethodNotAllowed, &apiv1.Error{Code: http.StatusMethodNotAllowed, ErrorMsg: "Method not allowed"})
+			return
+		}
+		req := new(T)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			reply(w, http.StatusBadRequest, &apiv1.Error{Code: http.StatusBadRequest, ErrorMsg: "Malformed request"})
+			return
+		}
+		s.mu.Lock()
+		res, err := fn(req)
+		s.mu.Unlock()
+		if err != nil {
+			code := http.StatusInternalServerError
+			if se, ok := err.(*statusError); ok {
+				code = se.code
+			}
+			reply(w, code, &apiv1.Error{Code: code, ErrorMsg: err.Error()})
+			return
+		}
+		reply(w, http.StatusOK, res)
+	})
+}
+
+func reply(w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(obj)
+}
+
+func checkZone(zone string) error {
+	if zone != Zone {
+		return notFound("Zone %q not found.", zone)
+	}
+	return nil
+}
+
+// Whether the host is owned by the user or any of the given groups.
+func (h *host) ownedBy(user string, groups []string) bool {
+	if h.owner == user {
+		return true
+	}
+	for _, g := range groups {
+		if h.group != "" && h.group == g {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the operation was started by the user or is on a host owned by any of the given groups.
+func (op *operation) accessibleBy(user string, groups []string) bool {
+	if op.owner == user {
+		return true
+	}
+	for _, g := range groups {
+		if op.group != "" && op.group == g {
+			return true
+		}
+	}
+	return false
+}
+
+// Admins acting with their admin rights have access to every host.
+func (s *Stub) getOwnedHost(zone, user string, groups []string, admin bool, name string) (*host, error) {
+	if err := checkZone(zone); err != nil {
+		return nil, err
+	}
+	h, ok := s.hosts[name]
+	if !ok || !(admin || h.ownedBy(user, groups)) {
+		return nil, notFound("Host instance %q not found.", name)
+	}
+	return h, nil
+}
+
+func (s *Stub) newDoneOp(owner, group string, result any) *apiv1.Operation {
+	s.opSeq++
+	name := fmt.Sprintf("stub-op-%d", s.opSeq)
+	s.ops[name] = &operation{owner: owner, group: group, result: result}
+	return &apiv1.Operation{Name: name, Done: true}
+}
+
+func copyHostInstance(h *host) *apiv1.HostInstance {
+	c := *h.instance
+	return &c
+}
+
+func (s *Stub) listZones(*apiv1.PluginListZonesRequest) (any, error) {
+	return &apiv1.ListZonesResponse{Items: []*apiv1.Zone{{Name: Zone}}}, nil
+}
+
+func (s *Stub) createHost(req *apiv1.PluginCreateHostRequest) (any, error) {
+	if err := checkZone(req.Zone); err != nil {
+		return nil, err
+	}
+	if req.Request == nil || req.Request.HostInstance == nil || req.Request.TTLSeconds < 0 {
+		return nil, badRequest("invalid CreateHostRequest")
+	}
+	s.hostSeq++
+	now := time.Now()
+	h := &host{
+		owner: req.User,
+		group: req.Request.Group,
+		instance: &apiv1.HostInstance{
+			Name:         fmt.Sprintf("stub-host-%d", s.hostSeq),
+			Status:       apiv1.HostStatusRunning,
+			CreationTime: now.Format(time.RFC3339),
+			Owner:        req.User,
+			Group:        req.Request.Group,
+			Labels:       req.Request.Labels,
+		},
+	}
+	if req.Request.TTLSeconds > 0 {
+		h.expiresAt = now.Add(time.Duration(req.Request.TTLSeconds) * time.Second)
+		h.instance.ExpirationTime = h.expiresAt.Format(time.RFC3339)
+	}
+	s.hosts[h.instance.Name] = h
+	return s.newDoneOp(req.User, h.group, copyHostInstance(h)), nil
+}
+
+func matches(h *host, req *apiv1.PluginListHostsRequest) bool {
+	if !(req.AllUsers || h.ownedBy(req.User, req.Groups)) || (req.Status != "" && h.instance.Status != req.Status) {
+		return false
+	}
+	for _, r := range req.LabelSelector {
+		v, ok := h.instance.Labels[r.Key]
+		switch r.Operator {
+		case "":
+			if !ok {
+				return false
+			}
+		case "=":
+			if !ok || v != r.Value {
+				return false
+			}
+		case "!=":
+			if ok && v == r.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Stub) listHosts(req *apiv1.PluginListHostsRequest) (any, error) {
+	if err := checkZone(req.Zone); err != nil {
+		return nil, err
+	}
+	res := &apiv1.ListHostsResponse{}
+	for _, h := range s.hosts {
+		if matches(h, req) {
+			res.Items = append(res.Items, copyHostInstance(h))
+		}
+	}
+	sort.Slice(res.Items, func(i, j int) bool { return res.Items[i].Name < res.Items[j].Name })
+	return res, nil
+}
+
+func (s *Stub) deleteHost(req *apiv1.PluginHostRequest) (any, error) {
+	h, err := s.getOwnedHost(req.Zone, req.User, req.Groups, req.Admin, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.hosts, h.instance.Name)
+	return s.newDoneOp(req.User, h.group, nil), nil
+}
+
+func (s *Stub) extendHost(req *apiv1.PluginExtendHostRequest) (any, error) {
+	h, err := s.getOwnedHost(req.Zone, req.User, req.Groups, req.Admin, req.Host)
+	if err != nil {
+		return nil, err
+	}
+	if req.Request == nil || req.Request.TTLSeconds <= 0 {
+		return nil, badRequest("invalid ExtendHostRequest: ttl must be positive")
+	}
+	h.expiresAt = time.Now().Add(time.Duration(req.Request.TTLSeconds) * time.Second)
+	h.instance.ExpirationTime = h.expiresAt.Format(time.RFC3339)
+	return s.newDoneOp(req.User, h.group, copyHostInstance(h)), nil
+}
+
+func (s *Stub) deleteExpiredHosts(*apiv1.PluginDeleteExpiredHostsRequest) (any, error) {
+	now := time.Now()
+	for name, h := range s.hosts {
+		if !h.expiresAt.IsZero() && !h.expiresAt.After(now) {
+			delete(s.hosts, name)
+		}
+	}
+	return struct{}{}, nil
+}
+
+// Returns a handler moving hosts from one status to another.
+func (s *Stub) setStatus(from, to string) func(*apiv1.PluginHostRequest) (any, error) {
+	return func(req *apiv1.PluginHostRequest) (any, error) {
+		h, err := s.getOwnedHost(req.Zone, req.User, req.Groups, req.Admin, req.Host)
+		if err != nil {
+			return nil, err
+		}
+		if h.instance.Status != from {
+			return nil, badRequest("Host instance %q is %s, expected %s", req.Host, h.instance.Status, from)
+		}
+		h.instance.Status = to
+		return s.newDoneOp(req.User, h.group, copyHostInstance(h)), nil
+	}
+}
+
+func (s *Stub) waitOperation(req *apiv1.PluginWaitOperationRequest) (any, error) {
+	if err := checkZone(req.Zone); err != nil {
+		return nil, err
+	}
+	op, ok := s.ops[req.Operation]
+	if !ok || !(req.Admin || op.accessibleBy(req.User, req.Groups)) {
+		return nil, notFound("Operation %q not found.", req.Operation)
+	}
+	res := &apiv1.PluginWaitOperationResponse{}
+	if op.result != nil {
+		b, err := json.Marshal(op.result)
+		if err != nil {
+			return nil, err
+		}
+		res.Result = b
+	}
+	return res, nil
+}
+
+func (s *Stub) authorizeHostAccess(req *apiv1.PluginHostRequest) (any, error) {
+	if _, err := s.getOwnedHost(req.Zone, req.User, req.Groups, req.Admin, req.Host); err != nil {
+		return nil, err
+	}
+	return struct{}{}, nil
+}
+
+func (s *Stub) getHostURL(req *apiv1.PluginGetHostURLRequest) (any, error) {
+	if err := checkZone(req.Zone); err != nil {
+		return nil, err
+	}
+	if _, ok := s.hosts[req.Host]; !ok {
+		return nil, notFound("Host instance %q not found.", req.Host)
+	}
+	return &apiv1.PluginGetHostURLResponse{URL: s.hostURL}, nil
+}
@@ -0,0 +1,519 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/errors"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+)
+
+const ProcessIMType IMType = "process"
+
+type ProcessIMConfig struct {
+	// Command line of the host orchestrator, started once per host in the host's working directory. The
+	// ${HOST_NAME}, ${HOST_PORT} and ${HOST_DIR} variables are expanded in the arguments and set in the
+	// environment of the process, other variables are taken from the orchestrator's environment.
+	Command []string
+	// Directory where the working directories of the hosts are created. A temporary directory, removed on
+	// shutdown, is used if empty.
+	HostsDir string
+	// Port of the first host, the following hosts use consecutive ports.
+	FirstHostOrchestratorPort int
+	// Maximum number of hosts at the same time.
+	MaxHosts int
+}
+
+// The only zone of the process instance manager.
+const processZone = "local"
+
+const (
+	// Time given to a host orchestrator to exit after being asked to before it's killed.
+	processTerminationGracePeriod = 10 * time.Second
+	// Maximum time WaitOperation blocks before reporting a time out.
+	processOpWaitTimeout = 2 * time.Minute
+	// Time finished operations are kept for before being forgotten.
+	processOpRetention = time.Hour
+)
+
+type processHost struct {
+	name      string
+	owner     string
+	group     string
+	port      int
+	dir       string
+	labels    map[string]string
+	createdAt time.Time
+	// Zero if the host never expires.
+	expiresAt time.Time
+	status    string
+	cmd       *exec.Cmd
+	// Closed once the host orchestrator process exits.
+	exited chan struct{}
+}
+
+type processOp struct {
+	owner string
+	// Closed once the operation finishes, result and err must not be read before.
+	done   chan struct{}
+	doneAt time.Time
+	result any
+	err    error
+}
+
+// Implements the Manager interface running a host orchestrator process per host on the local machine. This
+// implementation is useful to develop and test multi host flows without a cloud provider.
+type ProcessInstanceManager struct {
+	config        Config
+	nameGenerator NameGenerator
+	hostsDir      string
+	removeDir     bool
+
+	mu     sync.Mutex
+	hosts  map[string]*processHost
+	ops    map[string]*processOp
+	opSeq  int
+	closed bool
+}
+
+func NewProcessInstanceManager(cfg Config, nameGenerator NameGenerator) (*ProcessInstanceManager, error) {
+	pc := cfg.Process
+	if pc == nil || len(pc.Command) == 0 {
+		return nil, fmt.Errorf("process instance manager: no host orchestrator command configured")
+	}
+	if pc.FirstHostOrchestratorPort <= 0 || pc.MaxHosts <= 0 {
+		return nil, fmt.Errorf("process instance manager: first port and max hosts must be positive")
+	}
+	if err := cfg.Quota.checkNoVCPUsLimit(); err != nil {
+		return nil, fmt.Errorf("process instance manager: %w", err)
+	}
+	m := &ProcessInstanceManager{
+		config:        cfg,
+		nameGenerator: nameGenerator,
+		hostsDir:      pc.HostsDir,
+		hosts:         make(map[string]*processHost),
+		ops:           make(map[string]*processOp),
+	}
+	if m.hostsDir == "" {
+		dir, err := os.MkdirTemp("", "cloud_orchestrator_hosts")
+		if err != nil {
+			return nil, fmt.Errorf("process instance manager: %w", err)
+		}
+		m.hostsDir = dir
+		m.removeDir = true
+	} else if err := os.MkdirAll(m.hostsDir, 0750); err != nil {
+		return nil, fmt.Errorf("process instance manager: %w", err)
+	}
+	return m, nil
+}
+
+func (m *ProcessInstanceManager) ListZones() (*apiv1.ListZonesResponse, error) {
+	return &apiv1.ListZonesResponse{
+		Items: []*apiv1.Zone{{
+			Name: processZone,
+		}},
+	}, nil
+}
+
+func (m *ProcessInstanceManager) CreateHost(zone string, req *apiv1.CreateHostRequest, user accounts.User) (*apiv1.Operation, error) {
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	if req.HostInstance == nil || req.TTLSeconds < 0 {
+		return nil, errors.NewBadRequestError("invalid CreateHostRequest", nil)
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+	if err := validateGroup(req.Group, user); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, errors.NewServiceUnavailableError("Instance manager is shutting down", nil)
+	}
+	if err := m.config.Quota.check(m.quotaUsage(user), 0); err != nil {
+		return nil, err
+	}
+	port, ok := m.freePort()
+	if !ok {
+		return nil, errors.NewTooManyRequestsError(
+			fmt.Sprintf("No host slots left, at most %d hosts can run at the same time", m.config.Process.MaxHosts), nil)
+	}
+	h := &processHost{
+		name:      m.nameGenerator.NewName(),
+		owner:     user.Username(),
+		group:     req.Group,
+		port:      port,
+		labels:    req.Labels,
+		createdAt: time.Now(),
+		status:    apiv1.HostStatusRunning,
+	}
+	h.dir = filepath.Join(m.hostsDir, h.name)
+	if req.TTLSeconds > 0 {
+		h.expiresAt = h.createdAt.Add(time.Duration(req.TTLSeconds) * time.Second)
+	}
+	if err := os.Mkdir(h.dir, 0750); err != nil {
+		return nil, errors.NewInternalError("Failed creating host directory", err)
+	}
+	if err := m.startProcess(h); err != nil {
+		os.RemoveAll(h.dir)
+		return nil, errors.NewInternalError("Failed starting host orchestrator", err)
+	}
+	m.hosts[h.name] = h
+	return m.newDoneOp(user.Username(), buildProcessHostInstance(h)), nil
+}
+
+func (m *ProcessInstanceManager) ListHosts(zone string, user accounts.User, req *ListHostsRequest) (*apiv1.ListHostsResponse, error) {
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	if req.Status != "" && !hostStatuses[req.Status] {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Invalid host status: %q", req.Status), nil)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var items []*apiv1.HostInstance
+	for _, h := range m.hosts {
+		if !(req.AllUsers || ownsHost(h.owner, h.group, user)) ||
+			(req.Status != "" && h.status != req.Status) ||
+			!req.LabelSelector.Matches(h.labels) {
+			continue
+		}
+		items = append(items, buildProcessHostInstance(h))
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return &apiv1.ListHostsResponse{Items: items}, nil
+}
+
+func (m *ProcessInstanceManager) DeleteHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, err := m.getOwnedHost(user, name)
+	if err != nil {
+		return nil, err
+	}
+	if h.status == apiv1.HostStatusStopping {
+		return nil, errors.NewBadRequestError(fmt.Sprintf("Host instance %q is already being deleted", name), nil)
+	}
+	return m.deleteHost(h, user.Username()), nil
+}
+
+func (m *ProcessInstanceManager) ExtendHost(zone string, user accounts.User, name string, req *apiv1.ExtendHostRequest) (*apiv1.Operation, error) {
+	if req.TTLSeconds <= 0 {
+		return nil, errors.NewBadRequestError("invalid ExtendHostRequest: ttl must be positive", nil)
+	}
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, err := m.getOwnedHost(user, name)
+	if err != nil {
+		return nil, err
+	}
+	h.expiresAt = time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+	return m.newDoneOp(user.Username(), buildProcessHostInstance(h)), nil
+}
+
+func (m *ProcessInstanceManager) DeleteExpiredHosts() error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, h := range m.hosts {
+		if h.expiresAt.IsZero() || h.expiresAt.After(now) || h.status == apiv1.HostStatusStopping {
+			continue
+		}
+		logging.Default().Info("Deleting expired host instance", "host", h.name, "expired_at", h.expiresAt)
+		m.deleteHost(h, h.owner)
+	}
+	return nil
+}
+
+func (m *ProcessInstanceManager) StopHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, errors.NewMethodNotAllowedError("Hosts of the process instance manager can't be stopped", nil)
+}
+
+func (m *ProcessInstanceManager) StartHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, errors.NewMethodNotAllowedError("Hosts of the process instance manager can't be started", nil)
+}
+
+func (m *ProcessInstanceManager) SuspendHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, errors.NewMethodNotAllowedError("Hosts of the process instance manager can't be suspended", nil)
+}
+
+func (m *ProcessInstanceManager) ResumeHost(zone string, user accounts.User, name string) (*apiv1.Operation, error) {
+	return nil, errors.NewMethodNotAllowedError("Hosts of the process instance manager can't be resumed", nil)
+}
+
+func (m *ProcessInstanceManager) WaitOperation(zone string, user accounts.User, name string) (any, error) {
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	op, ok := m.ops[name]
+	m.mu.Unlock()
+	if !ok || op.owner != user.Username() {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Operation %q not found.", name), nil)
+	}
+	select {
+	case <-op.done:
+		return op.result, op.err
+	case <-time.After(processOpWaitTimeout):
+		return nil, errors.NewServiceUnavailableError("Wait for operation timed out", nil)
+	}
+}
+
+func (m *ProcessInstanceManager) AuthorizeHostAccess(zone string, user accounts.User, name string) error {
+	if err := checkProcessZone(zone); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.getOwnedHost(user, name)
+	return err
+}
+
+func (m *ProcessInstanceManager) GetHostClient(zone string, host string) (HostClient, error) {
+	if err := checkProcessZone(zone); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	h, ok := m.hosts[host]
+	m.mu.Unlock()
+	if !ok {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Host instance %q not found.", host), nil)
+	}
+	url, err := url.Parse(fmt.Sprintf("%s://127.0.0.1:%d", m.config.HostOrchestratorProtocol, h.port))
+	if err != nil {
+		return nil, err
+	}
+	return NewNetHostClient(url, m.config.AllowSelfSignedHostSSLCertificate), nil
+}
+
+// Terminates every host orchestrator process and removes the hosts' working directories. No hosts can be
+// created afterwards.
+func (m *ProcessInstanceManager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	hosts := []*processHost{}
+	for _, h := range m.hosts {
+		h.status = apiv1.HostStatusStopping
+		hosts = append(hosts, h)
+	}
+	m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(h *processHost) {
+			defer wg.Done()
+			terminateProcess(h)
+		}(h)
+	}
+	wg.Wait()
+	if m.removeDir {
+		return os.RemoveAll(m.hostsDir)
+	}
+	return nil
+}
+
+func checkProcessZone(zone string) error {
+	if zone != processZone {
+		return errors.NewNotFoundError(fmt.Sprintf("Zone %q not found.", zone), nil)
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) getOwnedHost(user accounts.User, name string) (*processHost, error) {
+	h, ok := m.hosts[name]
+	if !ok || !canAccessHost(h.owner, h.group, user) {
+		return nil, errors.NewNotFoundError(fmt.Sprintf("Host instance %q not found.", name), nil)
+	}
+	return h, nil
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) quotaUsage(user accounts.User) quotaUsage {
+	usage := quotaUsage{ZoneHosts: len(m.hosts)}
+	for _, h := range m.hosts {
+		if h.owner == user.Username() {
+			usage.UserHosts++
+		}
+	}
+	return usage
+}
+
+// Returns the lowest port not used by any host. Must be called with the lock held.
+func (m *ProcessInstanceManager) freePort() (int, bool) {
+	used := make(map[int]bool)
+	for _, h := range m.hosts {
+		used[h.port] = true
+	}
+	first := m.config.Process.FirstHostOrchestratorPort
+	for port := first; port < first+m.config.Process.MaxHosts; port++ {
+		if !used[port] {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) startProcess(h *processHost) error {
+	vars := map[string]string{
+		"HOST_NAME": h.name,
+		"HOST_PORT": strconv.Itoa(h.port),
+		"HOST_DIR":  h.dir,
+	}
+	expand := func(k string) string {
+		if v, ok := vars[k]; ok {
+			return v
+		}
+		return os.Getenv(k)
+	}
+	args := make([]string, len(m.config.Process.Command))
+	for i, a := range m.config.Process.Command {
+		args[i] = os.Expand(a, expand)
+	}
+	logFile, err := os.Create(filepath.Join(h.dir, "host_orchestrator.log"))
+	if err != nil {
+		return err
+	}
+	// The child process keeps its own copy of the file descriptor.
+	defer logFile.Close()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = h.dir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Env = os.Environ()
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	h.cmd = cmd
+	h.exited = make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		m.mu.Lock()
+		if h.status == apiv1.HostStatusRunning {
+			logging.Default().Error("Host orchestrator exited unexpectedly", "host", h.name, "error", err)
+			h.status = apiv1.HostStatusTerminated
+		}
+		m.mu.Unlock()
+		close(h.exited)
+	}()
+	return nil
+}
+
+// Deletes the host in the background, the operation belongs to the user requesting the deletion, who isn't
+// necessarily the host's owner. Must be called with the lock held.
+func (m *ProcessInstanceManager) deleteHost(h *processHost, requester string) *apiv1.Operation {
+	h.status = apiv1.HostStatusStopping
+	name, op := m.newOp(requester)
+	go func() {
+		terminateProcess(h)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.hosts, h.name)
+		m.finishOp(op, struct{}{}, nil)
+	}()
+	return &apiv1.Operation{Name: name, Done: false}
+}
+
+// Asks the host orchestrator to exit, killing it after a grace period, and removes the host's directory.
+func terminateProcess(h *processHost) {
+	if err := h.cmd.Process.Signal(syscall.SIGTERM); err == nil {
+		select {
+		case <-h.exited:
+		case <-time.After(processTerminationGracePeriod):
+			logging.Default().Warn("Host orchestrator didn't exit in time, killing it", "host", h.name)
+			h.cmd.Process.Kill()
+		}
+	}
+	<-h.exited
+	if err := os.RemoveAll(h.dir); err != nil {
+		logging.Default().Error("Failed to remove host directory", "host", h.name, "error", err)
+	}
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) newOp(owner string) (string, *processOp) {
+	now := time.Now()
+	for name, op := range m.ops {
+		if !op.doneAt.IsZero() && now.Sub(op.doneAt) > processOpRetention {
+			delete(m.ops, name)
+		}
+	}
+	m.opSeq++
+	name := fmt.Sprintf("operation-%d", m.opSeq)
+	op := &processOp{owner: owner, done: make(chan struct{})}
+	m.ops[name] = op
+	return name, op
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) finishOp(op *processOp, result any, err error) {
+	op.result = result
+	op.err = err
+	op.doneAt = time.Now()
+	close(op.done)
+}
+
+// Must be called with the lock held.
+func (m *ProcessInstanceManager) newDoneOp(owner string, result any) *apiv1.Operation {
+	name, op := m.newOp(owner)
+	m.finishOp(op, result, nil)
+	return &apiv1.Operation{Name: name, Done: true}
+}
+
+func buildProcessHostInstance(h *processHost) *apiv1.HostInstance {
+	result := &apiv1.HostInstance{
+		Name:         h.name,
+		Status:       h.status,
+		CreationTime: h.createdAt.Format(time.RFC3339),
+		Owner:        h.owner,
+		Group:        h.group,
+		InternalIP:   "127.0.0.1",
+	}
+	if !h.expiresAt.IsZero() {
+		result.ExpirationTime = h.expiresAt.Format(time.RFC3339)
+	}
+	if len(h.labels) > 0 {
+		result.Labels = make(map[string]string)
+		for k, v := range h.labels {
+			result.Labels[k] = v
+		}
+	}
+	return result
+}
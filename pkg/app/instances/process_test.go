@@ -0,0 +1,339 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Not a real test, it's run as the host orchestrator process by the tests below.
+func TestHelperHostOrchestrator(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_HOST_ORCHESTRATOR") != "1" {
+		return
+	}
+	// Records the arguments so tests can check the variables were expanded.
+	if err := os.WriteFile("args", []byte(fmt.Sprint(os.Args[len(os.Args)-1])), 0644); err != nil {
+		os.Exit(1)
+	}
+	// Runs until killed.
+	time.Sleep(time.Hour)
+}
+
+type seqNameGenerator struct {
+	next int
+}
+
+func (g *seqNameGenerator) NewName() string {
+	g.next++
+	return fmt.Sprintf("host-%d", g.next)
+}
+
+type otherUser struct{}
+
+func (*otherUser) Username() string {
+	return "janedoe"
+}
+
+func newTestProcessInstanceManager(t *testing.T, maxHosts int) *ProcessInstanceManager {
+	t.Setenv("GO_WANT_HELPER_HOST_ORCHESTRATOR", "1")
+	cfg := Config{
+		HostOrchestratorProtocol: "http",
+		Process: &ProcessIMConfig{
+			Command: []string{
+				os.Args[0], "-test.run=TestHelperHostOrchestrator", "--", "${HOST_NAME}:${HOST_PORT}",
+			},
+			HostsDir:                  t.TempDir(),
+			FirstHostOrchestratorPort: 9000,
+			MaxHosts:                  maxHosts,
+		},
+	}
+	m, err := NewProcessInstanceManager(cfg, &seqNameGenerator{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func createProcessHost(t *testing.T, m *ProcessInstanceManager, req *apiv1.CreateHostRequest) *apiv1.HostInstance {
+	op, err := m.CreateHost(processZone, req, &TestUser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.WaitOperation(processZone, &TestUser{}, op.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.(*apiv1.HostInstance)
+}
+
+func TestProcessCreateAndListHosts(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 2)
+	req := &apiv1.CreateHostRequest{
+		HostInstance: &apiv1.HostInstance{},
+		Labels:       map[string]string{"team": "camera"},
+	}
+
+	first := createProcessHost(t, m, req)
+	second := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	if first.Name != "host-1" || second.Name != "host-2" {
+		t.Fatalf("unexpected host names: %q, %q", first.Name, second.Name)
+	}
+	res, err := m.ListHosts(processZone, &TestUser{}, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.HostInstance{first, second}, res.Items); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	selector, _ := ParseLabelSelector("team=camera")
+	res, err = m.ListHosts(processZone, &TestUser{}, &ListHostsRequest{LabelSelector: selector})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 1 || res.Items[0].Name != "host-1" {
+		t.Errorf("expected only host-1 to match the selector, got: %+v", res.Items)
+	}
+	res, err = m.ListHosts(processZone, &otherUser{}, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("expected no hosts for other user, got: %+v", res.Items)
+	}
+}
+
+func TestProcessCreateHostStartsProcess(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 2)
+
+	createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+	createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	for name, want := range map[string]string{"host-1": "host-1:9000", "host-2": "host-2:9001"} {
+		argsFile := filepath.Join(m.hostsDir, name, "args")
+		var got []byte
+		// The process may not have written the file yet.
+		for i := 0; i < 100 && len(got) == 0; i++ {
+			got, _ = os.ReadFile(argsFile)
+			if len(got) == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("%s args mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+	hc, err := m.GetHostClient(processZone, "host-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hc.(*NetHostClient).url.String(); got != "http://127.0.0.1:9001" {
+		t.Errorf("unexpected host URL: %q", got)
+	}
+}
+
+func TestProcessCreateHostNoSlotsLeft(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	_, err := m.CreateHost(processZone, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}}, &TestUser{})
+
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected too many requests error, got: %v", err)
+	}
+}
+
+func TestProcessHostPowerOperationsNotAllowed(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	host := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+	ops := map[string]func(string, accounts.User, string) (*apiv1.Operation, error){
+		"stop":    m.StopHost,
+		"start":   m.StartHost,
+		"suspend": m.SuspendHost,
+		"resume":  m.ResumeHost,
+	}
+	for name, op := range ops {
+		t.Run(name, func(t *testing.T) {
+			_, err := op(processZone, &TestUser{}, host.Name)
+
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusMethodNotAllowed {
+				t.Errorf("expected method not allowed error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestProcessDeleteHost(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	host := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	op, err := m.DeleteHost(processZone, &TestUser{}, host.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.WaitOperation(processZone, &TestUser{}, op.Name); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := m.ListHosts(processZone, &TestUser{}, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 0 {
+		t.Errorf("expected no hosts, got: %+v", res.Items)
+	}
+	if _, err := os.Stat(filepath.Join(m.hostsDir, host.Name)); !os.IsNotExist(err) {
+		t.Errorf("expected host directory to be removed, got: %v", err)
+	}
+	// The slot is free again.
+	createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+}
+
+func TestProcessHostNotOwned(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	host := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+
+	_, delErr := m.DeleteHost(processZone, &otherUser{}, host.Name)
+	authErr := m.AuthorizeHostAccess(processZone, &otherUser{}, host.Name)
+
+	for _, err := range []error{delErr, authErr} {
+		var appErr *apperr.AppError
+		if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusNotFound {
+			t.Errorf("expected not found error, got: %v", err)
+		}
+	}
+}
+
+func TestProcessGroupMembersShareHosts(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	op, err := m.CreateHost(processZone,
+		&apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}, Group: "camera"}, cameraMember(&TestUser{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := m.WaitOperation(processZone, &TestUser{}, op.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := res.(*apiv1.HostInstance)
+	member := cameraMember(&otherUser{})
+
+	list, err := m.ListHosts(processZone, member, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.HostInstance{host}, list.Items); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	if host.Group != "camera" {
+		t.Errorf("expected group %q, got %q", "camera", host.Group)
+	}
+	if err := m.AuthorizeHostAccess(processZone, member, host.Name); err != nil {
+		t.Errorf("expected group member to have access, got: %v", err)
+	}
+	op, err = m.DeleteHost(processZone, member, host.Name)
+	if err != nil {
+		t.Fatalf("expected group member to delete the host, got: %v", err)
+	}
+	if _, err := m.WaitOperation(processZone, member, op.Name); err != nil {
+		t.Errorf("expected group member to wait for the deletion, got: %v", err)
+	}
+}
+
+func TestProcessAdminManagesEveryHost(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	host := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+	other := admin(&otherUser{})
+
+	own, err := m.ListHosts(processZone, other, &ListHostsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(own.Items) != 0 {
+		t.Errorf("expected no hosts owned by the admin, got: %+v", own.Items)
+	}
+	all, err := m.ListHosts(processZone, other, &ListHostsRequest{AllUsers: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]*apiv1.HostInstance{host}, all.Items); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+	if err := m.AuthorizeHostAccess(processZone, other, host.Name); err == nil {
+		t.Error("expected admin not to have access to other users' hosts")
+	}
+	if _, err := m.DeleteHost(processZone, other, host.Name); err == nil {
+		t.Error("expected admin not to delete other users' hosts without admin rights")
+	}
+	op, err := m.DeleteHost(processZone, accounts.WithAdminRights(other), host.Name)
+	if err != nil {
+		t.Fatalf("expected admin to delete the host with admin rights, got: %v", err)
+	}
+	if _, err := m.WaitOperation(processZone, other, op.Name); err != nil {
+		t.Errorf("expected admin to wait for the deletion, got: %v", err)
+	}
+}
+
+func TestProcessRejectsVCPUsQuota(t *testing.T) {
+	cfg := Config{
+		Quota: QuotaConfig{MaxVCPUsPerUser: 8},
+		Process: &ProcessIMConfig{
+			Command:                   []string{"host_orchestrator"},
+			HostsDir:                  t.TempDir(),
+			FirstHostOrchestratorPort: 9000,
+			MaxHosts:                  1,
+		},
+	}
+
+	if _, err := NewProcessInstanceManager(cfg, &seqNameGenerator{}); err == nil {
+		t.Error("expected an error for a quota the process instance manager can't enforce")
+	}
+}
+
+func TestProcessCloseTerminatesHosts(t *testing.T) {
+	m := newTestProcessInstanceManager(t, 1)
+	host := createProcessHost(t, m, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}})
+	h := m.hosts[host.Name]
+
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-h.exited:
+	default:
+		t.Error("expected host orchestrator process to have exited")
+	}
+	_, err := m.CreateHost(processZone, &apiv1.CreateHostRequest{HostInstance: &apiv1.HostInstance{}}, &TestUser{})
+	var appErr *apperr.AppError
+	if !errors.As(err, &appErr) || appErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected service unavailable error, got: %v", err)
+	}
+}
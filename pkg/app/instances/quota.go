@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/errors"
+)
+
+// Limits on the hosts created through the orchestrator. A zero value means unlimited.
+type QuotaConfig struct {
+	// Maximum number of hosts a user can own across all zones.
+	MaxHostsPerUser int
+	// Maximum total number of vCPUs of the hosts a user owns across all zones. Only the GCP instance manager knows
+	// the vCPUs of the hosts, the other instance managers fail to start if it is set.
+	MaxVCPUsPerUser int
+	// Maximum number of hosts in a single zone, regardless of their owner.
+	MaxHostsPerZone int
+}
+
+func (c *QuotaConfig) userLimited() bool {
+	return c.MaxHostsPerUser > 0 || c.MaxVCPUsPerUser > 0
+}
+
+func (c *QuotaConfig) zoneLimited() bool {
+	return c.MaxHostsPerZone > 0
+}
+
+// Instance managers that don't know the vCPUs of their hosts refuse to start with a vCPUs limit rather than
+// ignoring it.
+func (c *QuotaConfig) checkNoVCPUsLimit() error {
+	if c.MaxVCPUsPerUser > 0 {
+		return fmt.Errorf("MaxVCPUsPerUser quota is not supported, the vCPUs of the hosts are unknown")
+	}
+	return nil
+}
+
+// Resources in use before the new host is created.
+type quotaUsage struct {
+	UserHosts int
+	UserVCPUs int
+	ZoneHosts int
+}
+
+// Returns an error naming the limit that would be exceeded by creating a new host with the given number of
+// vCPUs. Per user limits are reported as 403 Forbidden since they only change when the user deletes some of
+// their hosts, while the per zone limit is reported as 429 Too Many Requests as it depends on other users too.
+func (c *QuotaConfig) check(usage quotaUsage, newVCPUs int) error {
+	if c.MaxHostsPerUser > 0 && usage.UserHosts+1 > c.MaxHostsPerUser {
+		return errors.NewForbiddenError(
+			fmt.Sprintf("Quota exceeded: hosts per user limit is %d, %d in use", c.MaxHostsPerUser, usage.UserHosts), nil)
+	}
+	if c.MaxVCPUsPerUser > 0 && usage.UserVCPUs+newVCPUs > c.MaxVCPUsPerUser {
+		return errors.NewForbiddenError(
+			fmt.Sprintf("Quota exceeded: vCPUs per user limit is %d, %d in use and %d requested",
+				c.MaxVCPUsPerUser, usage.UserVCPUs, newVCPUs), nil)
+	}
+	if c.MaxHostsPerZone > 0 && usage.ZoneHosts+1 > c.MaxHostsPerZone {
+		return errors.NewTooManyRequestsError(
+			fmt.Sprintf("Quota exceeded: hosts per zone limit is %d, %d in use", c.MaxHostsPerZone, usage.ZoneHosts), nil)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	apperr "github.com/google/cloud-android-orchestration/pkg/app/errors"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	quota := QuotaConfig{MaxHostsPerUser: 2, MaxVCPUsPerUser: 8, MaxHostsPerZone: 10}
+	tests := []struct {
+		name     string
+		usage    quotaUsage
+		newVCPUs int
+		expCode  int
+		expLimit string
+	}{
+		{"within limits", quotaUsage{UserHosts: 1, UserVCPUs: 4, ZoneHosts: 9}, 4, 0, ""},
+		{"hosts per user", quotaUsage{UserHosts: 2}, 1, http.StatusForbidden, "hosts per user"},
+		{"vcpus per user", quotaUsage{UserHosts: 1, UserVCPUs: 4}, 8, http.StatusForbidden, "vCPUs per user"},
+		{"hosts per zone", quotaUsage{ZoneHosts: 10}, 1, http.StatusTooManyRequests, "hosts per zone"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := quota.check(test.usage, test.newVCPUs)
+
+			if test.expCode == 0 {
+				if err != nil {
+					t.Fatalf("expected <<nil>>, got %+v", err)
+				}
+				return
+			}
+			var appErr *apperr.AppError
+			if !errors.As(err, &appErr) {
+				t.Fatalf("unexpected error <<\"%v\">>, want \"%T\"", err, appErr)
+			}
+			if diff := cmp.Diff(test.expCode, appErr.StatusCode); diff != "" {
+				t.Errorf("status code mismatch (-want +got):\n%s", diff)
+			}
+			if !strings.Contains(appErr.Msg, test.expLimit) {
+				t.Errorf("expected message to name the %q limit, got %q", test.expLimit, appErr.Msg)
+			}
+		})
+	}
+}
+
+func TestQuotaCheckUnlimited(t *testing.T) {
+	quota := QuotaConfig{}
+
+	err := quota.check(quotaUsage{UserHosts: 100, UserVCPUs: 1000, ZoneHosts: 1000}, 64)
+
+	if err != nil {
+		t.Errorf("expected <<nil>>, got %+v", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+)
+
+// Periodically deletes the hosts whose time to live has expired.
+type HostReaper struct {
+	manager  Manager
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+func NewHostReaper(manager Manager, interval time.Duration) *HostReaper {
+	return &HostReaper{
+		manager:  manager,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Starts deleting expired hosts in the background until Stop is called.
+func (r *HostReaper) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.manager.DeleteExpiredHosts(); err != nil {
+					logging.Default().Error("Failed to delete expired hosts", "error", err)
+				}
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (r *HostReaper) Stop() {
+	close(r.stopCh)
+}
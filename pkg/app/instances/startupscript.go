@@ -0,0 +1,60 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Variables available to startup script templates, e.g. `{{.Owner}}` or `{{index .Labels "team"}}`.
+type StartupScriptVars struct {
+	// Username of the user creating the host.
+	Owner string
+	// Name of the host instance.
+	HostName string
+	Zone     string
+	// User defined labels of the host.
+	Labels map[string]string
+}
+
+// Parses the startup script template files. Templates use the text/template syntax.
+func ParseStartupScriptTemplates(paths []string) ([]*template.Template, error) {
+	result := []*template.Template{}
+	for _, p := range paths {
+		t, err := template.New(filepath.Base(p)).Option("missingkey=error").ParseFiles(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing startup script template %q: %w", p, err)
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+// Renders the templates in order, concatenating the results into a single script.
+func renderStartupScript(templates []*template.Template, vars *StartupScriptVars) (string, error) {
+	sb := strings.Builder{}
+	for _, t := range templates {
+		if err := t.Execute(&sb, vars); err != nil {
+			return "", fmt.Errorf("failed rendering startup script template %q: %w", t.Name(), err)
+		}
+		if !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+
+	"github.com/hashicorp/go-multierror"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+type WarmPoolConfig struct {
+	Zone        string
+	MachineType string
+	// Number of unowned hosts to keep around.
+	Size int
+}
+
+const defaultWarmPoolRefillInterval = 5 * time.Minute
+
+// Whether requests for the given host shape can be served from a warm pool. Only hosts without customizations
+// are pooled.
+func (m *GCEInstanceManager) hasWarmPool(zone string, gcp *apiv1.GCPInstance) bool {
+	if gcp.MinCPUPlatform != "" || gcp.BootDiskSizeGB != 0 || gcp.Subnetwork != "" || len(gcp.NetworkTags) != 0 ||
+		gcp.NoExternalIP || gcp.Spot {
+		return false
+	}
+	for _, p := range m.Config.GCP.WarmPools {
+		if p.Zone == zone && p.MachineType == gcp.MachineType && p.Size > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Hands a ready host from the warm pool to the user by relabeling it. Returns false if no host could be
+// claimed, in which case a new host must be created instead.
+func (m *GCEInstanceManager) claimWarmHost(zone string, user accounts.User, req *apiv1.CreateHostRequest) (*apiv1.Operation, bool) {
+	machineType := req.HostInstance.GCP.MachineType
+	res, err := m.Service.Instances.
+		List(m.Config.GCP.ProjectID, zone).
+		Context(context.TODO()).
+		Filter(fmt.Sprintf("labels.%s:%s AND status=%s", labelWarmPool, machineType, apiv1.HostStatusRunning)).
+		Do()
+	if err != nil {
+		logging.Default().Error("Failed to list warm pool hosts", "zone", zone, "error", err)
+		return nil, false
+	}
+	for _, ins := range res.Items {
+		labels := map[string]string{}
+		for k, v := range ins.Labels {
+			if k != labelWarmPool {
+				labels[k] = v
+			}
+		}
+		for k, v := range ownerLabels(m.Config.GCP, user, req) {
+			labels[k] = v
+		}
+		// The fingerprint makes the request fail if another request claimed the host first.
+		setLabelsReq := &compute.InstancesSetLabelsRequest{
+			Labels:           labels,
+			LabelFingerprint: ins.LabelFingerprint,
+		}
+		op, err := m.Service.Instances.
+			SetLabels(m.Config.GCP.ProjectID, zone, ins.Name, setLabelsReq).
+			Context(context.TODO()).
+			Do()
+		if err != nil {
+			if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != http.StatusPreconditionFailed {
+				logging.Default().Error("Failed to claim warm pool host", "zone", zone, "host", ins.Name, "error", err)
+			}
+			continue
+		}
+		m.hostCache.invalidate(zone, ins.Name)
+		m.triggerWarmPoolRefill()
+		return &apiv1.Operation{Name: op.Name, Done: op.Status == operationStatusDone}, true
+	}
+	m.triggerWarmPoolRefill()
+	return nil, false
+}
+
+func (m *GCEInstanceManager) triggerWarmPoolRefill() {
+	select {
+	case m.warmPoolRefillCh <- struct{}{}:
+	default:
+		// A refill is already pending.
+	}
+}
+
+// Creates the hosts missing for the warm pools to reach their target size. Pooled hosts that were stopped or
+// terminated, e.g. by a maintenance event, are deleted and replaced.
+func (m *GCEInstanceManager) RefillWarmPools() error {
+	var merr error
+	for _, p := range m.Config.GCP.WarmPools {
+		if err := m.refillWarmPool(p); err != nil {
+			merr = multierror.Append(merr,
+				fmt.Errorf("failed to refill warm pool of %q hosts in zone %q: %w", p.MachineType, p.Zone, err))
+		}
+	}
+	return merr
+}
+
+func (m *GCEInstanceManager) refillWarmPool(p WarmPoolConfig) error {
+	if err := validateLabelValue(p.MachineType); err != nil {
+		return err
+	}
+	res, err := m.Service.Instances.
+		List(m.Config.GCP.ProjectID, p.Zone).
+		Context(context.TODO()).
+		Filter(fmt.Sprintf("labels.%s:%s", labelWarmPool, p.MachineType)).
+		Do()
+	if err != nil {
+		return err
+	}
+	var merr error
+	size := 0
+	for _, ins := range res.Items {
+		switch ins.Status {
+		case apiv1.HostStatusStopped, apiv1.HostStatusTerminated:
+			logging.Default().Info("Deleting unusable warm pool host", "zone", p.Zone, "host", ins.Name, "status", ins.Status)
+			_, err := m.Service.Instances.Delete(m.Config.GCP.ProjectID, p.Zone, ins.Name).Context(context.TODO()).Do()
+			if err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("failed to delete host %q: %w", ins.Name, err))
+			}
+		case apiv1.HostStatusStopping:
+			// Will be deleted by a later refill once stopped.
+		default:
+			size++
+		}
+	}
+	for ; size < p.Size; size++ {
+		payload := m.buildInstance(p.Zone, &apiv1.GCPInstance{MachineType: p.MachineType})
+		payload.Labels[labelWarmPool] = p.MachineType
+		err := m.setStartupScript(payload, &StartupScriptVars{HostName: payload.Name, Zone: p.Zone})
+		if err != nil {
+			return multierror.Append(merr, err)
+		}
+		_, err = m.Service.Instances.Insert(m.Config.GCP.ProjectID, p.Zone, payload).Context(context.TODO()).Do()
+		if err != nil {
+			return multierror.Append(merr, fmt.Errorf("failed to create host: %w", err))
+		}
+	}
+	return merr
+}
+
+// Keeps the warm pools of a GCE instance manager at their target size.
+type WarmPoolRefiller struct {
+	manager  *GCEInstanceManager
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+func NewWarmPoolRefiller(manager *GCEInstanceManager) *WarmPoolRefiller {
+	interval := defaultWarmPoolRefillInterval
+	if minutes := manager.Config.GCP.WarmPoolRefillIntervalMinutes; minutes > 0 {
+		interval = time.Duration(minutes) * time.Minute
+	}
+	return &WarmPoolRefiller{
+		manager:  manager,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Refills the warm pools in the background, periodically and whenever a host is taken from a pool, until
+// Stop is called.
+func (r *WarmPoolRefiller) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			if err := r.manager.RefillWarmPools(); err != nil {
+				logging.Default().Error("Failed to refill warm pools", "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-r.manager.warmPoolRefillCh:
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+func (r *WarmPoolRefiller) Stop() {
+	close(r.stopCh)
+}
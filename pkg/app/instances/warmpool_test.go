@@ -0,0 +1,204 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instances
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/compute/v1"
+)
+
+const instancesPath = "/projects/google.com:test-project/zones/us-central1-a/instances"
+
+var warmPoolTestConfig = Config{
+	GCP: &GCPIMConfig{
+		ProjectID:       "google.com:test-project",
+		HostImageFamily: "projects/test-project-releases/global/images/family/foo",
+		WarmPools: []WarmPoolConfig{
+			{Zone: "us-central1-a", MachineType: "n1-standard-4", Size: 2},
+		},
+	},
+}
+
+func TestCreateHostClaimsWarmHost(t *testing.T) {
+	var listFilter string
+	var setLabelsReqs []compute.InstancesSetLabelsRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; path {
+		case instancesPath:
+			if r.Method == http.MethodPost {
+				t.Fatal("unexpected host creation")
+			}
+			listFilter = r.URL.Query().Get("filter")
+			replyJSON(w, &compute.InstanceList{
+				Items: []*compute.Instance{
+					{Name: "bar", Labels: map[string]string{labelWarmPool: "n1-standard-4"}, LabelFingerprint: "fp-bar"},
+					{Name: "baz", Labels: map[string]string{labelWarmPool: "n1-standard-4"}, LabelFingerprint: "fp-baz"},
+				},
+			})
+		case instancesPath + "/bar/setLabels":
+			// Another request claimed it first.
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"error":{"code":412,"message":"Labels fingerprint either invalid or resource labels have changed"}}`))
+		case instancesPath + "/baz/setLabels":
+			body, _ := ioutil.ReadAll(r.Body)
+			req := compute.InstancesSetLabelsRequest{}
+			json.Unmarshal(body, &req)
+			setLabelsReqs = append(setLabelsReqs, req)
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		default:
+			t.Fatalf("unexpected path: %q", path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(warmPoolTestConfig, buildTestService(t, ts), testNameGenerator)
+
+	op, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-4"},
+			},
+			Labels: map[string]string{"team": "camera"},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("operation-1", op.Name); diff != "" {
+		t.Errorf("operation name mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("labels.cf-warm_pool:n1-standard-4 AND status=RUNNING", listFilter); diff != "" {
+		t.Errorf("list filter mismatch (-want +got):\n%s", diff)
+	}
+	expected := []compute.InstancesSetLabelsRequest{
+		{
+			Labels:           map[string]string{labelCreatedBy: fakeUsername, "team": "camera"},
+			LabelFingerprint: "fp-baz",
+		},
+	}
+	if diff := cmp.Diff(expected, setLabelsReqs); diff != "" {
+		t.Errorf("set labels requests mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateHostWarmPoolEmpty(t *testing.T) {
+	var inserted compute.Instance
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != instancesPath {
+			t.Fatalf("unexpected path: %q", r.URL.Path)
+		}
+		if r.Method == http.MethodPost {
+			body, _ := ioutil.ReadAll(r.Body)
+			json.Unmarshal(body, &inserted)
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+			return
+		}
+		replyJSON(w, &compute.InstanceList{})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(warmPoolTestConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-4"},
+			},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{labelCreatedBy: fakeUsername}, inserted.Labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCreateHostCustomizedSkipsWarmPool(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != instancesPath || r.Method != http.MethodPost {
+			t.Fatalf("unexpected request: %s %q", r.Method, r.URL.Path)
+		}
+		replyJSON(w, &compute.Operation{Name: "operation-1"})
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(warmPoolTestConfig, buildTestService(t, ts), testNameGenerator)
+
+	_, err := im.CreateHost("us-central1-a",
+		&apiv1.CreateHostRequest{
+			HostInstance: &apiv1.HostInstance{
+				GCP: &apiv1.GCPInstance{MachineType: "n1-standard-4", MinCPUPlatform: "Intel Haswell"},
+			},
+		},
+		&TestUser{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefillWarmPools(t *testing.T) {
+	deleted := []string{}
+	inserted := []compute.Instance{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == instancesPath && r.Method == http.MethodGet:
+			replyJSON(w, &compute.InstanceList{
+				Items: []*compute.Instance{
+					{Name: "bar", Status: "TERMINATED"},
+					{Name: "baz", Status: "STAGING"},
+				},
+			})
+		case r.URL.Path == instancesPath && r.Method == http.MethodPost:
+			body, _ := ioutil.ReadAll(r.Body)
+			ins := compute.Instance{}
+			json.Unmarshal(body, &ins)
+			inserted = append(inserted, ins)
+			replyJSON(w, &compute.Operation{Name: "operation-1"})
+		case r.URL.Path == instancesPath+"/bar" && r.Method == http.MethodDelete:
+			deleted = append(deleted, "bar")
+			replyJSON(w, &compute.Operation{Name: "operation-2"})
+		default:
+			t.Fatalf("unexpected request: %s %q", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	im := NewGCEInstanceManager(warmPoolTestConfig, buildTestService(t, ts), testNameGenerator)
+
+	if err := im.RefillWarmPools(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"bar"}, deleted); diff != "" {
+		t.Errorf("deleted hosts mismatch (-want +got):\n%s", diff)
+	}
+	if len(inserted) != 1 {
+		t.Fatalf("expected 1 host created, got %d", len(inserted))
+	}
+	if diff := cmp.Diff(map[string]string{labelWarmPool: "n1-standard-4"}, inserted[0].Labels); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("zones/us-central1-a/machineTypes/n1-standard-4", inserted[0].MachineType); diff != "" {
+		t.Errorf("machine type mismatch (-want +got):\n%s", diff)
+	}
+}
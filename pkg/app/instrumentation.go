@@ -0,0 +1,124 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/cloud-android-orchestration/pkg/app/accounts"
+	"github.com/google/cloud-android-orchestration/pkg/app/logging"
+	"github.com/google/cloud-android-orchestration/pkg/app/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_http_requests_total",
+		"HTTP requests handled, by route, method and status code.", "route", "method", "status")
+	httpRequestDuration = metrics.DefaultRegistry.NewHistogramVec("cloud_orchestrator_http_request_duration_seconds",
+		"Latency of the HTTP requests, by route and method.", metrics.DefaultBuckets, "route", "method")
+	// The host series are deleted with the hosts, those of hosts deleted otherwise, like expired ones, once idle.
+	proxyBytes = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_proxy_bytes_total",
+		"Bytes proxied between clients and host orchestrators, by zone, host and direction.",
+		"zone", "host", "direction").WithIdleTimeout(hostMetricsIdleTimeout)
+	proxyErrors = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_proxy_errors_total",
+		"Requests that couldn't be proxied to host orchestrators, by zone and host.", "zone", "host").
+		WithIdleTimeout(hostMetricsIdleTimeout)
+	oauth2TokenRefreshes = metrics.DefaultRegistry.NewCounterVec("cloud_orchestrator_oauth2_token_refreshes_total",
+		"Refreshes of the stored OAuth2 tokens, by outcome.", "outcome")
+)
+
+const hostMetricsIdleTimeout = 24 * time.Hour
+
+func serveMetrics(w http.ResponseWriter, r *http.Request, _ accounts.User) error {
+	metrics.DefaultRegistry.Handler().ServeHTTP(w, r)
+	return nil
+}
+
+// Deletes the metrics series of the given host.
+func forgetHostMetrics(zone, host string) {
+	labels := map[string]string{"zone": zone, "host": host}
+	proxyBytes.DeletePartialMatch(labels)
+	proxyErrors.DeletePartialMatch(labels)
+}
+
+// Returns the handler wrapped in another assigning an ID to every request, accepted from the X-Request-Id
+// header or generated, and logging the request once handled. The ID is returned in the response, forwarded
+// to host orchestrators and added to every entry logged with the request's logger.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r)
+		w.Header().Set(headerNameRequestID, id)
+		logger := logging.Default().With("request_id", id)
+		// Handlers may modify the request's path.
+		method, path := r.Method, r.URL.Path
+		sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(logging.NewContext(r.Context(), logger)))
+		logger.Info("Request handled",
+			"method", method,
+			"path", path,
+			"remote_addr", r.RemoteAddr,
+			"status", sw.statusCode,
+			"duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+// Label of the requests that didn't match any route.
+const unmatchedRoute = "unmatched"
+
+type routeKey struct{}
+
+// Returns the handler wrapped in another recording the request metrics. The route label is set by
+// recordRoute, which must be used as middleware of the router.
+func instrumentRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := unmatchedRoute
+		sw := &statusWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), routeKey{}, &route)))
+		httpRequests.Inc(route, r.Method, strconv.Itoa(sw.statusCode))
+		httpRequestDuration.ObserveSince(start, route, r.Method)
+	})
+}
+
+// Router middleware recording the matched route in the request context. Requests served by several
+// routes, like those addressing hosts by handle, are reported under the last one.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := r.Context().Value(routeKey{}).(*string); ok {
+			if tpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				*route = tpl
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Counts the bytes read from the wrapped reader.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
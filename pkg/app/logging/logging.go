@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging implements leveled structured logging, every entry is written as a JSON object in a single
+// line.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "DEBUG",
+	LevelInfo:  "INFO",
+	LevelWarn:  "WARN",
+	LevelError: "ERROR",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Parses a level name, case insensitive. The empty string is LevelInfo.
+func ParseLevel(s string) (Level, error) {
+	if s == "" {
+		return LevelInfo, nil
+	}
+	for l, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+}
+
+type Config struct {
+	// One of "debug", "info", "warn" or "error", "info" if empty.
+	Level string
+}
+
+type output struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel Level
+}
+
+// Loggers are safe for concurrent use.
+type Logger struct {
+	out *output
+	// Key value pairs added to every entry.
+	fields []any
+}
+
+func New(w io.Writer, minLevel Level) *Logger {
+	return &Logger{out: &output{w: w, minLevel: minLevel}}
+}
+
+var defaultLogger = New(os.Stderr, LevelInfo)
+
+func Default() *Logger {
+	return defaultLogger
+}
+
+// Replaces the default logger and routes the output of the standard log package through it, with the info
+// level.
+func SetDefault(l *Logger) {
+	defaultLogger = l
+	log.SetFlags(0)
+	log.SetOutput(&stdLogWriter{l})
+}
+
+// Returns a logger adding the given key value pairs to every entry.
+func (l *Logger) With(keyvals ...any) *Logger {
+	return &Logger{out: l.out, fields: append(append([]any{}, l.fields...), keyvals...)}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...any) { l.Log(LevelDebug, msg, keyvals...) }
+func (l *Logger) Info(msg string, keyvals ...any)  { l.Log(LevelInfo, msg, keyvals...) }
+func (l *Logger) Warn(msg string, keyvals ...any)  { l.Log(LevelWarn, msg, keyvals...) }
+func (l *Logger) Error(msg string, keyvals ...any) { l.Log(LevelError, msg, keyvals...) }
+
+// Writes an entry with the given message and key value pairs. Keys must be strings, errors are logged with
+// their message.
+func (l *Logger) Log(level Level, msg string, keyvals ...any) {
+	if level < l.out.minLevel {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSON(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(&buf, level.String())
+	buf.WriteString(`,"msg":`)
+	writeJSON(&buf, msg)
+	kvs := append(append([]any{}, l.fields...), keyvals...)
+	for i := 0; i < len(kvs); i += 2 {
+		key := fmt.Sprint(kvs[i])
+		var value any = "(MISSING)"
+		if i+1 < len(kvs) {
+			value = kvs[i+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		buf.WriteByte(',')
+		writeJSON(&buf, key)
+		buf.WriteByte(':')
+		writeJSON(&buf, value)
+	}
+	buf.WriteString("}\n")
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+type stdLogWriter struct {
+	l *Logger
+}
+
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	w.l.Info(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// Returns the logger of the context, the default logger if none.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func decodeEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		e := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid entry %q: %v", line, err)
+		}
+		if _, ok := e["time"]; !ok {
+			t.Errorf("entry without time: %q", line)
+		}
+		delete(e, "time")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestLoggerWritesJSONEntries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := New(buf, LevelInfo).With("request_id", "req-1")
+
+	l.Debug("skipped")
+	l.Info("Request handled", "status", 200)
+	l.Error("Failed", "error", fmt.Errorf("boom"), "odd")
+
+	want := []map[string]any{
+		{"level": "INFO", "msg": "Request handled", "request_id": "req-1", "status": float64(200)},
+		{"level": "ERROR", "msg": "Failed", "request_id": "req-1", "error": "boom", "odd": "(MISSING)"},
+	}
+	if diff := cmp.Diff(want, decodeEntries(t, buf)); diff != "" {
+		t.Errorf("entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]Level{
+		"":      LevelInfo,
+		"debug": LevelDebug,
+		"WARN":  LevelWarn,
+		"error": LevelError,
+	}
+	for s, want := range tests {
+		got, err := ParseLevel(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("ParseLevel(%q): expected %s, got %s", s, want, got)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	l := New(&bytes.Buffer{}, LevelInfo)
+
+	if got := FromContext(NewContext(context.Background(), l)); got != l {
+		t.Errorf("expected the context logger, got %+v", got)
+	}
+	if got := FromContext(context.Background()); got != Default() {
+		t.Errorf("expected the default logger, got %+v", got)
+	}
+}
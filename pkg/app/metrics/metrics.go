@@ -0,0 +1,292 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics implements counters and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latency buckets in seconds, from 5ms to 10s.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry of the metrics served by the orchestrator's /metrics route.
+var DefaultRegistry = NewRegistry()
+
+type metric interface {
+	write(w io.Writer) error
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Writes every metric in the Prometheus text exposition format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.Write(w)
+	})
+}
+
+type desc struct {
+	name       string
+	help       string
+	labelNames []string
+}
+
+// Formats the label values as they appear in the exposition format, e.g. `route="/v1/zones",method="GET"`.
+func (d *desc) labels(values []string) string {
+	if len(values) != len(d.labelNames) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", d.name, len(d.labelNames), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, v := range values {
+		pairs[i] = d.labelNames[i] + `="` + escapeLabelValue(v) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d *desc) writeHeader(w io.Writer, typ string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, typ)
+	return err
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func series(name, labels string) string {
+	if labels == "" {
+		return name
+	}
+	return name + "{" + labels + "}"
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type counter struct {
+	labelValues []string
+	value       float64
+	updated     time.Time
+}
+
+// Counters partitioned by label values.
+type CounterVec struct {
+	desc
+	// Counters not updated for this long are dropped, never if zero.
+	idleTimeout time.Duration
+	mu          sync.Mutex
+	values      map[string]*counter
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, labelNames}, values: make(map[string]*counter)}
+	r.register(c)
+	return c
+}
+
+// Makes the counters not updated for the given time to be dropped, so that those of label values no longer
+// in use don't accumulate. Returns the same CounterVec.
+func (c *CounterVec) WithIdleTimeout(d time.Duration) *CounterVec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.idleTimeout = d
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Adds the given non negative value to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	labels := c.labels(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ctr, ok := c.values[labels]
+	if !ok {
+		ctr = &counter{labelValues: append([]string{}, labelValues...)}
+		c.values[labels] = ctr
+	}
+	ctr.value += v
+	ctr.updated = time.Now()
+}
+
+// Deletes the counters whose label values match the given ones, by label name. Returns how many were deleted.
+func (c *CounterVec) DeletePartialMatch(labels map[string]string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := 0
+	for key, ctr := range c.values {
+		if c.matches(ctr, labels) {
+			delete(c.values, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (c *CounterVec) matches(ctr *counter, labels map[string]string) bool {
+	for i, name := range c.labelNames {
+		if v, ok := labels[name]; ok && v != ctr.labelValues[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.writeHeader(w, "counter"); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idleTimeout > 0 {
+		for key, ctr := range c.values {
+			if time.Since(ctr.updated) > c.idleTimeout {
+				delete(c.values, key)
+			}
+		}
+	}
+	for _, labels := range sortedKeys(c.values) {
+		if _, err := fmt.Fprintf(w, "%s %s\n", series(c.name, labels), formatFloat(c.values[labels].value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type histogram struct {
+	// Not cumulative, the last one counts the observations above every bucket.
+	counts []uint64
+	sum    float64
+}
+
+// Histograms partitioned by label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+// The buckets are the upper bounds of the histogram buckets, in increasing order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name, help, labelNames},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	labels := h.labels(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hist, ok := h.values[labels]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[labels] = hist
+	}
+	hist.counts[sort.SearchFloat64s(h.buckets, v)]++
+	hist.sum += v
+}
+
+// Observes the time elapsed since start, in seconds.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.writeHeader(w, "histogram"); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, labels := range sortedKeys(h.values) {
+		hist := h.values[labels]
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		var count uint64
+		for i, c := range hist.counts {
+			count += c
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			bucketLabels := labels + sep + `le="` + formatFloat(le) + `"`
+			if _, err := fmt.Fprintf(w, "%s %d\n", series(h.name+"_bucket", bucketLabels), count); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n%s %d\n",
+			series(h.name+"_sum", labels), formatFloat(hist.sum), series(h.name+"_count", labels), count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests handled.", "route", "status")
+	h := r.NewHistogramVec("request_duration_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	c.Inc("/v1/zones", "200")
+	c.Add(2, "/v1/zones", "200")
+	c.Inc(`/v1/"quoted"`, "500")
+	h.Observe(0.05, "/v1/zones")
+	h.Observe(0.1, "/v1/zones")
+	h.Observe(5, "/v1/zones")
+
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{route="/v1/\"quoted\"",status="500"} 1
+requests_total{route="/v1/zones",status="200"} 3
+# HELP request_duration_seconds Request latency.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/v1/zones",le="0.1"} 2
+request_duration_seconds_bucket{route="/v1/zones",le="1"} 2
+request_duration_seconds_bucket{route="/v1/zones",le="+Inf"} 3
+request_duration_seconds_sum{route="/v1/zones"} 5.15
+request_duration_seconds_count{route="/v1/zones"} 3
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("exposition mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCounterVecPanicsOnWrongLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounterVec("requests_total", "Requests handled.", "route")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+
+	c.Inc("/v1/zones", "200")
+}
+
+func TestCounterVecDeletePartialMatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("bytes_total", "Bytes.", "zone", "host", "direction")
+	c.Inc("zone-a", "foo", "request")
+	c.Inc("zone-a", "foo", "response")
+	c.Inc("zone-a", "bar", "request")
+	c.Inc("zone-b", "foo", "request")
+
+	if n := c.DeletePartialMatch(map[string]string{"zone": "zone-a", "host": "foo"}); n != 2 {
+		t.Errorf("expected 2 counters deleted, got %d", n)
+	}
+
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP bytes_total Bytes.
+# TYPE bytes_total counter
+bytes_total{zone="zone-a",host="bar",direction="request"} 1
+bytes_total{zone="zone-b",host="foo",direction="request"} 1
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("exposition mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestCounterVecDropsIdleCounters(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("bytes_total", "Bytes.", "host").WithIdleTimeout(50 * time.Millisecond)
+	c.Inc("foo")
+	time.Sleep(100 * time.Millisecond)
+	c.Inc("bar")
+
+	var sb strings.Builder
+	if err := r.Write(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP bytes_total Bytes.
+# TYPE bytes_total counter
+bytes_total{host="bar"} 1
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("exposition mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ratelimit limits the rate of the requests made by each user with token buckets.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type LimitConfig struct {
+	// Sustained number of requests per second allowed for each user.
+	RequestsPerSecond float64
+	// Number of requests a user can make at once after being idle, RequestsPerSecond rounded up if zero.
+	Burst int
+}
+
+type Config struct {
+	// Limits of the requests served by the cloud orchestrator itself, e.g. creating or listing hosts. No limit
+	// if nil.
+	ControlPlane *LimitConfig
+	// Limits of the requests proxied to the host orchestrators. No limit if nil.
+	Proxy *LimitConfig
+	// Maximum size in bytes of the JSON bodies of the control plane requests, 1MiB if zero.
+	MaxRequestBodyBytes int64
+}
+
+const DefaultMaxRequestBodyBytes = 1 << 20
+
+func (c *Config) RequestBodyLimit() int64 {
+	if c.MaxRequestBodyBytes > 0 {
+		return c.MaxRequestBodyBytes
+	}
+	return DefaultMaxRequestBodyBytes
+}
+
+// Buckets are dropped once they are full again, after being idle for this long at least.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Keeps a token bucket for each key.
+type Limiter struct {
+	rate  float64
+	burst float64
+	// Replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Returns nil, meaning no limit, if the config is nil or the rate isn't positive.
+func NewLimiter(cfg *LimitConfig) *Limiter {
+	if cfg == nil || cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(cfg.Burst)
+	if burst <= 0 {
+		burst = math.Ceil(cfg.RequestsPerSecond)
+	}
+	return &Limiter{
+		rate:    cfg.RequestsPerSecond,
+		burst:   burst,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Takes a token from the key's bucket. If the bucket is empty it returns false and how long until a token is
+// available. A nil limiter allows everything.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// Drops the buckets that became full again, they are equivalent to new ones. Must be called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestLimiter(cfg *LimitConfig) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l := NewLimiter(cfg)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLimiterAllowsBurstThenRate(t *testing.T) {
+	l, clock := newTestLimiter(&LimitConfig{RequestsPerSecond: 2, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("johndoe"); !ok {
+			t.Fatalf("request %d: expected to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("johndoe")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("expected to wait 500ms, got: %t %s", ok, wait)
+	}
+	if ok, _ := l.Allow("janedoe"); !ok {
+		t.Error("expected other users not to be limited")
+	}
+	clock.t = clock.t.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("johndoe"); !ok {
+		t.Error("expected to be allowed after waiting")
+	}
+}
+
+func TestLimiterDropsFullBuckets(t *testing.T) {
+	l, clock := newTestLimiter(&LimitConfig{RequestsPerSecond: 1})
+	l.Allow("johndoe")
+
+	clock.t = clock.t.Add(sweepInterval)
+	l.Allow("janedoe")
+
+	if _, ok := l.buckets["johndoe"]; ok {
+		t.Error("expected bucket to be dropped")
+	}
+}
+
+func TestNilLimiterAllowsEverything(t *testing.T) {
+	l := NewLimiter(nil)
+
+	if ok, _ := l.Allow("johndoe"); !ok {
+		t.Error("expected to be allowed")
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 	"time"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	client "github.com/google/cloud-android-orchestration/pkg/client"
 	wclient "github.com/google/cloud-android-orchestration/pkg/webrtcclient"
 
@@ -67,17 +68,36 @@ const (
 	serviceURLFlag = "service_url"
 	zoneFlag       = "zone"
 	httpProxyFlag  = "http_proxy"
+	apiTokenFlag   = "api_token_file"
 	verboseFlag    = "verbose"
 )
 
 const (
 	gcpMachineTypeFlag    = "gcp_machine_type"
 	gcpMinCPUPlatformFlag = "gcp_min_cpu_platform"
+	gcpBootDiskSizeFlag   = "gcp_boot_disk_size_gb"
+	gcpSubnetworkFlag     = "gcp_subnetwork"
+	gcpNetworkTagFlag     = "gcp_network_tag"
+	gcpNoExternalIPFlag   = "gcp_no_external_ip"
+	gcpSpotFlag           = "gcp_spot"
+	ttlFlag               = "ttl"
+	statusFlag            = "status"
+	labelFlag             = "label"
+	selectorFlag          = "selector"
+	groupFlag             = "group"
 )
 
 const (
 	gcpMachineTypeFlagDesc    = "Indicates the machine type"
 	gcpMinCPUPlatformFlagDesc = "Specifies a minimum CPU platform for the VM instance"
+	gcpBootDiskSizeFlagDesc   = "Boot disk size in GB, the service default is used if zero"
+	gcpSubnetworkFlagDesc     = "Subnetwork to attach the VM instance to, the service default is used if empty"
+	gcpNetworkTagFlagDesc     = "Network tag to add to the VM instance, can be repeated"
+	gcpNoExternalIPFlagDesc   = "Create the VM instance without an external IP address"
+	gcpSpotFlagDesc           = "Create the VM instance as a Spot VM"
+	ttlFlagDesc               = "Time after which the host is automatically deleted, e.g. 8h. Zero means never"
+	labelFlagDesc             = "Label to set on the host as key=value, can be repeated"
+	groupFlagDesc             = "Group to own the host, its members can use and delete the host"
 )
 
 const (
@@ -119,7 +139,9 @@ type CVDRemoteFlags struct {
 	ServiceURL string
 	Zone       string
 	HTTPProxy  string
-	Verbose    bool
+	// Path of the file holding the API token sent with every request.
+	APITokenFile string
+	Verbose      bool
 }
 
 func (f *CVDRemoteFlags) AsArgs() []string {
@@ -130,6 +152,9 @@ func (f *CVDRemoteFlags) AsArgs() []string {
 	if f.HTTPProxy != "" {
 		args = append(args, "--"+httpProxyFlag, f.HTTPProxy)
 	}
+	if f.APITokenFile != "" {
+		args = append(args, "--"+apiTokenFlag, f.APITokenFile)
+	}
 	if f.Verbose {
 		args = append(args, "-v")
 	}
@@ -147,6 +172,17 @@ type CreateCVDFlags struct {
 	*CreateHostOpts
 }
 
+type ListHostsFlags struct {
+	*CVDRemoteFlags
+	Status   string
+	Selector string
+}
+
+type ExtendHostFlags struct {
+	*CVDRemoteFlags
+	TTL time.Duration
+}
+
 type ListCVDsFlags struct {
 	*CVDRemoteFlags
 	Host string
@@ -315,9 +351,11 @@ func NewCVDRemoteCommand(o *CommandOptions) *CVDRemoteCommand {
 		// Make it required if not configured
 		rootCmd.MarkPersistentFlagRequired(serviceURLFlag)
 	}
-	rootCmd.PersistentFlags().StringVar(&flags.Zone, zoneFlag, o.InitialConfig.Zone, "Cloud zone.")
+	rootCmd.PersistentFlags().StringVar(&flags.Zone, zoneFlag, o.InitialConfig.Zone, "Cloud zone new hosts are created in.")
 	rootCmd.PersistentFlags().StringVar(&flags.HTTPProxy, httpProxyFlag, o.InitialConfig.HTTPProxy,
 		"Proxy used to route the http communication through.")
+	rootCmd.PersistentFlags().StringVar(&flags.APITokenFile, apiTokenFlag, o.InitialConfig.APITokenFile,
+		"File holding an API token to authenticate with, for non-interactive clients such as CI.")
 	// Do not show a `help` command, users have always the `-h` and `--help` flags for help purpose.
 	rootCmd.SetHelpCommand(&cobra.Command{Hidden: true})
 	rootCmd.PersistentFlags().BoolVarP(&flags.Verbose, verboseFlag, "v", false, "Be verbose.")
@@ -369,13 +407,25 @@ func hostCommand(opts *subCommandOpts) *cobra.Command {
 		opts.InitialConfig.Host.GCP.MachineType, gcpMachineTypeFlagDesc)
 	create.Flags().StringVar(&createFlags.GCP.MinCPUPlatform, gcpMinCPUPlatformFlag,
 		opts.InitialConfig.Host.GCP.MinCPUPlatform, gcpMinCPUPlatformFlagDesc)
+	create.Flags().Int64Var(&createFlags.GCP.BootDiskSizeGB, gcpBootDiskSizeFlag, 0, gcpBootDiskSizeFlagDesc)
+	create.Flags().StringVar(&createFlags.GCP.Subnetwork, gcpSubnetworkFlag, "", gcpSubnetworkFlagDesc)
+	create.Flags().StringSliceVar(&createFlags.GCP.NetworkTags, gcpNetworkTagFlag, nil, gcpNetworkTagFlagDesc)
+	create.Flags().BoolVar(&createFlags.GCP.NoExternalIP, gcpNoExternalIPFlag, false, gcpNoExternalIPFlagDesc)
+	create.Flags().BoolVar(&createFlags.GCP.Spot, gcpSpotFlag, false, gcpSpotFlagDesc)
+	create.Flags().DurationVar(&createFlags.TTL, ttlFlag, 0, ttlFlagDesc)
+	create.Flags().StringToStringVar(&createFlags.Labels, labelFlag, nil, labelFlagDesc)
+	create.Flags().StringVar(&createFlags.Group, groupFlag, "", groupFlagDesc)
+	listFlags := &ListHostsFlags{CVDRemoteFlags: opts.RootFlags}
 	list := &cobra.Command{
 		Use:   "list",
 		Short: "Lists hosts.",
 		RunE: func(c *cobra.Command, args []string) error {
-			return runListHostCommand(c, opts.RootFlags, opts)
+			return runListHostCommand(c, listFlags, opts)
 		},
 	}
+	list.Flags().StringVar(&listFlags.Status, statusFlag, "", "Only lists hosts with the given status, e.g. RUNNING")
+	list.Flags().StringVar(&listFlags.Selector, selectorFlag, "",
+		"Only lists hosts matching the label selector, e.g. team=camera,ci-run!=1234")
 	del := &cobra.Command{
 		Use:   "delete <foo> <bar> <baz>",
 		Short: "Delete hosts.",
@@ -383,6 +433,17 @@ func hostCommand(opts *subCommandOpts) *cobra.Command {
 			return runDeleteHostsCommand(c, args, opts.RootFlags, opts)
 		},
 	}
+	extendFlags := &ExtendHostFlags{CVDRemoteFlags: opts.RootFlags}
+	extend := &cobra.Command{
+		Use:   "extend <foo>",
+		Short: "Sets a new time to live for a host, counted from now.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			return runExtendHostCommand(c, args[0], extendFlags, opts)
+		},
+	}
+	extend.Flags().DurationVar(&extendFlags.TTL, ttlFlag, 0, "New time to live of the host, e.g. 8h")
+	extend.MarkFlagRequired(ttlFlag)
 	host := &cobra.Command{
 		Use:   "host",
 		Short: "Work with hosts",
@@ -390,9 +451,43 @@ func hostCommand(opts *subCommandOpts) *cobra.Command {
 	host.AddCommand(create)
 	host.AddCommand(list)
 	host.AddCommand(del)
+	host.AddCommand(extend)
+	for _, cmd := range hostOperationCommands(opts) {
+		host.AddCommand(cmd)
+	}
 	return host
 }
 
+type hostOperation struct {
+	Name  string
+	Short string
+	Run   func(client.Service, string) (*apiv1.HostInstance, error)
+	// Whether the devices in the host become unreachable after the operation.
+	Disconnects bool
+}
+
+func hostOperationCommands(opts *subCommandOpts) []*cobra.Command {
+	ops := []hostOperation{
+		{Name: "stop", Short: "Stops hosts, they keep their disk but can't be used until started again.", Run: client.Service.StopHost, Disconnects: true},
+		{Name: "start", Short: "Starts stopped hosts.", Run: client.Service.StartHost},
+		{Name: "suspend", Short: "Suspends hosts, preserving their memory state.", Run: client.Service.SuspendHost, Disconnects: true},
+		{Name: "resume", Short: "Resumes suspended hosts.", Run: client.Service.ResumeHost},
+	}
+	var cmds []*cobra.Command
+	for _, op := range ops {
+		op := op
+		cmds = append(cmds, &cobra.Command{
+			Use:   op.Name + " <foo> <bar> <baz>",
+			Short: op.Short,
+			Args:  cobra.MinimumNArgs(1),
+			RunE: func(c *cobra.Command, args []string) error {
+				return runHostOperationCommand(c, args, op, opts.RootFlags, opts)
+			},
+		})
+	}
+	return cmds
+}
+
 func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 	// Create command
 	createFlags := &CreateCVDFlags{
@@ -468,6 +563,12 @@ func cvdCommands(opts *subCommandOpts) []*cobra.Command {
 		create.Flags().StringVar(f.ValueRef, name, f.Default, f.Desc)
 		create.MarkFlagsMutuallyExclusive(hostFlag, name)
 	}
+	create.Flags().DurationVar(&createFlags.CreateHostOpts.TTL, "host_"+ttlFlag, 0, ttlFlagDesc)
+	create.MarkFlagsMutuallyExclusive(hostFlag, "host_"+ttlFlag)
+	create.Flags().StringToStringVar(&createFlags.CreateHostOpts.Labels, "host_"+labelFlag, nil, labelFlagDesc)
+	create.MarkFlagsMutuallyExclusive(hostFlag, "host_"+labelFlag)
+	create.Flags().StringVar(&createFlags.CreateHostOpts.Group, "host_"+groupFlag, "", groupFlagDesc)
+	create.MarkFlagsMutuallyExclusive(hostFlag, "host_"+groupFlag)
 	// List command
 	listFlags := &ListCVDsFlags{CVDRemoteFlags: opts.RootFlags}
 	list := &cobra.Command{
@@ -534,25 +635,68 @@ func runCreateHostCommand(c *cobra.Command, flags *CreateHostFlags, opts *subCom
 	if err != nil {
 		return fmt.Errorf("Failed to create host: %w", err)
 	}
-	c.Printf("%s\n", ins.Name)
+	c.Printf("%s\n", hostID(ins))
 	return nil
 }
 
-func runListHostCommand(c *cobra.Command, flags *CVDRemoteFlags, opts *subCommandOpts) error {
-	apiClient, err := opts.ServiceBuilder(flags, c)
+func runListHostCommand(c *cobra.Command, flags *ListHostsFlags, opts *subCommandOpts) error {
+	apiClient, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
 	if err != nil {
 		return err
 	}
-	hosts, err := apiClient.ListHosts()
+	listOpts := client.ListHostsOpts{
+		Status:        strings.ToUpper(flags.Status),
+		LabelSelector: flags.Selector,
+	}
+	hosts, err := apiClient.ListHosts(listOpts)
 	if err != nil {
 		return fmt.Errorf("Error listing hosts: %w", err)
 	}
 	for _, ins := range hosts.Items {
-		c.Printf("%s\n", ins.Name)
+		c.Println(HostToPrintableStr(ins))
+	}
+	return nil
+}
+
+func runExtendHostCommand(c *cobra.Command, host string, flags *ExtendHostFlags, opts *subCommandOpts) error {
+	if flags.TTL <= 0 {
+		return fmt.Errorf("Invalid --%s flag value: %s", ttlFlag, flags.TTL)
+	}
+	service, err := opts.ServiceBuilder(flags.CVDRemoteFlags, c)
+	if err != nil {
+		return err
 	}
+	req := &apiv1.ExtendHostRequest{TTLSeconds: int64(flags.TTL.Seconds())}
+	ins, err := service.ExtendHost(host, req)
+	if err != nil {
+		return fmt.Errorf("Failed to extend host: %w", err)
+	}
+	c.Printf("%s expires at %s\n", hostID(ins), ins.ExpirationTime)
 	return nil
 }
 
+func runHostOperationCommand(c *cobra.Command, args []string, op hostOperation, flags *CVDRemoteFlags, opts *subCommandOpts) error {
+	service, err := opts.ServiceBuilder(flags, c)
+	if err != nil {
+		return err
+	}
+	var merr error
+	for _, host := range args {
+		if op.Disconnects {
+			if err := disconnectDevicesByHost(host, opts); err != nil {
+				c.PrintErrf("Error disconecting devices for host %s: %v\n", host, err)
+			}
+		}
+		ins, err := op.Run(service, host)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("Failed to %s host %q: %w", op.Name, host, err))
+			continue
+		}
+		c.Printf("%s\n", hostID(ins))
+	}
+	return merr
+}
+
 func runDeleteHostsCommand(c *cobra.Command, args []string, flags *CVDRemoteFlags, opts *subCommandOpts) error {
 	service, err := opts.ServiceBuilder(flags, c)
 	if err != nil {
@@ -616,7 +760,7 @@ func runCreateCVDCommand(c *cobra.Command, args []string, flags *CreateCVDFlags,
 		if err != nil {
 			return fmt.Errorf("Failed to create host: %w", err)
 		}
-		flags.CreateCVDOpts.Host = ins.Name
+		flags.CreateCVDOpts.Host = hostID(ins)
 	}
 	cvds, err := createCVD(service, *flags.CreateCVDOpts, statePrinter)
 	if err != nil {
@@ -1034,9 +1178,15 @@ func buildServiceBuilder(builder client.ServiceBuilder) serviceBuilder {
 		if flags.Verbose {
 			dumpOut = c.ErrOrStderr()
 		}
+		apiToken, err := readAPIToken(flags.APITokenFile)
+		if err != nil {
+			return nil, err
+		}
 		opts := &client.ServiceOptions{
-			RootEndpoint:           buildServiceRootEndpoint(flags.ServiceURL, flags.Zone),
+			RootEndpoint:           buildServiceRootEndpoint(flags.ServiceURL),
+			Zone:                   flags.Zone,
 			ProxyURL:               proxyURL,
+			APIToken:               apiToken,
 			DumpOut:                dumpOut,
 			ErrOut:                 c.ErrOrStderr(),
 			RetryAttempts:          3,
@@ -1048,13 +1198,29 @@ func buildServiceBuilder(builder client.ServiceBuilder) serviceBuilder {
 	}
 }
 
+// Returns the token in the given file, the empty string if no file is given.
+func readAPIToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(expandPath(path))
+	if err != nil {
+		return "", fmt.Errorf("failed reading API token: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("empty API token file: %q", path)
+	}
+	return token, nil
+}
+
 func notImplementedCommand(c *cobra.Command, _ []string) error {
 	return fmt.Errorf("Command not implemented")
 }
 
-func buildServiceRootEndpoint(serviceURL, zone string) string {
+func buildServiceRootEndpoint(serviceURL string) string {
 	const version = "v1"
-	return client.BuildRootEndpoint(serviceURL, version, zone)
+	return client.BuildRootEndpoint(serviceURL, version, "")
 }
 
 // Prints out state changes.
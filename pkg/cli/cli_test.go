@@ -93,16 +93,50 @@ func (fakeService) CreateHost(req *apiv1.CreateHostRequest) (*apiv1.HostInstance
 	return &apiv1.HostInstance{Name: "foo"}, nil
 }
 
-func (fakeService) ListHosts() (*apiv1.ListHostsResponse, error) {
-	return &apiv1.ListHostsResponse{
-		Items: []*apiv1.HostInstance{{Name: "foo"}, {Name: "bar"}},
-	}, nil
+func (fakeService) ListHosts(opts client.ListHostsOpts) (*apiv1.ListHostsResponse, error) {
+	hosts := []*apiv1.HostInstance{
+		{Name: "foo", Status: apiv1.HostStatusRunning},
+		{Name: "bar", Status: apiv1.HostStatusRunning, Labels: map[string]string{"team": "camera"}},
+		{Name: "baz", Status: apiv1.HostStatusStopped},
+	}
+	res := &apiv1.ListHostsResponse{}
+	for _, h := range hosts {
+		if opts.Status != "" && opts.Status != h.Status {
+			continue
+		}
+		// Only exact label matches are supported here.
+		if k, v, found := strings.Cut(opts.LabelSelector, "="); found && h.Labels[k] != v {
+			continue
+		}
+		res.Items = append(res.Items, h)
+	}
+	return res, nil
 }
 
 func (fakeService) DeleteHosts(name []string) error {
 	return nil
 }
 
+func (fakeService) ExtendHost(name string, req *apiv1.ExtendHostRequest) (*apiv1.HostInstance, error) {
+	return &apiv1.HostInstance{Name: name, ExpirationTime: "2023-01-01T08:00:00Z"}, nil
+}
+
+func (fakeService) StopHost(name string) (*apiv1.HostInstance, error) {
+	return &apiv1.HostInstance{Name: name}, nil
+}
+
+func (fakeService) StartHost(name string) (*apiv1.HostInstance, error) {
+	return &apiv1.HostInstance{Name: name}, nil
+}
+
+func (fakeService) SuspendHost(name string) (*apiv1.HostInstance, error) {
+	return &apiv1.HostInstance{Name: name}, nil
+}
+
+func (fakeService) ResumeHost(name string) (*apiv1.HostInstance, error) {
+	return &apiv1.HostInstance{Name: name}, nil
+}
+
 func (fakeService) GetInfraConfig(host string) (*apiv1.InfraConfig, error) {
 	return nil, nil
 }
@@ -156,10 +190,57 @@ func TestCommandSucceeds(t *testing.T) {
 			ExpOut: "foo\n",
 		},
 		{
-			Name:   "host list",
-			Args:   []string{"host", "list"},
+			Name: "host list",
+			Args: []string{"host", "list"},
+			ExpOut: hostOutput(&apiv1.HostInstance{Name: "foo", Status: apiv1.HostStatusRunning}) +
+				hostOutput(&apiv1.HostInstance{Name: "bar", Status: apiv1.HostStatusRunning, Labels: map[string]string{"team": "camera"}}) +
+				hostOutput(&apiv1.HostInstance{Name: "baz", Status: apiv1.HostStatusStopped}),
+		},
+		{
+			Name:   "host list with --selector",
+			Args:   []string{"host", "list", "--selector=team=camera"},
+			ExpOut: hostOutput(&apiv1.HostInstance{Name: "bar", Status: apiv1.HostStatusRunning, Labels: map[string]string{"team": "camera"}}),
+		},
+		{
+			Name:   "host create with --label",
+			Args:   []string{"host", "create", "--label=team=camera", "--label=ci-run=1234"},
+			ExpOut: "foo\n",
+		},
+		{
+			Name:   "host list with --status",
+			Args:   []string{"host", "list", "--status=stopped"},
+			ExpOut: hostOutput(&apiv1.HostInstance{Name: "baz", Status: apiv1.HostStatusStopped}),
+		},
+		{
+			Name:   "host create with --ttl",
+			Args:   []string{"host", "create", "--ttl=8h"},
+			ExpOut: "foo\n",
+		},
+		{
+			Name:   "host extend",
+			Args:   []string{"host", "extend", "foo", "--ttl=8h"},
+			ExpOut: "foo expires at 2023-01-01T08:00:00Z\n",
+		},
+		{
+			Name:   "host stop",
+			Args:   []string{"host", "stop", "foo", "bar"},
 			ExpOut: "foo\nbar\n",
 		},
+		{
+			Name:   "host start",
+			Args:   []string{"host", "start", "foo"},
+			ExpOut: "foo\n",
+		},
+		{
+			Name:   "host suspend",
+			Args:   []string{"host", "suspend", "foo"},
+			ExpOut: "foo\n",
+		},
+		{
+			Name:   "host resume",
+			Args:   []string{"host", "resume", "foo"},
+			ExpOut: "foo\n",
+		},
 		{
 			Name:   "host delete",
 			Args:   []string{"host", "delete", "foo", "bar"},
@@ -228,6 +309,7 @@ func TestBuildAgentCmdline(t *testing.T) {
 			"service url",
 			"zone",
 			"http proxy",
+			"api token file",
 			true, // verbose
 		},
 		host:             "host",
@@ -265,6 +347,10 @@ func newTestIOStreams() (IOStreams, *bytes.Buffer, *bytes.Buffer) {
 	}, in, out
 }
 
+func hostOutput(h *apiv1.HostInstance) string {
+	return HostToPrintableStr(h) + "\n"
+}
+
 func cvdOutput(serviceURL, host string, cvd hoapi.CVD, port int) string {
 	out := &bytes.Buffer{}
 	cvdOut := NewRemoteCVD(fakeService{}.RootURI(), host, &cvd)
@@ -34,9 +34,11 @@ type HostConfig struct {
 }
 
 type Config struct {
-	ServiceURL           string
-	Zone                 string
-	HTTPProxy            string
+	ServiceURL string
+	Zone       string
+	HTTPProxy  string
+	// Path of the file holding the API token sent with every request, e.g. "~/.cvdr/api_token".
+	APITokenFile         string
 	ConnectionControlDir string
 	KeepLogFilesDays     int
 	Host                 HostConfig
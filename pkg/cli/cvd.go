@@ -21,6 +21,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/client"
 
 	hoapi "github.com/google/android-cuttlefish/frontend/src/liboperator/api/v1"
@@ -220,13 +221,13 @@ type cvdListResult struct {
 }
 
 func listAllCVDs(service client.Service, controlDir string) ([]*RemoteCVD, error) {
-	hl, err := service.ListHosts()
+	hl, err := service.ListHosts(client.ListHostsOpts{Status: apiv1.HostStatusRunning})
 	if err != nil {
 		return nil, fmt.Errorf("Error listing hosts: %w", err)
 	}
 	var hosts []string
 	for _, host := range hl.Items {
-		hosts = append(hosts, host.Name)
+		hosts = append(hosts, hostID(host))
 	}
 	var chans []chan cvdListResult
 	statuses, merr := listCVDConnections(controlDir)
@@ -15,17 +15,33 @@
 package cli
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	apiv1 "github.com/google/cloud-android-orchestration/api/v1"
 	"github.com/google/cloud-android-orchestration/pkg/client"
 )
 
 type CreateHostOpts struct {
 	GCP CreateGCPHostOpts
+	// The host is deleted automatically after this time, never if zero.
+	TTL time.Duration
+	// User defined labels.
+	Labels map[string]string
+	// Group owning the host, if any.
+	Group string
 }
 
 type CreateGCPHostOpts struct {
 	MachineType    string
 	MinCPUPlatform string
+	BootDiskSizeGB int64
+	Subnetwork     string
+	NetworkTags    []string
+	NoExternalIP   bool
+	Spot           bool
 }
 
 func createHost(service client.Service, opts CreateHostOpts) (*apiv1.HostInstance, error) {
@@ -34,20 +50,63 @@ func createHost(service client.Service, opts CreateHostOpts) (*apiv1.HostInstanc
 			GCP: &apiv1.GCPInstance{
 				MachineType:    opts.GCP.MachineType,
 				MinCPUPlatform: opts.GCP.MinCPUPlatform,
+				BootDiskSizeGB: opts.GCP.BootDiskSizeGB,
+				Subnetwork:     opts.GCP.Subnetwork,
+				NetworkTags:    opts.GCP.NetworkTags,
+				NoExternalIP:   opts.GCP.NoExternalIP,
+				Spot:           opts.GCP.Spot,
 			},
 		},
+		TTLSeconds: int64(opts.TTL.Seconds()),
+		Labels:     opts.Labels,
+		Group:      opts.Group,
 	}
 	return service.CreateHost(&req)
 }
 
+// Returns how the host is referred to in commands, its handle if known since it works in any zone.
+func hostID(h *apiv1.HostInstance) string {
+	if h.Handle != "" {
+		return h.Handle
+	}
+	return h.Name
+}
+
+func HostToPrintableStr(h *apiv1.HostInstance) string {
+	status := h.Status
+	if h.Status == apiv1.HostStatusRunning && !h.Ready {
+		status += ", not ready"
+	}
+	res := fmt.Sprintf("%s (%s)", hostID(h), status)
+	res += "\n  " + "Owner: " + h.Owner
+	if h.Group != "" {
+		res += "\n  " + "Group: " + h.Group
+	}
+	res += "\n  " + "Created: " + h.CreationTime
+	expires := h.ExpirationTime
+	if expires == "" {
+		expires = "never"
+	}
+	res += "\n  " + "Expires: " + expires
+	res += "\n  " + "Internal IP: " + h.InternalIP
+	res += "\n  " + "External IP: " + h.ExternalIP
+	var labels []string
+	for k, v := range h.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	res += "\n  " + "Labels: " + strings.Join(labels, ",")
+	return res
+}
+
 func hostnames(service client.Service) ([]string, error) {
-	hosts, err := service.ListHosts()
+	hosts, err := service.ListHosts(client.ListHostsOpts{Status: apiv1.HostStatusRunning})
 	if err != nil {
 		return nil, err
 	}
 	result := []string{}
 	for _, h := range hosts.Items {
-		result = append(result, h.Name)
+		result = append(result, hostID(h))
 	}
 	return result, nil
 }
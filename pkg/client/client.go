@@ -52,6 +52,8 @@ type ApiCallError struct {
 	Code     int    `json:"code,omitempty"`
 	ErrorMsg string `json:"error,omitempty"`
 	Details  string `json:"details,omitempty"`
+	// ID the server assigned to the failed request, useful to find it in the server logs.
+	RequestID string `json:"-"`
 }
 
 func (e *ApiCallError) Error() string {
@@ -59,6 +61,9 @@ func (e *ApiCallError) Error() string {
 	if e.Details != "" {
 		str += fmt.Sprintf("\n\nDETAILS: %s", e.Details)
 	}
+	if e.RequestID != "" {
+		str += fmt.Sprintf("\n\nREQUEST ID: %s", e.RequestID)
+	}
 	return str
 }
 
@@ -75,8 +80,14 @@ type BackOffOpts struct {
 }
 
 type ServiceOptions struct {
-	RootEndpoint           string
-	ProxyURL               string
+	// Service URL including the API version, e.g. `https://example.com/v1`.
+	RootEndpoint string
+	// Zone hosts are created in and host names are resolved in. Hosts can be referred to by handle regardless of
+	// it, hosts of every zone are listed.
+	Zone     string
+	ProxyURL string
+	// Sent as bearer token with every request if not empty, it authenticates non-interactive clients.
+	APIToken               string
 	DumpOut                io.Writer
 	ErrOut                 io.Writer
 	RetryAttempts          int
@@ -89,13 +100,32 @@ type ConnectWebRTCOpts struct {
 	LocalICEConfig *wclient.ICEConfig
 }
 
+type ListHostsOpts struct {
+	// Only hosts with this status are listed, hosts with any status are listed if empty.
+	Status string
+	// Comma separated list of label requirements, e.g. `team=camera,ci-run`.
+	LabelSelector string
+}
+
 type Service interface {
 	CreateHost(req *apiv1.CreateHostRequest) (*apiv1.HostInstance, error)
 
-	ListHosts() (*apiv1.ListHostsResponse, error)
+	// Lists the hosts in every zone.
+	ListHosts(opts ListHostsOpts) (*apiv1.ListHostsResponse, error)
 
 	DeleteHosts(names []string) error
 
+	// Sets a new time to live for the given host, counted from now.
+	ExtendHost(name string, req *apiv1.ExtendHostRequest) (*apiv1.HostInstance, error)
+
+	StopHost(name string) (*apiv1.HostInstance, error)
+
+	StartHost(name string) (*apiv1.HostInstance, error)
+
+	SuspendHost(name string) (*apiv1.HostInstance, error)
+
+	ResumeHost(name string) (*apiv1.HostInstance, error)
+
 	GetInfraConfig(host string) (*apiv1.InfraConfig, error)
 
 	ConnectWebRTC(host, device string, observer wclient.Observer, logger io.Writer, opts ConnectWebRTCOpts) (*wclient.Connection, error)
@@ -133,18 +163,45 @@ func NewService(opts *ServiceOptions) (Service, error) {
 		}
 		httpClient.Transport = &http.Transport{Proxy: http.ProxyURL(proxyUrl)}
 	}
+	if opts.APIToken != "" {
+		httpClient.Transport = &bearerTokenTransport{base: httpClient.Transport, token: opts.APIToken}
+	}
 	return &serviceImpl{
 		ServiceOptions: opts,
 		client:         httpClient,
 	}, nil
 }
 
+// Adds the `Authorization: Bearer` header to every request.
+type bearerTokenTransport struct {
+	// http.DefaultTransport if nil.
+	base  http.RoundTripper
+	token string
+}
+
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// Round trippers must not modify the request.
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return base.RoundTrip(req)
+}
+
+// Returned when creating hosts without a zone, there is no default zone to create them in.
+var ErrZoneRequired = errors.New("zone is required to create hosts")
+
 func (c *serviceImpl) CreateHost(req *apiv1.CreateHostRequest) (*apiv1.HostInstance, error) {
+	if c.Zone == "" {
+		return nil, ErrZoneRequired
+	}
 	var op apiv1.Operation
-	if err := c.doRequest("POST", "/hosts", req, &op); err != nil {
+	if err := c.doRequest("POST", zonePath(c.Zone)+"/hosts", req, &op); err != nil {
 		return nil, err
 	}
-	path := "/operations/" + op.Name + "/:wait"
+	path := zonePath(c.Zone) + "/operations/" + op.Name + "/:wait"
 	ins := &apiv1.HostInstance{}
 	if err := c.doRequest("POST", path, nil, ins); err != nil {
 		return nil, err
@@ -152,9 +209,20 @@ func (c *serviceImpl) CreateHost(req *apiv1.CreateHostRequest) (*apiv1.HostInsta
 	return ins, nil
 }
 
-func (c *serviceImpl) ListHosts() (*apiv1.ListHostsResponse, error) {
+func (c *serviceImpl) ListHosts(opts ListHostsOpts) (*apiv1.ListHostsResponse, error) {
+	query := url.Values{}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.LabelSelector != "" {
+		query.Set("labelSelector", opts.LabelSelector)
+	}
+	path := "/hosts"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
 	var res apiv1.ListHostsResponse
-	if err := c.doRequest("GET", "/hosts", nil, &res); err != nil {
+	if err := c.doRequest("GET", path, nil, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
@@ -168,7 +236,7 @@ func (c *serviceImpl) DeleteHosts(names []string) error {
 		wg.Add(1)
 		go func(name string) {
 			defer wg.Done()
-			if err := c.doRequest("DELETE", "/hosts/"+name, nil, nil); err != nil {
+			if err := c.doRequest("DELETE", c.hostPath(name), nil, nil); err != nil {
 				mu.Lock()
 				defer mu.Unlock()
 				merr = multierror.Append(merr, fmt.Errorf("Delete host %q failed: %w", name, err))
@@ -179,9 +247,50 @@ func (c *serviceImpl) DeleteHosts(names []string) error {
 	return merr
 }
 
+func (c *serviceImpl) ExtendHost(name string, req *apiv1.ExtendHostRequest) (*apiv1.HostInstance, error) {
+	return c.doHostOperation(name, "extend", req)
+}
+
+func (c *serviceImpl) StopHost(name string) (*apiv1.HostInstance, error) {
+	return c.doHostOperation(name, "stop", nil)
+}
+
+func (c *serviceImpl) StartHost(name string) (*apiv1.HostInstance, error) {
+	return c.doHostOperation(name, "start", nil)
+}
+
+func (c *serviceImpl) SuspendHost(name string) (*apiv1.HostInstance, error) {
+	return c.doHostOperation(name, "suspend", nil)
+}
+
+func (c *serviceImpl) ResumeHost(name string) (*apiv1.HostInstance, error) {
+	return c.doHostOperation(name, "resume", nil)
+}
+
+// Runs the given custom method on the host and waits for the resulting operation to be done.
+func (c *serviceImpl) doHostOperation(name, method string, req any) (*apiv1.HostInstance, error) {
+	zone := c.Zone
+	if z, _, ok := apiv1.ParseHostHandle(name); ok {
+		zone = z
+	}
+	if zone == "" {
+		return nil, fmt.Errorf("zone is required to %s host %q, set the zone or use the host handle", method, name)
+	}
+	var op apiv1.Operation
+	if err := c.doRequest("POST", c.hostPath(name)+"/:"+method, req, &op); err != nil {
+		return nil, err
+	}
+	path := zonePath(zone) + "/operations/" + op.Name + "/:wait"
+	ins := &apiv1.HostInstance{}
+	if err := c.doRequest("POST", path, nil, ins); err != nil {
+		return nil, err
+	}
+	return ins, nil
+}
+
 func (c *serviceImpl) GetInfraConfig(host string) (*apiv1.InfraConfig, error) {
 	var res apiv1.InfraConfig
-	if err := c.doRequest("GET", fmt.Sprintf("/hosts/%s/infra_config", host), nil, &res); err != nil {
+	if err := c.doRequest("GET", c.hostPath(host)+"/infra_config", nil, &res); err != nil {
 		return nil, err
 	}
 	return &res, nil
@@ -210,7 +319,7 @@ func (c *serviceImpl) ConnectWebRTC(host, device string, observer wclient.Observ
 }
 
 func (c *serviceImpl) createPolledConnection(host, device string) (*apiv1.NewConnReply, error) {
-	path := fmt.Sprintf("/hosts/%s/polled_connections", host)
+	path := c.hostPath(host) + "/polled_connections"
 	req := apiv1.NewConnMsg{DeviceId: device}
 	var res apiv1.NewConnReply
 	if err := c.doRequest("POST", path, &req, &res); err != nil {
@@ -249,7 +358,7 @@ func (c *serviceImpl) webRTCPoll(sinkCh chan map[string]any, host, connID string
 	pollInterval := initialPollInterval
 	errCount := 0
 	for {
-		path := fmt.Sprintf("/hosts/%s/polled_connections/%s/messages?start=%d", host, connID, start)
+		path := fmt.Sprintf("%s/polled_connections/%s/messages?start=%d", c.hostPath(host), connID, start)
 		var messages []map[string]any
 		if err := c.doRequest("GET", path, nil, &messages); err != nil {
 			fmt.Fprintf(c.ErrOut, "Error polling messages: %v\n", err)
@@ -298,7 +407,7 @@ func (c *serviceImpl) webRTCForward(srcCh chan any, host, connID string, stopPol
 			break
 		}
 		forwardMsg := apiv1.ForwardMsg{Payload: msg}
-		path := fmt.Sprintf("/hosts/%s/polled_connections/%s/:forward", host, connID)
+		path := fmt.Sprintf("%s/polled_connections/%s/:forward", c.hostPath(host), connID)
 		i := 0
 		for ; i < maxConsecutiveErrors; i++ {
 			if err := c.doRequest("POST", path, &forwardMsg, nil); err != nil {
@@ -327,6 +436,9 @@ func asWebRTCICEServers(in []apiv1.IceServer) []webrtc.ICEServer {
 
 const headerNameCOInjectBuildAPICreds = "X-Cutf-Cloud-Orchestrator-Inject-BuildAPI-Creds"
 
+// Header set by the cloud orchestrator in every response.
+const headerNameRequestID = "X-Request-Id"
+
 func (c *serviceImpl) FetchArtifacts(
 	host string, req *hoapi.FetchArtifactsRequest) (*hoapi.FetchArtifactsResponse, error) {
 	reqOpts := requestOpts{
@@ -334,10 +446,10 @@ func (c *serviceImpl) FetchArtifacts(
 		Header: http.Header{headerNameCOInjectBuildAPICreds: []string{""}},
 	}
 	var op hoapi.Operation
-	if err := c.doRequestWithOpts("POST", "/hosts/"+host+"/artifacts", req, &op, reqOpts); err != nil {
+	if err := c.doRequestWithOpts("POST", c.hostPath(host)+"/artifacts", req, &op, reqOpts); err != nil {
 		return nil, err
 	}
-	path := "/hosts/" + host + "/operations/" + op.Name + "/:wait"
+	path := c.hostPath(host) + "/operations/" + op.Name + "/:wait"
 	res := &hoapi.FetchArtifactsResponse{}
 	if err := c.doRequest("POST", path, nil, res); err != nil {
 		return nil, err
@@ -351,10 +463,10 @@ func (c *serviceImpl) CreateCVD(host string, req *hoapi.CreateCVDRequest) (*hoap
 		Header: http.Header{headerNameCOInjectBuildAPICreds: []string{""}},
 	}
 	var op hoapi.Operation
-	if err := c.doRequestWithOpts("POST", "/hosts/"+host+"/cvds", req, &op, reqOpts); err != nil {
+	if err := c.doRequestWithOpts("POST", c.hostPath(host)+"/cvds", req, &op, reqOpts); err != nil {
 		return nil, err
 	}
-	path := "/hosts/" + host + "/operations/" + op.Name + "/:wait"
+	path := c.hostPath(host) + "/operations/" + op.Name + "/:wait"
 	res := &hoapi.CreateCVDResponse{}
 	if err := c.doRequest("POST", path, nil, res); err != nil {
 		return nil, err
@@ -364,14 +476,14 @@ func (c *serviceImpl) CreateCVD(host string, req *hoapi.CreateCVDRequest) (*hoap
 
 func (c *serviceImpl) ListCVDs(host string) ([]*hoapi.CVD, error) {
 	var res hoapi.ListCVDsResponse
-	if err := c.doRequest("GET", "/hosts/"+host+"/cvds", nil, &res); err != nil {
+	if err := c.doRequest("GET", c.hostPath(host)+"/cvds", nil, &res); err != nil {
 		return nil, err
 	}
 	return res.CVDs, nil
 }
 
 func (c *serviceImpl) DownloadRuntimeArtifacts(host string, dst io.Writer) error {
-	req, err := http.NewRequest("POST", c.RootEndpoint+"/hosts/"+host+"/runtimeartifacts/:pull", nil)
+	req, err := http.NewRequest("POST", c.RootEndpoint+c.hostPath(host)+"/runtimeartifacts/:pull", nil)
 	if err != nil {
 		return err
 	}
@@ -384,14 +496,14 @@ func (c *serviceImpl) DownloadRuntimeArtifacts(host string, dst io.Writer) error
 		return err
 	}
 	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return &ApiCallError{ErrorMsg: res.Status}
+		return &ApiCallError{ErrorMsg: res.Status, RequestID: res.Header.Get(headerNameRequestID)}
 	}
 	return nil
 }
 
 func (c *serviceImpl) CreateUpload(host string) (string, error) {
 	uploadDir := &hoapi.UploadDirectory{}
-	if err := c.doRequest("POST", "/hosts/"+host+"/userartifacts", nil, uploadDir); err != nil {
+	if err := c.doRequest("POST", c.hostPath(host)+"/userartifacts", nil, uploadDir); err != nil {
 		return "", err
 	}
 	return uploadDir.Name, nil
@@ -403,7 +515,7 @@ func (c *serviceImpl) UploadFiles(host, uploadDir string, filenames []string) er
 	}
 	uploader := &filesUploader{
 		Client:         c.client,
-		EndpointURL:    c.RootEndpoint + "/hosts/" + host + "/userartifacts/" + uploadDir,
+		EndpointURL:    c.RootEndpoint + c.hostPath(host) + "/userartifacts/" + uploadDir,
 		Filenames:      filenames,
 		ChunkSizeBytes: c.ChunkSizeBytes,
 		DumpOut:        c.DumpOut,
@@ -452,13 +564,23 @@ func (c *serviceImpl) doRequestWithOpts(method, path string, reqpl, respl any, o
 	if err != nil {
 		return fmt.Errorf("Error sending request: %w", err)
 	}
-	for i := 0; i < c.RetryAttempts && isRetryableErrorCode(res.StatusCode); i++ {
+	for i := 0; i < c.RetryAttempts; i++ {
+		delay, ok := c.retryDelay(res)
+		if !ok {
+			break
+		}
 		err = dumpResponse(res, c.DumpOut)
 		res.Body.Close()
 		if err != nil {
 			return fmt.Errorf("Error dumping response: %w", err)
 		}
-		time.Sleep(c.RetryDelay)
+		time.Sleep(delay)
+		// The body was consumed by the previous attempt.
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return fmt.Errorf("Error creating request: %w", err)
+			}
+		}
 		if res, err = c.client.Do(req); err != nil {
 			return fmt.Errorf("Error sending request: %w", err)
 		}
@@ -471,12 +593,13 @@ func (c *serviceImpl) doRequestWithOpts(method, path string, reqpl, respl any, o
 	if res.StatusCode < 200 || res.StatusCode > 299 {
 		// DELETE responses do not have a body.
 		if method == "DELETE" {
-			return &ApiCallError{ErrorMsg: res.Status}
+			return &ApiCallError{ErrorMsg: res.Status, RequestID: res.Header.Get(headerNameRequestID)}
 		}
 		errpl := new(ApiCallError)
 		if err := dec.Decode(errpl); err != nil {
 			return fmt.Errorf("Error decoding response: %w", err)
 		}
+		errpl.RequestID = res.Header.Get(headerNameRequestID)
 		return errpl
 	}
 	if respl != nil {
@@ -488,7 +611,22 @@ func (c *serviceImpl) doRequestWithOpts(method, path string, reqpl, respl any, o
 }
 
 func (s *serviceImpl) RootURI() string {
-	return s.RootEndpoint
+	return s.RootEndpoint + zonePath(s.Zone)
+}
+
+// Returns the path of the given host, which can be a host name or a host handle.
+func (c *serviceImpl) hostPath(host string) string {
+	if _, _, ok := apiv1.ParseHostHandle(host); ok {
+		return "/hosts/" + host
+	}
+	return zonePath(c.Zone) + "/hosts/" + host
+}
+
+func zonePath(zone string) string {
+	if zone == "" {
+		return ""
+	}
+	return "/zones/" + zone
 }
 
 const openConnections = 32
@@ -638,9 +776,12 @@ func (w *uploadChunkWorker) Start() <-chan error {
 				duration := b.NextBackOff()
 				if duration == backoff.Stop {
 					break
-				} else {
-					time.Sleep(duration)
 				}
+				var rl *retryLaterError
+				if errors.As(err, &rl) && rl.retryAfter > duration {
+					duration = rl.retryAfter
+				}
+				time.Sleep(duration)
 			}
 			ch <- err
 		}
@@ -684,11 +825,29 @@ func (w *uploadChunkWorker) upload(job uploadChunkJob) error {
 	if res.StatusCode != 200 {
 		const msg = "Failed uploading file chunk with status code %q. " +
 			"File %q, chunk number: %d, chunk total: %d."
-		return fmt.Errorf(msg, res.Status, filepath.Base(job.Filename), job.ChunkNumber, job.TotalChunks)
+		err := fmt.Errorf(msg, res.Status, filepath.Base(job.Filename), job.ChunkNumber, job.TotalChunks)
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			return &retryLaterError{err: err, retryAfter: retryAfter}
+		}
+		return err
 	}
 	return nil
 }
 
+// Error of the requests the server asked to retry later.
+type retryLaterError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryLaterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryLaterError) Unwrap() error {
+	return e.err
+}
+
 func writeMultipartRequest(writer *multipart.Writer, job uploadChunkJob) error {
 	file, err := os.Open(job.Filename)
 	if err != nil {
@@ -774,6 +933,44 @@ func isRetryableErrorCode(code int) bool {
 		code == http.StatusBadGateway
 }
 
+// Longest Retry-After honored, requests asked to wait longer fail right away.
+const maxRetryAfter = time.Minute
+
+// Returns how long to wait before retrying the request that got the given response, false if it shouldn't be
+// retried. The Retry-After header takes precedence over the configured delay, rate limited requests are only
+// retried if the header is present.
+func (c *serviceImpl) retryDelay(res *http.Response) (time.Duration, bool) {
+	retryAfter, hasRetryAfter := parseRetryAfter(res.Header.Get("Retry-After"))
+	if hasRetryAfter && retryAfter > maxRetryAfter {
+		return 0, false
+	}
+	switch {
+	case hasRetryAfter && (res.StatusCode == http.StatusTooManyRequests || isRetryableErrorCode(res.StatusCode)):
+		return retryAfter, true
+	case isRetryableErrorCode(res.StatusCode):
+		return c.RetryDelay, true
+	default:
+		return 0, false
+	}
+}
+
+// Parses the value of a Retry-After header, either a number of seconds or a date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
 func DefaultChunkUploadBackOffOpts() BackOffOpts {
 	return BackOffOpts{
 		InitialDuration:     500 * time.Millisecond,
@@ -16,6 +16,7 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -39,9 +40,9 @@ func TestRetryLogic(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		opName := "op-foo"
 		switch ep := r.Method + " " + r.URL.Path; ep {
-		case "POST /hosts":
+		case "POST /zones/us-central1-a/hosts":
 			writeOK(w, &apiv1.Operation{Name: opName})
-		case "POST /operations/" + opName + "/:wait":
+		case "POST /zones/us-central1-a/operations/" + opName + "/:wait":
 			if failsCounter < failsTotal {
 				failsCounter++
 				writeErr(w, 503)
@@ -56,6 +57,7 @@ func TestRetryLogic(t *testing.T) {
 	defer ts.Close()
 	opts := &ServiceOptions{
 		RootEndpoint:  ts.URL,
+		Zone:          "us-central1-a",
 		DumpOut:       io.Discard,
 		RetryAttempts: 2,
 		RetryDelay:    100 * time.Millisecond,
@@ -75,6 +77,71 @@ func TestRetryLogic(t *testing.T) {
 	}
 }
 
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if len(bodies) == 1 {
+			w.Header().Set("Retry-After", "1")
+			writeErr(w, http.StatusTooManyRequests)
+			return
+		}
+		writeOK(w, &apiv1.Operation{Name: "op-foo", Done: true})
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint:  ts.URL,
+		DumpOut:       io.Discard,
+		RetryAttempts: 1,
+		RetryDelay:    time.Millisecond,
+	}
+	srv, _ := NewService(opts)
+
+	start := time.Now()
+	err := srv.(*serviceImpl).doRequest("POST", "/hosts", &apiv1.CreateHostRequest{}, nil)
+	duration := time.Since(start)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration < time.Second {
+		t.Errorf("expected to wait for 1s before retrying, waited %s", duration)
+	}
+	if len(bodies) != 2 || bodies[0] != bodies[1] {
+		t.Errorf("expected the same body to be sent twice, got: %q", bodies)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	srv := &serviceImpl{ServiceOptions: &ServiceOptions{RetryDelay: 5 * time.Second}}
+	tests := []struct {
+		code       int
+		retryAfter string
+		wantDelay  time.Duration
+		wantRetry  bool
+	}{
+		{http.StatusServiceUnavailable, "", 5 * time.Second, true},
+		{http.StatusServiceUnavailable, "2", 2 * time.Second, true},
+		{http.StatusTooManyRequests, "3", 3 * time.Second, true},
+		{http.StatusTooManyRequests, "", 0, false},
+		{http.StatusTooManyRequests, "3600", 0, false},
+		{http.StatusNotFound, "3", 0, false},
+	}
+	for _, tc := range tests {
+		res := &http.Response{StatusCode: tc.code, Header: http.Header{}}
+		if tc.retryAfter != "" {
+			res.Header.Set("Retry-After", tc.retryAfter)
+		}
+
+		delay, retry := srv.retryDelay(res)
+
+		if delay != tc.wantDelay || retry != tc.wantRetry {
+			t.Errorf("%d %q: expected %s %t, got %s %t", tc.code, tc.retryAfter, tc.wantDelay, tc.wantRetry, delay, retry)
+		}
+	}
+}
+
 func TestUploadFilesChunkSizeBytesIsZeroPanic(t *testing.T) {
 	defer func() {
 		if r := recover(); r == nil {
@@ -232,6 +299,35 @@ func TestUploadFilesExponentialBackoffReachedElapsedTime(t *testing.T) {
 	}
 }
 
+func TestListHostsWithStatus(t *testing.T) {
+	var query string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/hosts" {
+			panic("unexpected request: " + r.Method + " " + r.URL.Path)
+		}
+		query = r.URL.RawQuery
+		writeOK(w, &apiv1.ListHostsResponse{Items: []*apiv1.HostInstance{{Name: "foo"}}})
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint: ts.URL,
+		DumpOut:      io.Discard,
+	}
+	srv, _ := NewService(opts)
+
+	res, err := srv.ListHosts(ListHostsOpts{Status: apiv1.HostStatusStopped})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("status=STOPPED", query); diff != "" {
+		t.Errorf("query mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("foo", res.Items[0].Name); diff != "" {
+		t.Errorf("host name mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func TestDeleteHosts(t *testing.T) {
 	existingNames := map[string]struct{}{"bar": {}, "baz": {}}
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -265,6 +361,117 @@ func TestDeleteHosts(t *testing.T) {
 	}
 }
 
+func TestHostOperationByHandle(t *testing.T) {
+	var eps []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eps = append(eps, r.Method+" "+r.URL.Path)
+		writeOK(w, &apiv1.Operation{Name: "op-foo"})
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint: ts.URL,
+		Zone:         "us-central1-a",
+		DumpOut:      io.Discard,
+	}
+	srv, _ := NewService(opts)
+
+	if _, err := srv.StopHost("europe-west1-b~foo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.StopHost("bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"POST /hosts/europe-west1-b~foo/:stop",
+		"POST /zones/europe-west1-b/operations/op-foo/:wait",
+		"POST /zones/us-central1-a/hosts/bar/:stop",
+		"POST /zones/us-central1-a/operations/op-foo/:wait",
+	}
+	if diff := cmp.Diff(expected, eps); diff != "" {
+		t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestHostOperationsRequireZone(t *testing.T) {
+	var eps []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		eps = append(eps, r.Method+" "+r.URL.Path)
+		writeOK(w, &apiv1.Operation{Name: "op-foo"})
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint: ts.URL,
+		DumpOut:      io.Discard,
+	}
+	srv, _ := NewService(opts)
+
+	if _, err := srv.CreateHost(&apiv1.CreateHostRequest{}); !errors.Is(err, ErrZoneRequired) {
+		t.Errorf("expected zone required error, got: %v", err)
+	}
+	if _, err := srv.StopHost("foo"); err == nil {
+		t.Error("expected error stopping host without zone")
+	}
+	if _, err := srv.StopHost("europe-west1-b~foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"POST /hosts/europe-west1-b~foo/:stop",
+		"POST /zones/europe-west1-b/operations/op-foo/:wait",
+	}
+	if diff := cmp.Diff(expected, eps); diff != "" {
+		t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestApiCallErrorIncludesRequestID(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "req-123")
+		write(w, &apiv1.Error{Code: http.StatusNotFound, ErrorMsg: "not found"}, http.StatusNotFound)
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint: ts.URL,
+		Zone:         "us-central1-a",
+		DumpOut:      io.Discard,
+	}
+	srv, _ := NewService(opts)
+
+	_, err := srv.StopHost("foo")
+
+	expected := &ApiCallError{Code: http.StatusNotFound, ErrorMsg: "not found", RequestID: "req-123"}
+	if diff := cmp.Diff(expected, err); diff != "" {
+		t.Errorf("error mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("api call error 404: not found\n\nREQUEST ID: req-123", err.Error()); diff != "" {
+		t.Errorf("error message mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestAPITokenIsSentAsBearerToken(t *testing.T) {
+	var auth []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		writeOK(w, &apiv1.ListHostsResponse{})
+	}))
+	defer ts.Close()
+	opts := &ServiceOptions{
+		RootEndpoint: ts.URL,
+		APIToken:     "cotk_foo",
+		DumpOut:      io.Discard,
+	}
+	srv, _ := NewService(opts)
+
+	if _, err := srv.ListHosts(ListHostsOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"Bearer cotk_foo"}, auth); diff != "" {
+		t.Errorf("authorization headers mismatch (-want +got):\n%s", diff)
+	}
+}
+
 func createTempDir(t *testing.T) string {
 	dir, err := os.MkdirTemp("", "cvdrTest")
 	if err != nil {